@@ -3,12 +3,18 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
+	"github.com/dhemery/duffel/internal/config"
 	"github.com/dhemery/duffel/internal/file"
+	"github.com/dhemery/duffel/plan"
 )
 
 // FS is an [fs.FS] that implements all of the methods used by duffel.
@@ -17,23 +23,64 @@ type FS interface {
 	file.ActionFS
 }
 
+// An FSOpener opens the file system rooted at the named directory.
+type FSOpener func(dir string) (FS, error)
+
 // Execute performs the duffel operations requested by args.
-func Execute(args []string, fsys FS, cwd string, wout, werr io.Writer) {
+// Open opens the file system in which to perform the operations,
+// rooted at the directory that duffel treats as /.
+// Cwd is the absolute path to the working directory.
+// Sys describes the system on which to install packages.
+func Execute(args []string, open FSOpener, cwd string, sys plan.System, wout, werr io.Writer) {
 	opts, args, err := parseArgs(args, werr)
 	if err != nil {
 		fatalUsage(werr, err)
 	}
 
-	cmd, err := newCommand(opts, args, fsys, cwd[1:], wout, werr)
+	root, err := config.Expand(opts.root, sys.Env)
+	if err != nil {
+		fatalUsage(werr, fmt.Errorf("root %w", err))
+	}
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(cwd, root)
+	}
+	fsys, err := open(root)
+	if err != nil {
+		fatalUsage(werr, fmt.Errorf("root: %w", err))
+	}
+	if filepath.Clean(root) != optDefaultRoot {
+		// Install for the host and distro of the image at root,
+		// not those of the running system.
+		sys.Facts = sys.Facts.Rooted(fsys)
+	}
+
+	cmd, err := newCommand(opts, args, fsys, rootedPath(root, cwd), sys, wout, werr)
 	if err != nil {
 		fatalUsage(werr, err)
 	}
 
-	if err := cmd.execute(); err != nil {
+	// Interrupting or terminating duffel stops planning,
+	// or lets the running tasks finish and starts no more.
+	// A second signal kills duffel.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	if err := cmd.execute(ctx); err != nil {
 		fatal(werr, err)
 	}
 }
 
+// rootedPath returns the path to name from root, without a leading slash.
+// If name is outside root, rootedPath returns the path to root itself.
+func rootedPath(root, name string) string {
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
 func fatal(w io.Writer, e error) {
 	fmt.Fprintln(w, e.Error())
 	os.Exit(1)
@@ -0,0 +1,25 @@
+package cmd
+
+import "testing"
+
+func TestRootedPath(t *testing.T) {
+	tests := map[string]struct {
+		root string
+		name string
+		want string
+	}{
+		"system root":        {root: "/", name: "/home/user", want: "home/user"},
+		"system root itself": {root: "/", name: "/", want: ""},
+		"inside root":        {root: "/images/img", name: "/images/img/home/user", want: "home/user"},
+		"root itself":        {root: "/images/img", name: "/images/img", want: ""},
+		"outside root":       {root: "/images/img", name: "/home/user", want: ""},
+		"sibling of root":    {root: "/images/img", name: "/images/img2/home", want: ""},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			if got := rootedPath(test.root, test.name); got != test.want {
+				t.Errorf("rootedPath(%q, %q)=%q, want %q", test.root, test.name, got, test.want)
+			}
+		})
+	}
+}
@@ -1,26 +1,32 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"path"
 	"path/filepath"
+	"strings"
 
+	"github.com/dhemery/duffel/internal/config"
 	"github.com/dhemery/duffel/internal/file"
 	"github.com/dhemery/duffel/internal/log"
-	"github.com/dhemery/duffel/internal/plan"
+	"github.com/dhemery/duffel/plan"
 )
 
 // A planner creates a [plan.Plan].
 type planner interface {
-	// Plan creates a [plan.Plan].
-	Plan() (plan.Plan, error)
+	// PlanContext creates a [plan.Plan],
+	// and stops if ctx is done.
+	PlanContext(ctx context.Context) (plan.Plan, error)
 }
 
-// planFunc acts on a [plan.Plan].
-type planFunc func(p plan.Plan) error
+// planFunc acts on a [plan.Plan],
+// and stops if ctx is done.
+type planFunc func(ctx context.Context, p plan.Plan) error
 
 // A command creates a [plan.Plan] and acts on it.
 type command struct {
@@ -29,53 +35,207 @@ type command struct {
 }
 
 // execute creates a plan and acts on it.
-func (c command) execute() error {
-	plan, err := c.planner.Plan()
+// Once ctx is done, execute stops planning,
+// or stops acting on the plan.
+func (c command) execute(ctx context.Context) error {
+	plan, err := c.planner.PlanContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	return c.planFunc(plan)
+	return c.planFunc(ctx, plan)
 }
 
 // newCommand compiles a [command] that satisfes the goals described by args and opts.
-func newCommand(opts options, args []string, fsys FS, cwd string, wout, werr io.Writer) (command, error) {
-	target := fullValidPath(cwd, opts.target)
-	source := fullValidPath(cwd, opts.source)
+// If opts.physical is set, newCommand resolves any symlinks
+// in the paths to cwd, the targets, and the sources,
+// so that the planned links lead from physical targets to physical sources.
+func newCommand(opts options, args []string, fsys FS, cwd string, sys plan.System, wout, werr io.Writer) (command, error) {
+	cwd, err := physicalPath(fsys, fullValidPath(cwd, "."), opts.physical)
+	if err != nil {
+		return command{}, fmt.Errorf("working dir %w", err)
+	}
 
-	terr := validateDir(fsys, "target", target)
-	serr := validateSource(fsys, source)
-	if err := errors.Join(serr, terr); err != nil {
+	var errs []error
+	target, err := fullPhysicalPath(fsys, cwd, opts.target, sys.Env, opts.physical)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("target %w", err))
+	}
+	var sources []string
+	for _, s := range opts.sources {
+		source, err := fullPhysicalPath(fsys, cwd, s, sys.Env, opts.physical)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("source %w", err))
+			continue
+		}
+		sources = append(sources, source)
+	}
+	if err := errors.Join(errs...); err != nil {
 		return command{}, err
 	}
 
-	var errs []error
-	var goals []plan.DirGoal
-	for _, pkg := range args {
-		errs = append(errs, validatePackage(fsys, source, pkg))
-		goal := plan.InstallPackage(source, pkg)
-		goals = append(goals, goal)
+	for _, source := range sources {
+		errs = append(errs, validateSource(fsys, source))
+	}
+	errs = append(errs, validateDir(fsys, "target", target))
+	errs = append(errs, validatePatterns("include", opts.include))
+	errs = append(errs, validatePatterns("exclude", opts.exclude))
+	if opts.uninstall && opts.status {
+		errs = append(errs, fmt.Errorf("-uninstall and -status: %w: cannot be used together", fs.ErrInvalid))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return command{}, err
+	}
+
+	resolver := newResolver(fsys, cwd, sources)
+	resolver.physical = opts.physical
+	if opts.all {
+		errs = append(errs, resolver.resolveAll())
+	}
+	for _, arg := range args {
+		errs = append(errs, resolver.resolve(arg))
 	}
 
 	if err := errors.Join(errs...); err != nil {
 		return command{}, err
 	}
 
+	if opts.xdg {
+		sys.Paths = xdgPaths(sys.Env)
+	}
+
 	logger := log.Logger(werr, &opts.logLevel)
 
+	// Uninstall or report the status of only the named packages,
+	// not the packages they require.
+	pkgs := resolver.pkgs
+	newGoal := plan.InstallItem
+	switch {
+	case opts.uninstall:
+		newGoal = plan.UninstallItem
+		if !opts.force {
+			if err := checkRequired(fsys, pkgs, target, sys, opts.physical, logger); err != nil {
+				return command{}, err
+			}
+		}
+	case opts.status:
+		newGoal = plan.StatusItem
+	default:
+		pkgs, err = expandRequirements(fsys, pkgs)
+		if err != nil {
+			return command{}, err
+		}
+	}
+
+	var goals []plan.DirGoal
+	for _, p := range pkgs {
+		pkgTarget, err := packageTarget(fsys, p, target, sys.Env, opts.physical)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		goal := newGoal(p.source, p.pkg, p.item).
+			WithProfiles(resolver.profiles[p]).
+			WithFilter(opts.include, opts.exclude).
+			WithTarget(pkgTarget)
+		if position, ok := resolver.listed[p]; ok {
+			goal = goal.WithListed(position)
+		}
+		goals = append(goals, goal)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return command{}, err
+	}
+
 	var planFunc planFunc
-	if opts.dryRun {
-		planFunc = plan.Print(wout)
-	} else {
-		planFunc = plan.Execute(fsys, logger)
+	switch {
+	case opts.status:
+		printStatus := plan.PrintStatus(wout)
+		planFunc = func(_ context.Context, p plan.Plan) error {
+			return printStatus(p)
+		}
+	case opts.dryRun:
+		printPlan := plan.Print(wout)
+		planFunc = func(_ context.Context, p plan.Plan) error {
+			return printPlan(p)
+		}
+	default:
+		planFunc = plan.ExecuteContext(fsys, opts.jobs, logger)
+	}
+
+	planner := plan.NewPlanner(fsys, target, goals, sys, opts.jobs, logger)
+	if err := planner.Validate(); err != nil {
+		return command{}, err
 	}
 
 	return command{
-		planner:  plan.NewPlanner(fsys, target, goals, logger),
+		planner:  planner,
 		planFunc: planFunc,
 	}, nil
 }
 
+// checkRequired checks that no installed package requires
+// any package in pkgs, other than the packages in pkgs themselves.
+// A package is installed if uninstalling it would change its target tree.
+// An item in pkgs does not need checking,
+// because uninstalling an item leaves the rest of its package installed.
+func checkRequired(fsys FS, pkgs []pkgRef, target string, sys plan.System, physical bool, l *slog.Logger) error {
+	var errs []error
+	for _, p := range pkgs {
+		if p.item != "" {
+			continue
+		}
+		requirers, err := requiredBy(fsys, p, pkgs)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, r := range requirers {
+			installed, err := isInstalled(fsys, r, target, sys, physical, l)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if installed {
+				errs = append(errs, fmt.Errorf("package %s: %w: required by installed package %s; use -force to uninstall it anyway",
+					p.pkg, fs.ErrInvalid, r.pkg))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isInstalled reports whether uninstalling p
+// would change p's target tree.
+func isInstalled(fsys FS, p pkgRef, target string, sys plan.System, physical bool, l *slog.Logger) (bool, error) {
+	pkgTarget, err := packageTarget(fsys, p, target, sys.Env, physical)
+	if err != nil {
+		return false, err
+	}
+	goals := []plan.DirGoal{plan.UninstallPackage(p.source, p.pkg).WithTarget(pkgTarget)}
+	uninstall, err := plan.NewPlanner(fsys, target, goals, sys, 1, l).Plan()
+	if err != nil {
+		return false, fmt.Errorf("package %s: %w", p.pkg, err)
+	}
+	for _, tasks := range uninstall.Targets {
+		if len(tasks) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validatePatterns checks that each pattern is a valid [path.Match] pattern.
+func validatePatterns(desc string, patterns []string) error {
+	var errs []error
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s pattern %s: %w: %w", desc, pattern, fs.ErrInvalid, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // validateDir checks that the named file exists and is a directory.
 func validateDir(fsys fs.ReadLinkFS, desc, name string) error {
 	info, err := fsys.Lstat(name)
@@ -109,19 +269,93 @@ func validateSource(fsys fs.ReadLinkFS, source string) error {
 		return fmt.Errorf("source %s: %w", source, err)
 	}
 
+	if _, err := config.ReadSource(fsys, source); err != nil {
+		return fmt.Errorf("source %s: %w", source, err)
+	}
+
 	return nil
 }
 
-// validatePackage checks that pkg is a directory child of source.
-func validatePackage(fsys fs.ReadLinkFS, source, pkg string) error {
+// splitPackage splits name into the package it names in source
+// and the item within the package, if any.
+// The package is a directory that is not a group,
+// and is either a child of source or a child of a group in source.
+// Any components of name after the package name the item.
+// SplitPackage checks that the package and item exist.
+func splitPackage(fsys fs.ReadLinkFS, source, name string) (string, string, error) {
+	clean := path.Clean(name)
+	if name == "" || clean == "." || !fs.ValidPath(clean) {
+		return "", "", fmt.Errorf("package %s: %w: not in source (%s)",
+			name, fs.ErrInvalid, source)
+	}
+
+	// Each leading component that names a group
+	// is part of the package name.
+	var pkg string
+	rest := clean
+	for {
+		var dir string
+		var found bool
+		dir, rest, found = strings.Cut(rest, "/")
+		pkg = path.Join(pkg, dir)
+		if !found {
+			break
+		}
+		isGroup, err := file.IsGroup(fsys, path.Join(source, pkg))
+		if err != nil {
+			return "", "", fmt.Errorf("package %s: %w", name, err)
+		}
+		if !isGroup {
+			break
+		}
+	}
+
 	full := path.Join(source, pkg)
-	dir := path.Dir(full)
-	if dir != source {
-		return fmt.Errorf("package %s (%s): %w: not a child of source (%s)",
-			pkg, dir, fs.ErrInvalid, source)
+	if err := validateDir(fsys, "package", full); err != nil {
+		return "", "", err
+	}
+
+	isGroup, err := file.IsGroup(fsys, full)
+	if err != nil {
+		return "", "", fmt.Errorf("package %s: %w", name, err)
+	}
+	if isGroup {
+		return "", "", fmt.Errorf("package %s: %w: is a package group", name, fs.ErrInvalid)
 	}
 
-	return validateDir(fsys, "package", full)
+	if rest != "" {
+		if err := findItem(fsys, full, rest); err != nil {
+			return "", "", fmt.Errorf("package %s: item %s: %w", pkg, rest, err)
+		}
+	}
+
+	return pkg, rest, nil
+}
+
+// conditionSep separates the name of an item's variant
+// from the conditions under which to install it.
+const conditionSep = "##"
+
+// findItem checks that item exists in the package dir.
+// Each component of item may name a file
+// or the variants of a file, such as name##os.linux.
+// The planner chooses which variant to install.
+func findItem(fsys fs.ReadLinkFS, dir, item string) error {
+	first, rest, _ := strings.Cut(item, "/")
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name, _, _ := strings.Cut(e.Name(), conditionSep)
+		if e.Name() != first && name != first {
+			continue
+		}
+		if rest == "" || findItem(fsys, path.Join(dir, e.Name()), rest) == nil {
+			return nil
+		}
+	}
+	return &fs.PathError{Op: "find", Path: path.Join(dir, item), Err: fs.ErrNotExist}
 }
 
 // fullValidPath returns the relative path from / to name.
@@ -137,3 +371,47 @@ func fullValidPath(cwd, name string) string {
 	}
 	return path.Clean(name)
 }
+
+// fullExpandedPath expands the variables in name from env,
+// then returns the relative path from / to the result.
+// See [config.Expand] and [fullValidPath].
+func fullExpandedPath(cwd, name string, env map[string]string) (string, error) {
+	expanded, err := config.Expand(name, env)
+	if err != nil {
+		return "", err
+	}
+	return fullValidPath(cwd, expanded), nil
+}
+
+// fullPhysicalPath returns the full expanded path to name,
+// resolved to a physical path if physical is set.
+// See [fullExpandedPath] and [physicalPath].
+func fullPhysicalPath(fsys fs.ReadLinkFS, cwd, name string, env map[string]string, physical bool) (string, error) {
+	full, err := fullExpandedPath(cwd, name, env)
+	if err != nil {
+		return "", err
+	}
+	return physicalPath(fsys, full, physical)
+}
+
+// physicalPath returns the physical path to the named file if physical is set,
+// and otherwise returns name unchanged.
+// See [file.Physical].
+func physicalPath(fsys fs.ReadLinkFS, name string, physical bool) (string, error) {
+	if !physical {
+		return name, nil
+	}
+	return file.Physical(fsys, name)
+}
+
+// xdgPaths returns item path mappings that install
+// each package's .config items into the XDG config dir.
+// If env's XDG_CONFIG_HOME is unset, empty, or is ~/.config,
+// .config items need no mapping.
+func xdgPaths(env map[string]string) map[string]string {
+	dir := env["XDG_CONFIG_HOME"]
+	if dir == "" || path.Clean(dir) == path.Join(env["HOME"], ".config") {
+		return nil
+	}
+	return map[string]string{".config": "$XDG_CONFIG_HOME"}
+}
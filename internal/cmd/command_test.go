@@ -2,28 +2,35 @@ package cmd
 
 import (
 	"errors"
+	"io"
 	"io/fs"
 	"path"
+	"syscall"
 	"testing"
 
+	"github.com/dhemery/duffel/internal/config"
 	"github.com/dhemery/duffel/internal/errfs"
 	"github.com/dhemery/duffel/internal/file"
+	"github.com/dhemery/duffel/plan"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestValidate(t *testing.T) {
 	tests := []struct {
-		desc    string        // Description of the test.
-		files   []*errfs.File // Files on the file system.
-		opts    options       // Options passed to Compile.
-		args    []string      // Args passed to Compile.
-		cwd     string        // Current working directory passed to Compile.
-		wantErr error         // Error result from Compile.
-		skip    string        // Reason to skip this test.
+		desc    string            // Description of the test.
+		files   []*errfs.File     // Files on the file system.
+		opts    options           // Options passed to Compile.
+		args    []string          // Args passed to Compile.
+		cwd     string            // Current working directory passed to Compile.
+		env     map[string]string // Environment variables passed to Compile.
+		wantErr error             // Error result from Compile.
+		skip    string            // Reason to skip this test.
 	}{
 		{
 			desc:    "target does not exist",
 			files:   []*errfs.File{sourceDir("source")},
-			opts:    options{target: "target", source: "source"},
+			opts:    options{target: "target", sources: []string{"source"}},
 			wantErr: fs.ErrNotExist,
 		},
 		{
@@ -32,37 +39,75 @@ func TestValidate(t *testing.T) {
 				sourceDir("source"),
 				errfs.NewFile("target", 0o644),
 			},
-			opts:    options{target: "target", source: "source"},
+			opts:    options{target: "target", sources: []string{"source"}},
 			wantErr: fs.ErrInvalid,
 		},
 		{
 			desc:    "root target",
 			files:   []*errfs.File{sourceDir("source")},
-			opts:    options{target: "/", source: "source"},
+			opts:    options{target: "/", sources: []string{"source"}},
 			wantErr: nil, // Root target is okay.
 		},
 		{
 			desc:    "empty target",
 			files:   []*errfs.File{sourceDir("source")},
-			opts:    options{target: "", source: "source"},
+			opts:    options{target: "", sources: []string{"source"}},
 			wantErr: nil, // Empty target uses root.
 		},
+		{
+			desc:    "target refers to variable",
+			files:   []*errfs.File{sourceDir("source"), errfs.NewDir("home/user", 0o755)},
+			opts:    options{target: "$HOME", sources: []string{"source"}},
+			env:     map[string]string{"HOME": "/home/user"},
+			wantErr: nil,
+		},
+		{
+			desc:    "target refers to undefined variable",
+			files:   []*errfs.File{sourceDir("source"), errfs.NewDir("home/user", 0o755)},
+			opts:    options{target: "$HOME", sources: []string{"source"}},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc:    "source in home dir",
+			files:   []*errfs.File{sourceDir("home/user/source")},
+			opts:    options{sources: []string{"~/source"}},
+			env:     map[string]string{"HOME": "/home/user"},
+			wantErr: nil,
+		},
+		{
+			desc:    "source refers to undefined variable",
+			files:   []*errfs.File{sourceDir("home/user/source")},
+			opts:    options{sources: []string{"${DOTFILES}"}},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc:    "bad include pattern",
+			files:   []*errfs.File{sourceDir("source"), errfs.NewDir("target", 0o755)},
+			opts:    options{target: "target", sources: []string{"source"}, include: []string{"["}},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc:    "bad exclude pattern",
+			files:   []*errfs.File{sourceDir("source"), errfs.NewDir("target", 0o755)},
+			opts:    options{target: "target", sources: []string{"source"}, exclude: []string{"a/["}},
+			wantErr: fs.ErrInvalid,
+		},
 		{
 			desc:    "source does not exist",
 			files:   []*errfs.File{},
-			opts:    options{source: "source"},
+			opts:    options{sources: []string{"source"}},
 			wantErr: fs.ErrNotExist,
 		},
 		{
 			desc:    "source is not a dir",
 			files:   []*errfs.File{errfs.NewFile("source", 0o644)},
-			opts:    options{source: "source"},
+			opts:    options{sources: []string{"source"}},
 			wantErr: fs.ErrInvalid,
 		},
 		{
 			desc:    "source is not a duffel source",
 			files:   []*errfs.File{errfs.NewDir("source", 0o755)},
-			opts:    options{source: "source"},
+			opts:    options{sources: []string{"source"}},
 			wantErr: fs.ErrInvalid,
 		},
 		{
@@ -71,26 +116,26 @@ func TestValidate(t *testing.T) {
 				sourceDir("a/b/c/source"),
 				errfs.NewDir("a/b/c/source/sourceopt", 0o755),
 			},
-			opts:    options{source: "a/b/c/source/sourceopt"},
+			opts:    options{sources: []string{"a/b/c/source/sourceopt"}},
 			wantErr: fs.ErrInvalid,
 		},
 		{
 			desc:    "root source",
 			files:   []*errfs.File{sourceDir("")},
-			opts:    options{source: "/"},
+			opts:    options{sources: []string{"/"}},
 			wantErr: nil,
 		},
 		{
 			desc:    "empty source",
 			files:   []*errfs.File{sourceDir("a/b/c/cwd")},
 			cwd:     "a/b/c/cwd",
-			opts:    options{source: ""},
+			opts:    options{sources: []string{""}},
 			wantErr: nil,
 		},
 		{
 			desc:    "package does not exist",
 			files:   []*errfs.File{sourceDir("source")},
-			opts:    options{source: "source"},
+			opts:    options{sources: []string{"source"}},
 			args:    []string{"pkg"},
 			wantErr: fs.ErrNotExist,
 		},
@@ -100,21 +145,21 @@ func TestValidate(t *testing.T) {
 				sourceDir("source"),
 				errfs.NewFile("source/pkg", 0o644),
 			},
-			opts:    options{source: "source"},
+			opts:    options{sources: []string{"source"}},
 			args:    []string{"pkg"},
 			wantErr: fs.ErrInvalid,
 		},
 		{
 			desc:    "empty package",
 			files:   []*errfs.File{sourceDir("source")},
-			opts:    options{source: "source"},
+			opts:    options{sources: []string{"source"}},
 			args:    []string{""},
 			wantErr: fs.ErrInvalid,
 		},
 		{
 			desc:    "package is .",
 			files:   []*errfs.File{sourceDir("source")},
-			opts:    options{source: "source"},
+			opts:    options{sources: []string{"source"}},
 			args:    []string{"."},
 			wantErr: fs.ErrInvalid,
 		},
@@ -124,18 +169,487 @@ func TestValidate(t *testing.T) {
 				sourceDir("source"),
 				errfs.NewDir("pkg", 0o755),
 			},
-			opts:    options{source: "source"},
+			opts:    options{sources: []string{"source"}},
 			args:    []string{"../pkg"},
 			wantErr: fs.ErrInvalid,
 		},
 		{
-			desc: "package is deeper than child",
+			desc: "package in group",
+			files: []*errfs.File{
+				sourceDir("source"),
+				groupDir("source/group"),
+				errfs.NewDir("source/group/pkg", 0o755),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"group/pkg"},
+			wantErr: nil,
+		},
+		{
+			desc: "package in nested group",
+			files: []*errfs.File{
+				sourceDir("source"),
+				groupDir("source/group1"),
+				groupDir("source/group1/group2"),
+				errfs.NewDir("source/group1/group2/pkg", 0o755),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"group1/group2/pkg"},
+			wantErr: nil,
+		},
+		{
+			desc: "package is a group",
+			files: []*errfs.File{
+				sourceDir("source"),
+				groupDir("source/group"),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"group"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "item in package",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewDir("source/pkg1/pkg2", 0o755),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg1/pkg2"},
+			wantErr: nil,
+		},
+		{
+			desc: "item deep in package in group",
+			files: []*errfs.File{
+				sourceDir("source"),
+				groupDir("source/group"),
+				errfs.NewFile("source/group/pkg/item1/item2/item3", 0o644),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"group/pkg/item1/item2/item3"},
+			wantErr: nil,
+		},
+		{
+			desc: "item does not exist",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewDir("source/pkg", 0o755),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg/item"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "item with only variants",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewFile("source/pkg/.bashrc##os.linux", 0o644),
+				errfs.NewFile("source/pkg/.bashrc##os.darwin", 0o644),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg/.bashrc"},
+			wantErr: nil,
+		},
+		{
+			desc: "item in a variant dir",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewDir("source/pkg/.config##os.darwin/other", 0o755),
+				errfs.NewFile("source/pkg/.config##os.linux/app/settings", 0o644),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg/.config/app"},
+			wantErr: nil,
+		},
+		{
+			desc: "item in no variant dir",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewFile("source/pkg/.config##os.linux/app/settings", 0o644),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg/.config/other"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "item in missing package",
 			files: []*errfs.File{
 				sourceDir("source"),
 				errfs.NewDir("source/pkg1/pkg2", 0o755),
 			},
-			opts:    options{source: "source"},
+			opts:    options{sources: []string{"source"}},
 			args:    []string{"pk1/pkg2"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "package in one of several sources",
+			files: []*errfs.File{
+				sourceDir("source1"),
+				sourceDir("source2"),
+				errfs.NewDir("source2/pkg", 0o755),
+			},
+			opts:    options{sources: []string{"source1", "source2"}},
+			args:    []string{"pkg"},
+			wantErr: nil,
+		},
+		{
+			desc: "package in no source",
+			files: []*errfs.File{
+				sourceDir("source1"),
+				sourceDir("source2"),
+			},
+			opts:    options{sources: []string{"source1", "source2"}},
+			args:    []string{"pkg"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "package in several sources",
+			files: []*errfs.File{
+				sourceDir("source1"),
+				errfs.NewDir("source1/pkg", 0o755),
+				sourceDir("source2"),
+				errfs.NewDir("source2/pkg", 0o755),
+			},
+			opts:    options{sources: []string{"source1", "source2"}},
+			args:    []string{"pkg"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "source:pkg chooses among several sources",
+			files: []*errfs.File{
+				sourceDir("source1"),
+				errfs.NewDir("source1/pkg", 0o755),
+				sourceDir("source2"),
+				errfs.NewDir("source2/pkg", 0o755),
+			},
+			opts:    options{sources: []string{"source1", "source2"}},
+			args:    []string{"source2:pkg"},
+			wantErr: nil,
+		},
+		{
+			desc: "source:pkg names a source not given by option",
+			files: []*errfs.File{
+				sourceDir("source1"),
+				sourceDir("other"),
+				errfs.NewDir("other/pkg", 0o755),
+			},
+			opts:    options{sources: []string{"source1"}},
+			args:    []string{"other:pkg"},
+			wantErr: nil,
+		},
+		{
+			desc: "source:pkg names a dir that is not a source",
+			files: []*errfs.File{
+				sourceDir("source1"),
+				errfs.NewDir("other/pkg", 0o755),
+			},
+			opts:    options{sources: []string{"source1"}},
+			args:    []string{"other:pkg"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "source:pkg names a package not in the source",
+			files: []*errfs.File{
+				sourceDir("source1"),
+				errfs.NewDir("source1/pkg", 0o755),
+				sourceDir("source2"),
+			},
+			opts:    options{sources: []string{"source1", "source2"}},
+			args:    []string{"source2:pkg"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "package requires missing package",
+			files: []*errfs.File{
+				sourceDir("source"),
+				requires("pkg", "missing"),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "package requirement cycle",
+			files: []*errfs.File{
+				sourceDir("source"),
+				requires("pkg1", "pkg2"),
+				requires("pkg2", "pkg1"),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg1"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "uninstall package that an installed package requires",
+			files: []*errfs.File{
+				sourceDir("source"),
+				requires("nvim", "fonts"),
+				errfs.NewFile("source/nvim/.vimrc", 0o644),
+				errfs.NewFile("source/fonts/.fonts", 0o644),
+				errfs.NewLink("target/.vimrc", "../source/nvim/.vimrc"),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, uninstall: true},
+			args:    []string{"fonts"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "force uninstall of package that an installed package requires",
+			files: []*errfs.File{
+				sourceDir("source"),
+				requires("nvim", "fonts"),
+				errfs.NewFile("source/nvim/.vimrc", 0o644),
+				errfs.NewFile("source/fonts/.fonts", 0o644),
+				errfs.NewLink("target/.vimrc", "../source/nvim/.vimrc"),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, uninstall: true, force: true},
+			args:    []string{"fonts"},
+			wantErr: nil,
+		},
+		{
+			desc: "uninstall package that only an uninstalled package requires",
+			files: []*errfs.File{
+				sourceDir("source"),
+				requires("nvim", "fonts"),
+				errfs.NewFile("source/nvim/.vimrc", 0o644),
+				errfs.NewFile("source/fonts/.fonts", 0o644),
+				errfs.NewDir("target", 0o755),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, uninstall: true},
+			args:    []string{"fonts"},
+			wantErr: nil,
+		},
+		{
+			desc: "uninstall package along with the installed package that requires it",
+			files: []*errfs.File{
+				sourceDir("source"),
+				requires("nvim", "fonts"),
+				errfs.NewFile("source/nvim/.vimrc", 0o644),
+				errfs.NewFile("source/fonts/.fonts", 0o644),
+				errfs.NewLink("target/.vimrc", "../source/nvim/.vimrc"),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, uninstall: true},
+			args:    []string{"fonts", "nvim"},
+			wantErr: nil,
+		},
+		{
+			desc: "uninstall item of package that an installed package requires",
+			files: []*errfs.File{
+				sourceDir("source"),
+				requires("nvim", "fonts"),
+				errfs.NewFile("source/nvim/.vimrc", 0o644),
+				errfs.NewFile("source/fonts/.fonts", 0o644),
+				errfs.NewLink("target/.vimrc", "../source/nvim/.vimrc"),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, uninstall: true},
+			args:    []string{"fonts/.fonts"},
+			wantErr: nil,
+		},
+		{
+			desc: "uninstall does not check that required packages exist",
+			files: []*errfs.File{
+				sourceDir("source"),
+				requires("pkg", "missing"),
+				errfs.NewDir("target", 0o755),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, uninstall: true},
+			args:    []string{"pkg"},
+			wantErr: nil,
+		},
+		{
+			desc: "uninstall item deep in package",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewFile("source/nvim/.config/nvim/lua/init.lua", 0o644),
+				errfs.NewLink("target/.config", "../source/nvim/.config"),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, uninstall: true},
+			args:    []string{"nvim/.config/nvim/lua"},
+			wantErr: nil,
+		},
+		{
+			desc: "uninstall item does not exist",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewFile("source/nvim/.config/nvim/init.lua", 0o644),
+				errfs.NewDir("target", 0o755),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, uninstall: true},
+			args:    []string{"nvim/.config/nvim/lua"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "status of item deep in package",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewFile("source/nvim/.config/nvim/lua/init.lua", 0o644),
+				errfs.NewDir("target", 0o755),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, status: true},
+			args:    []string{"nvim/.config/nvim/lua"},
+			wantErr: nil,
+		},
+		{
+			desc: "uninstall and status",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewFile("source/nvim/.vimrc", 0o644),
+				errfs.NewDir("target", 0o755),
+			},
+			opts:    options{target: "target", sources: []string{"source"}, uninstall: true, status: true},
+			args:    []string{"nvim"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "profile",
+			files: []*errfs.File{
+				sourceConfig("source", `{"profiles": {"work": ["pkg1", "@base"], "base": ["pkg2"]}}`),
+				errfs.NewDir("source/pkg1", 0o755),
+				errfs.NewDir("source/pkg2", 0o755),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"@work"},
+			wantErr: nil,
+		},
+		{
+			desc:    "undefined profile",
+			files:   []*errfs.File{sourceDir("source")},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"@work"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "profile includes missing package",
+			files: []*errfs.File{
+				sourceConfig("source", `{"profiles": {"work": ["missing"]}}`),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"@work"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "profile includes undefined profile",
+			files: []*errfs.File{
+				sourceConfig("source", `{"profiles": {"work": ["@missing"]}}`),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"@work"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "profile cycle",
+			files: []*errfs.File{
+				sourceConfig("source", `{"profiles": {"a": ["@b"], "b": ["@a"]}}`),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"@a"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "profile in several sources",
+			files: []*errfs.File{
+				sourceConfig("source1", `{"profiles": {"work": []}}`),
+				sourceConfig("source2", `{"profiles": {"work": []}}`),
+			},
+			opts:    options{sources: []string{"source1", "source2"}},
+			args:    []string{"@work"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "source:@profile chooses among several sources",
+			files: []*errfs.File{
+				sourceConfig("source1", `{"profiles": {"work": []}}`),
+				sourceConfig("source2", `{"profiles": {"work": []}}`),
+			},
+			opts:    options{sources: []string{"source1", "source2"}},
+			args:    []string{"source2:@work"},
+			wantErr: nil,
+		},
+		{
+			desc: "package target",
+			files: []*errfs.File{
+				sourceDir("source"),
+				packageConfig("pkg", `{"target": "${ETC}/xdg"}`),
+				errfs.NewDir("etc/xdg", 0o755),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg"},
+			env:     map[string]string{"ETC": "/etc"},
+			wantErr: nil,
+		},
+		{
+			desc: "package target refers to undefined variable",
+			files: []*errfs.File{
+				sourceDir("source"),
+				packageConfig("pkg", `{"target": "$NO_SUCH_VAR/xdg"}`),
+				errfs.NewDir("etc/xdg", 0o755),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "package target does not exist",
+			files: []*errfs.File{
+				sourceDir("source"),
+				packageConfig("pkg", `{"target": "/etc/xdg"}`),
+			},
+			opts:    options{sources: []string{"source"}},
+			args:    []string{"pkg"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc:    "target is source",
+			files:   []*errfs.File{sourceDir("source"), errfs.NewDir("source/pkg", 0o755)},
+			opts:    options{target: "source", sources: []string{"source"}},
+			args:    []string{"pkg"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "target links into package",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewDir("source/pkg/dir", 0o755),
+				errfs.NewLink("target", "source/pkg/dir"),
+			},
+			opts:    options{target: "target", sources: []string{"source"}},
+			args:    []string{"pkg"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "package installs item into source",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewFile("source/pkg/source/item", 0o644),
+			},
+			opts:    options{target: "/", sources: []string{"source"}},
+			args:    []string{"pkg"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "physical paths through symlinked ancestors",
+			files: []*errfs.File{
+				sourceDir("var/home/user/source"),
+				errfs.NewDir("var/home/user/source/pkg", 0o755),
+				errfs.NewLink("home", "var/home"),
+			},
+			opts:    options{target: "..", sources: []string{"."}, physical: true},
+			args:    []string{"pkg"},
+			cwd:     "/home/user/source",
+			wantErr: nil,
+		},
+		{
+			desc: "physical path with link loop",
+			files: []*errfs.File{
+				sourceDir("source"),
+				errfs.NewLink("loop", "loop"),
+			},
+			opts:    options{target: "loop/target", sources: []string{"source"}, physical: true},
+			wantErr: syscall.ELOOP,
+		},
+		{
+			desc: "malformed source config",
+			files: []*errfs.File{
+				sourceConfig("source", `{"profiles": `),
+			},
+			opts:    options{sources: []string{"source"}},
 			wantErr: fs.ErrInvalid,
 		},
 	}
@@ -150,7 +664,7 @@ func TestValidate(t *testing.T) {
 				errfs.Add(testfs, file)
 			}
 
-			_, err := newCommand(test.opts, test.args, testfs, test.cwd, nil, nil)
+			_, err := newCommand(test.opts, test.args, testfs, test.cwd, plan.System{Env: test.env}, nil, io.Discard)
 
 			if !errors.Is(err, test.wantErr) {
 				t.Errorf("error result:\n got: %v\nwant: %v", err, test.wantErr)
@@ -159,6 +673,38 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestXDGPaths(t *testing.T) {
+	tests := map[string]struct {
+		env  map[string]string
+		want map[string]string
+	}{
+		"unset": {
+			env:  map[string]string{"HOME": "/home/user"},
+			want: nil,
+		},
+		"empty": {
+			env:  map[string]string{"HOME": "/home/user", "XDG_CONFIG_HOME": ""},
+			want: nil,
+		},
+		"default dir": {
+			env:  map[string]string{"HOME": "/home/user", "XDG_CONFIG_HOME": "/home/user/.config/"},
+			want: nil,
+		},
+		"other dir": {
+			env:  map[string]string{"HOME": "/home/user", "XDG_CONFIG_HOME": "/home/user/.xdg"},
+			want: map[string]string{".config": "$XDG_CONFIG_HOME"},
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			got := xdgPaths(test.env)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("xdgPaths():\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestFullValidPath(t *testing.T) {
 	tests := []struct {
 		desc     string
@@ -223,3 +769,18 @@ func TestFullValidPath(t *testing.T) {
 func sourceDir(dir string) *errfs.File {
 	return errfs.NewFile(path.Join(dir, file.SourceMarkerFile), 0o644)
 }
+
+// groupDir returns a group marker file in dir.
+func groupDir(dir string) *errfs.File {
+	return errfs.NewFile(path.Join(dir, file.GroupMarkerFile), 0o644)
+}
+
+// sourceConfig returns a source marker file in dir that contains config.
+func sourceConfig(dir, config string) *errfs.File {
+	return errfs.NewFileData(path.Join(dir, file.SourceMarkerFile), 0o644, []byte(config))
+}
+
+// packageConfig returns a config file with the given data for pkg in source.
+func packageConfig(pkg, data string) *errfs.File {
+	return errfs.NewFileData(path.Join("source", pkg, config.PackageFile), 0o644, []byte(data))
+}
@@ -5,44 +5,101 @@ import (
 	"flag"
 	"io"
 	"log/slog"
+	"strconv"
 	"strings"
 )
 
 // options provides the set of options parsed from the command arguments.
 type options struct {
-	source   string
-	target   string
-	dryRun   bool
-	logLevel slog.Level
+	root      string
+	sources   []string
+	target    string
+	all       bool
+	include   []string
+	exclude   []string
+	dryRun    bool
+	uninstall bool
+	force     bool
+	status    bool
+	xdg       bool
+	physical  bool
+	jobs      int
+	logLevel  slog.Level
 }
 
 var (
-	optDefaultSource   = "."
-	optDefaultTarget   = ".."
-	optDefaultDryRu    = false
-	optDefaultLogLevel = slog.LevelError
-	errLogLevel        = errors.New("must be one of none, error, warn, info, debug")
+	optDefaultRoot      = "/"
+	optDefaultSource    = "."
+	optDefaultTarget    = ".."
+	optDefaultAll       = false
+	optDefaultDryRu     = false
+	optDefaultUninstall = false
+	optDefaultForce     = false
+	optDefaultStatus    = false
+	optDefaultXDG       = false
+	optDefaultPhysical  = true
+	optDefaultJobs      = 1
+	optDefaultLogLevel  = slog.LevelError
+	errLogLevel         = errors.New("must be one of none, error, warn, info, debug")
+	errJobs             = errors.New("must be a number at least 1")
 )
 
 // parseArgs returns the [options] parsed from args.
 // The []string result holds the non-flag args.
 func parseArgs(args []string, werr io.Writer) (options, []string, error) {
-	opts := options{logLevel: optDefaultLogLevel}
+	opts := options{jobs: optDefaultJobs, logLevel: optDefaultLogLevel}
+	jobsOpt := &jobsValue{&opts.jobs}
 	logLevelOpt := &logLevelValue{&opts.logLevel}
+	sourcesOpt := &stringsValue{&opts.sources}
+	includeOpt := &stringsValue{&opts.include}
+	excludeOpt := &stringsValue{&opts.exclude}
 
 	flags := flag.NewFlagSet("duffel", flag.ContinueOnError)
 	flags.SetOutput(werr)
 
+	flags.BoolVar(&opts.all, "a", optDefaultAll, "Install all packages in each source")
+	flags.Var(jobsOpt, "j", "Read up to `n` dirs or target states and execute up to n independent tasks concurrently")
+	flags.Var(excludeOpt, "exclude", "Do not install package items that match `pattern` (repeatable)")
+	flags.BoolVar(&opts.force, "force", optDefaultForce, "Uninstall packages even if installed packages require them")
+	flags.Var(includeOpt, "include", "Install only package items that match `pattern` (repeatable)")
 	flags.BoolVar(&opts.dryRun, "n", optDefaultDryRu, "Print planned actions without executing them")
 	flags.Var(logLevelOpt, "log", "Log `level`")
-	flags.StringVar(&opts.source, "source", optDefaultSource, "The source `dir`")
+	flags.BoolVar(&opts.physical, "physical", optDefaultPhysical, "Resolve symlinks in the source, target, and working dir paths")
+	flags.StringVar(&opts.root, "root", optDefaultRoot, "Treat `dir` as / for every path")
+	flags.Var(sourcesOpt, "source", "A source `dir` (repeatable, default \""+optDefaultSource+"\")")
+	flags.BoolVar(&opts.status, "status", optDefaultStatus, "Print the status of each package item instead of installing it")
 	flags.StringVar(&opts.target, "target", optDefaultTarget, "The target `dir`")
+	flags.BoolVar(&opts.uninstall, "uninstall", optDefaultUninstall, "Uninstall the packages instead of installing them")
+	flags.BoolVar(&opts.xdg, "xdg", optDefaultXDG, "Install package .config items into $XDG_CONFIG_HOME")
 
 	err := flags.Parse(args)
 
+	if len(opts.sources) == 0 {
+		opts.sources = []string{optDefaultSource}
+	}
+
 	return opts, flags.Args(), err
 }
 
+// stringsValue is a list of strings, one for each use of an option.
+type stringsValue struct {
+	Values *[]string
+}
+
+// String implements [flag.Value].
+func (v *stringsValue) String() string {
+	if v.Values == nil {
+		return ""
+	}
+	return strings.Join(*v.Values, ",")
+}
+
+// Set implements [flag.Value].
+func (v *stringsValue) Set(value string) error {
+	*v.Values = append(*v.Values, value)
+	return nil
+}
+
 // logLevelValue is the minimum severity level for duffel to log.
 type logLevelValue struct {
 	Level *slog.Level
@@ -74,3 +131,27 @@ func (v *logLevelValue) Set(name string) error {
 	}
 	return nil
 }
+
+// jobsValue is the maximum number of dirs or target states to read
+// or tasks to execute concurrently.
+type jobsValue struct {
+	Jobs *int
+}
+
+// String implements [flag.Value].
+func (v *jobsValue) String() string {
+	if v.Jobs == nil {
+		return "<nil>"
+	}
+	return strconv.Itoa(*v.Jobs)
+}
+
+// Set implements [flag.Value].
+func (v *jobsValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return errJobs
+	}
+	*v.Jobs = n
+	return nil
+}
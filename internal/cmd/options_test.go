@@ -23,15 +23,50 @@ func TestOptions(t *testing.T) {
 			desc: "default",
 			args: []string{},
 			wantOpts: checkOpts(
-				checkSource("."),
+				checkRoot("/"),
+				checkSources("."),
 				checkTarget(".."),
+				checkAll(false),
+				checkInclude(),
+				checkExclude(),
 				checkDryRun(false),
+				checkUninstall(false),
+				checkForce(false),
+				checkStatus(false),
+				checkXDG(false),
+				checkPhysical(true),
+				checkJobs(1),
 				checkLogLevel(slog.LevelError)),
 		},
+		{
+			desc:     "all",
+			args:     []string{"-a"},
+			wantOpts: checkAll(true),
+		},
+		{
+			desc:     "root",
+			args:     []string{"-root", "my-root"},
+			wantOpts: checkRoot("my-root"),
+		},
 		{
 			desc:     "source",
 			args:     []string{"-source", "my-source"},
-			wantOpts: checkSource("my-source"),
+			wantOpts: checkSources("my-source"),
+		},
+		{
+			desc:     "multiple sources",
+			args:     []string{"-source", "source1", "-source", "source2", "-source", "source3"},
+			wantOpts: checkSources("source1", "source2", "source3"),
+		},
+		{
+			desc:     "exclude",
+			args:     []string{"-exclude", ".config/gtk-*", "-exclude", "*.bak"},
+			wantOpts: checkExclude(".config/gtk-*", "*.bak"),
+		},
+		{
+			desc:     "include",
+			args:     []string{"-include", ".config/nvim", "-include", ".local"},
+			wantOpts: checkInclude(".config/nvim", ".local"),
 		},
 		{
 			desc:     "target",
@@ -43,6 +78,36 @@ func TestOptions(t *testing.T) {
 			args:     []string{"-n"},
 			wantOpts: checkDryRun(true),
 		},
+		{
+			desc:     "uninstall",
+			args:     []string{"-uninstall"},
+			wantOpts: checkUninstall(true),
+		},
+		{
+			desc:     "force",
+			args:     []string{"-force"},
+			wantOpts: checkForce(true),
+		},
+		{
+			desc:     "status",
+			args:     []string{"-status"},
+			wantOpts: checkStatus(true),
+		},
+		{
+			desc:     "xdg",
+			args:     []string{"-xdg"},
+			wantOpts: checkXDG(true),
+		},
+		{
+			desc:     "jobs",
+			args:     []string{"-j", "8"},
+			wantOpts: checkJobs(8),
+		},
+		{
+			desc:     "no physical",
+			args:     []string{"-physical=false"},
+			wantOpts: checkPhysical(false),
+		},
 		{
 			desc:     "log level none",
 			args:     []string{"-log", "none"},
@@ -72,7 +137,7 @@ func TestOptions(t *testing.T) {
 			desc: "positional args",
 			args: []string{"positional1", "positional2", "positional3"},
 			wantOpts: checkOpts(
-				checkSource("."),
+				checkSources("."),
 				checkTarget(".."),
 				checkDryRun(false),
 				checkLogLevel(slog.LevelError)),
@@ -91,7 +156,7 @@ func TestOptions(t *testing.T) {
 				checkDryRun(true),
 				checkLogLevel(slog.LevelWarn),
 				checkTarget("my-target"),
-				checkSource("my-source"),
+				checkSources("my-source"),
 			),
 			wantArgs: []string{"positional1", "positional2", "positional3"},
 		},
@@ -101,6 +166,18 @@ func TestOptions(t *testing.T) {
 			wantErr:    cmpopts.AnyError,
 			wantErrOut: "bad-log-level",
 		},
+		{
+			desc:       "zero jobs",
+			args:       []string{"-j", "0"},
+			wantErr:    cmpopts.AnyError,
+			wantErrOut: errJobs.Error(),
+		},
+		{
+			desc:       "jobs not a number",
+			args:       []string{"-j", "many"},
+			wantErr:    cmpopts.AnyError,
+			wantErrOut: errJobs.Error(),
+		},
 		{
 			desc:       "unknown option",
 			args:       []string{"-bad-option"},
@@ -144,10 +221,26 @@ func checkOpts(funcs ...checkOptsFunc) checkOptsFunc {
 	}
 }
 
-func checkSource(want string) checkOptsFunc {
+func checkSources(want ...string) checkOptsFunc {
 	return func(t *testing.T, o options) {
-		if o.source != want {
-			t.Errorf("source: got %s, want %s", o.source, want)
+		if diff := cmp.Diff(want, o.sources); diff != "" {
+			t.Errorf("sources:\n%s", diff)
+		}
+	}
+}
+
+func checkInclude(want ...string) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if diff := cmp.Diff(want, o.include, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("include:\n%s", diff)
+		}
+	}
+}
+
+func checkExclude(want ...string) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if diff := cmp.Diff(want, o.exclude, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("exclude:\n%s", diff)
 		}
 	}
 }
@@ -160,6 +253,14 @@ func checkTarget(want string) checkOptsFunc {
 	}
 }
 
+func checkAll(want bool) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if o.all != want {
+			t.Errorf("all: got %t want %t", o.all, want)
+		}
+	}
+}
+
 func checkDryRun(want bool) checkOptsFunc {
 	return func(t *testing.T, o options) {
 		if o.dryRun != want {
@@ -168,6 +269,62 @@ func checkDryRun(want bool) checkOptsFunc {
 	}
 }
 
+func checkRoot(want string) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if o.root != want {
+			t.Errorf("root: got %q, want %q", o.root, want)
+		}
+	}
+}
+
+func checkUninstall(want bool) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if o.uninstall != want {
+			t.Errorf("uninstall: got %t want %t", o.uninstall, want)
+		}
+	}
+}
+
+func checkForce(want bool) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if o.force != want {
+			t.Errorf("force: got %t want %t", o.force, want)
+		}
+	}
+}
+
+func checkStatus(want bool) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if o.status != want {
+			t.Errorf("status: got %t want %t", o.status, want)
+		}
+	}
+}
+
+func checkXDG(want bool) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if o.xdg != want {
+			t.Errorf("xdg: got %t want %t", o.xdg, want)
+		}
+	}
+}
+
+func checkJobs(want int) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if o.jobs != want {
+			t.Errorf("jobs: got %d want %d", o.jobs, want)
+		}
+	}
+}
+
+func checkPhysical(want bool) checkOptsFunc {
+	return func(t *testing.T, o options) {
+		if o.physical != want {
+			t.Errorf("physical: got %t want %t", o.physical, want)
+		}
+	}
+}
+
 func checkLogLevel(want slog.Level) checkOptsFunc {
 	return func(t *testing.T, o options) {
 		if o.logLevel != want {
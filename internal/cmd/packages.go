@@ -0,0 +1,402 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/dhemery/duffel/internal/config"
+	"github.com/dhemery/duffel/internal/file"
+)
+
+// A pkgRef identifies a package in a source,
+// or an item in a package.
+type pkgRef struct {
+	source string // The full path to the source directory.
+	pkg    string // The name of the package.
+	item   string // The path from the package directory to the item, or empty.
+}
+
+// dir returns the full path to the package directory.
+func (p pkgRef) dir() string {
+	return path.Join(p.source, p.pkg)
+}
+
+// packageTarget returns the full path to the target dir
+// set by p's package configuration,
+// or "" if the configuration sets no target.
+// Variables in the configured target are expanded from env.
+// See [config.Expand].
+// A relative configured target is relative to the command's target.
+// If physical is set, the result is a physical path.
+// See [physicalPath].
+func packageTarget(fsys fs.ReadLinkFS, p pkgRef, target string, env map[string]string, physical bool) (string, error) {
+	conf, err := config.ReadPackage(fsys, p.dir())
+	if err != nil {
+		return "", fmt.Errorf("package %s: %w", p.pkg, err)
+	}
+	if conf.Target == "" {
+		return "", nil
+	}
+
+	pkgTarget, err := fullPhysicalPath(fsys, target, conf.Target, env, physical)
+	if err != nil {
+		return "", fmt.Errorf("package %s: target %w", p.pkg, err)
+	}
+	if err := validateDir(fsys, "package "+p.pkg+" target", pkgTarget); err != nil {
+		return "", err
+	}
+	return pkgTarget, nil
+}
+
+// newResolver returns a [resolver] that resolves packages
+// in the given sources.
+// Cwd is the directory against which to resolve relative source names in args.
+func newResolver(fsys fs.ReadLinkFS, cwd string, sources []string) *resolver {
+	return &resolver{
+		fsys:     fsys,
+		cwd:      cwd,
+		sources:  sources,
+		profiles: map[pkgRef][]string{},
+		listed:   map[pkgRef]int{},
+	}
+}
+
+// A resolver resolves command arguments to packages.
+type resolver struct {
+	fsys     fs.ReadLinkFS
+	cwd      string
+	sources  []string            // The full paths to the sources given by options.
+	physical bool                // Whether to resolve source names to physical paths.
+	pkgs     []pkgRef            // The resolved packages, in argument order.
+	profiles map[pkgRef][]string // The chain of profiles that introduced each package.
+	listed   map[pkgRef]int      // The position of each package named explicitly by an argument.
+}
+
+// resolve adds the packages named by arg.
+// Arg is either a package name or a profile name prefixed by @.
+// If arg has the form source:name,
+// the package or profile must be in the named source.
+// Otherwise the package or profile must be in exactly one of r's sources.
+func (r *resolver) resolve(arg string) error {
+	sources := r.sources
+	name := arg
+	if dir, n, found := strings.Cut(arg, ":"); found {
+		source, err := physicalPath(r.fsys, fullValidPath(r.cwd, dir), r.physical)
+		if err != nil {
+			return fmt.Errorf("%s: source %w", arg, err)
+		}
+		if !slices.Contains(sources, source) {
+			if err := validateSource(r.fsys, source); err != nil {
+				return fmt.Errorf("%s: %w", arg, err)
+			}
+		}
+		sources = []string{source}
+		name = n
+	}
+
+	if profile, ok := strings.CutPrefix(name, "@"); ok {
+		return r.resolveProfile(sources, profile)
+	}
+	if isPattern(name) {
+		return r.resolvePattern(sources, name)
+	}
+	return r.resolvePackage(sources, name)
+}
+
+// resolveAll adds every package in r's sources.
+// Hidden packages and packages excluded by the source configuration are skipped.
+func (r *resolver) resolveAll() error {
+	var errs []error
+	for _, source := range r.sources {
+		pkgs, err := listPackages(r.fsys, source)
+		errs = append(errs, err)
+		for _, pkg := range pkgs {
+			r.add(pkgRef{source, pkg, ""}, nil)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resolvePattern adds each package in sources
+// whose name matches pattern.
+// The pattern syntax is that of [path.Match].
+// Hidden packages and packages excluded by the source configuration are skipped.
+func (r *resolver) resolvePattern(sources []string, pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("package pattern %s: %w: %w", pattern, fs.ErrInvalid, err)
+	}
+
+	var matched bool
+	var errs []error
+	for _, source := range sources {
+		pkgs, err := listPackages(r.fsys, source)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, pkg := range pkgs {
+			if ok, _ := path.Match(pattern, pkg); ok {
+				r.add(pkgRef{source, pkg, ""}, nil)
+				matched = true
+			}
+		}
+	}
+
+	if !matched && len(errs) == 0 {
+		errs = append(errs, fmt.Errorf("package pattern %s: %w: matches no package in %s",
+			pattern, fs.ErrNotExist, strings.Join(sources, ", ")))
+	}
+	return errors.Join(errs...)
+}
+
+// listPackages returns the names of the packages in source,
+// including the packages in groups.
+// Hidden directories and packages excluded by the source configuration are skipped.
+func listPackages(fsys fs.ReadLinkFS, source string) ([]string, error) {
+	conf, err := config.ReadSource(fsys, source)
+	if err != nil {
+		return nil, fmt.Errorf("source %s: %w", source, err)
+	}
+
+	return appendPackages(nil, fsys, source, ".", conf)
+}
+
+// appendPackages appends the names of the packages in the group dir of source to pkgs.
+// Dir "." is the source itself.
+func appendPackages(pkgs []string, fsys fs.ReadLinkFS, source, dir string, conf config.Source) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, path.Join(source, dir))
+	if err != nil {
+		return pkgs, fmt.Errorf("source %s: %w", source, err)
+	}
+
+	for _, e := range entries {
+		name := path.Join(dir, e.Name())
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") || conf.Excludes(name) {
+			continue
+		}
+
+		isGroup, err := file.IsGroup(fsys, path.Join(source, name))
+		if err != nil {
+			return pkgs, fmt.Errorf("source %s: %w", source, err)
+		}
+		if !isGroup {
+			pkgs = append(pkgs, name)
+			continue
+		}
+
+		pkgs, err = appendPackages(pkgs, fsys, source, name, conf)
+		if err != nil {
+			return pkgs, err
+		}
+	}
+	return pkgs, nil
+}
+
+// isPattern reports whether name contains any of the special characters
+// recognized by [path.Match].
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
+
+// resolvePackage adds the package or package item named by name,
+// which must be in exactly one of sources.
+func (r *resolver) resolvePackage(sources []string, name string) error {
+	var found []pkgRef
+	var errs []error
+	for _, source := range sources {
+		pkg, item, err := splitPackage(r.fsys, source, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		found = append(found, pkgRef{source, pkg, item})
+	}
+
+	switch len(found) {
+	case 0:
+		return errors.Join(errs...)
+	case 1:
+		r.add(found[0], nil)
+		r.list(found[0])
+		return nil
+	}
+	var dirs []string
+	for _, p := range found {
+		dirs = append(dirs, p.source)
+	}
+	return fmt.Errorf("package %s: %w: in multiple sources (%s); use source:%s to choose one",
+		name, fs.ErrInvalid, strings.Join(dirs, ", "), name)
+}
+
+// resolveProfile adds the packages in the named profile,
+// which must be defined in exactly one of sources.
+func (r *resolver) resolveProfile(sources []string, name string) error {
+	var found []string
+	var profiles map[string][]string
+	var errs []error
+	for _, source := range sources {
+		conf, err := config.ReadSource(r.fsys, source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("source %s: %w", source, err))
+			continue
+		}
+		if _, ok := conf.Profiles[name]; ok {
+			found = append(found, source)
+			profiles = conf.Profiles
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		errs = append(errs, fmt.Errorf("profile @%s: %w: not defined in any source (%s)",
+			name, fs.ErrNotExist, strings.Join(sources, ", ")))
+		return errors.Join(errs...)
+	case 1:
+		return r.expandProfile(found[0], profiles, []string{"@" + name})
+	}
+	return fmt.Errorf("profile @%s: %w: defined in multiple sources (%s); use source:@%s to choose one",
+		name, fs.ErrInvalid, strings.Join(found, ", "), name)
+}
+
+// expandProfile adds the packages in the last profile in chain.
+// Chain lists the profile and the profiles that include it.
+func (r *resolver) expandProfile(source string, profiles map[string][]string, chain []string) error {
+	profile := chain[len(chain)-1]
+	var errs []error
+	for _, member := range profiles[profile[1:]] {
+		if !strings.HasPrefix(member, "@") {
+			pkg, item, err := splitPackage(r.fsys, source, member)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("profile %s: %w", profile, err))
+				continue
+			}
+			r.add(pkgRef{source, pkg, item}, chain)
+			continue
+		}
+
+		if slices.Contains(chain, member) {
+			errs = append(errs, fmt.Errorf("profile %s: %w: profile cycle %s -> %s",
+				profile, fs.ErrInvalid, strings.Join(chain, " -> "), member))
+			continue
+		}
+		if _, ok := profiles[member[1:]]; !ok {
+			errs = append(errs, fmt.Errorf("profile %s: %w: includes undefined profile %s",
+				profile, fs.ErrNotExist, member))
+			continue
+		}
+		errs = append(errs, r.expandProfile(source, profiles, append(slices.Clip(chain), member)))
+	}
+	return errors.Join(errs...)
+}
+
+// add adds p to r's packages.
+// Profiles is the chain of profiles that introduced p, if any.
+// If p was already added, add does nothing.
+func (r *resolver) add(p pkgRef, profiles []string) {
+	if slices.Contains(r.pkgs, p) {
+		return
+	}
+	r.pkgs = append(r.pkgs, p)
+	if len(profiles) > 0 {
+		r.profiles[p] = profiles
+	}
+}
+
+// list records that an argument named p explicitly.
+// If an earlier argument named p, list does nothing.
+func (r *resolver) list(p pkgRef) {
+	if _, ok := r.listed[p]; !ok {
+		r.listed[p] = len(r.listed)
+	}
+}
+
+// requiredBy returns the packages in p's source
+// that require p's package or an item in it,
+// other than the packages in except.
+func requiredBy(fsys fs.ReadLinkFS, p pkgRef, except []pkgRef) ([]pkgRef, error) {
+	names, err := listPackages(fsys, p.source)
+	if err != nil {
+		return nil, err
+	}
+	var requirers []pkgRef
+	for _, name := range names {
+		r := pkgRef{p.source, name, ""}
+		if name == p.pkg || slices.Contains(except, r) {
+			continue
+		}
+		conf, err := config.ReadPackage(fsys, r.dir())
+		if err != nil {
+			return nil, fmt.Errorf("package %s: %w", name, err)
+		}
+		for _, req := range conf.Requires {
+			pkg, _, err := splitPackage(fsys, p.source, req)
+			if err == nil && pkg == p.pkg {
+				requirers = append(requirers, r)
+				break
+			}
+		}
+	}
+	return requirers, nil
+}
+
+// expandRequirements returns pkgs along with the packages they require.
+// Each package appears once,
+// after every package that it requires.
+func expandRequirements(fsys fs.ReadLinkFS, pkgs []pkgRef) ([]pkgRef, error) {
+	e := expander{fsys: fsys, done: map[pkgRef]bool{}}
+	var errs []error
+	for _, p := range pkgs {
+		errs = append(errs, e.expand(p))
+	}
+	return e.expanded, errors.Join(errs...)
+}
+
+// An expander expands packages to include the packages they require.
+type expander struct {
+	fsys     fs.ReadLinkFS
+	done     map[pkgRef]bool // The packages already expanded.
+	visiting []pkgRef        // The chain of packages being expanded.
+	expanded []pkgRef        // The expanded packages, in dependency order.
+}
+
+// expand adds p's requirements to e, then adds p.
+func (e *expander) expand(p pkgRef) error {
+	if e.done[p] {
+		return nil
+	}
+
+	if i := slices.Index(e.visiting, p); i >= 0 {
+		var names []string
+		for _, v := range e.visiting[i:] {
+			names = append(names, v.pkg)
+		}
+		names = append(names, p.pkg)
+		return fmt.Errorf("package %s: %w: requirement cycle %s",
+			p.pkg, fs.ErrInvalid, strings.Join(names, " -> "))
+	}
+
+	conf, err := config.ReadPackage(e.fsys, p.dir())
+	if err != nil {
+		return fmt.Errorf("package %s: %w", p.pkg, err)
+	}
+
+	e.visiting = append(e.visiting, p)
+	var errs []error
+	for _, req := range conf.Requires {
+		pkg, item, err := splitPackage(e.fsys, p.source, req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("package %s requires %s: %w", p.pkg, req, err))
+			continue
+		}
+		errs = append(errs, e.expand(pkgRef{p.source, pkg, item}))
+	}
+	e.visiting = e.visiting[:len(e.visiting)-1]
+
+	e.done[p] = true
+	e.expanded = append(e.expanded, p)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/dhemery/duffel/internal/config"
+	"github.com/dhemery/duffel/internal/errfs"
+)
+
+func TestResolveProfiles(t *testing.T) {
+	testfs := errfs.New()
+	conf := `{"profiles": {"work": ["git", "@base", "nvim"], "base": ["shell", "git"]}}`
+	errfs.Add(testfs, sourceConfig("source", conf))
+	for _, pkg := range []string{"git", "nvim", "shell", "tmux"} {
+		errfs.AddDir(testfs, path.Join("source", pkg), 0o755)
+	}
+
+	r := newResolver(testfs, "", []string{"source"})
+	for _, arg := range []string{"tmux", "@work", "git"} {
+		if err := r.resolve(arg); err != nil {
+			t.Fatalf("resolve(%q): %v", arg, err)
+		}
+	}
+
+	wantPkgs := []pkgRef{
+		{"source", "tmux", ""},
+		{"source", "git", ""},
+		{"source", "shell", ""},
+		{"source", "nvim", ""},
+	}
+	if diff := cmp.Diff(wantPkgs, r.pkgs, cmp.AllowUnexported(pkgRef{})); diff != "" {
+		t.Errorf("packages:\n%s", diff)
+	}
+
+	wantProfiles := map[pkgRef][]string{
+		{"source", "git", ""}:   {"@work"},
+		{"source", "shell", ""}: {"@work", "@base"},
+		{"source", "nvim", ""}:  {"@work"},
+	}
+	if diff := cmp.Diff(wantProfiles, r.profiles, cmp.AllowUnexported(pkgRef{})); diff != "" {
+		t.Errorf("profiles:\n%s", diff)
+	}
+
+	// Only packages named by arguments are listed,
+	// even if a profile introduced them first.
+	wantListed := map[pkgRef]int{
+		{"source", "tmux", ""}: 0,
+		{"source", "git", ""}:  1,
+	}
+	if diff := cmp.Diff(wantListed, r.listed, cmp.AllowUnexported(pkgRef{})); diff != "" {
+		t.Errorf("listed:\n%s", diff)
+	}
+}
+
+func TestResolveAllAndPatterns(t *testing.T) {
+	tests := []struct {
+		desc     string   // Description of the test.
+		all      bool     // Whether to resolve all packages.
+		args     []string // The args to resolve.
+		wantPkgs []pkgRef // The resolved packages.
+		wantErr  error    // The error result.
+	}{
+		{
+			desc: "all",
+			all:  true,
+			wantPkgs: []pkgRef{
+				{"source1", "shell-bash", ""},
+				{"source1", "shell-zsh", ""},
+				{"source1", "tmux", ""},
+				{"source2", "git", ""},
+				{"source2", "shell-fish", ""},
+				{"source2", "work/git", ""},
+				{"source2", "work/tools/jq", ""},
+			},
+		},
+		{
+			desc: "pattern in group",
+			args: []string{"work/*"},
+			wantPkgs: []pkgRef{
+				{"source2", "work/git", ""},
+			},
+		},
+		{
+			desc: "package in group",
+			args: []string{"work/tools/jq"},
+			wantPkgs: []pkgRef{
+				{"source2", "work/tools/jq", ""},
+			},
+		},
+		{
+			desc: "pattern",
+			args: []string{"shell-*"},
+			wantPkgs: []pkgRef{
+				{"source1", "shell-bash", ""},
+				{"source1", "shell-zsh", ""},
+				{"source2", "shell-fish", ""},
+			},
+		},
+		{
+			desc: "pattern in chosen source",
+			args: []string{"source2:shell-*"},
+			wantPkgs: []pkgRef{
+				{"source2", "shell-fish", ""},
+			},
+		},
+		{
+			desc:    "pattern does not match excluded package",
+			args:    []string{"scratch*"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc:    "pattern does not match hidden dir",
+			args:    []string{"?hidden"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc:    "pattern matches no package",
+			args:    []string{"no-such-*"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc:    "malformed pattern",
+			args:    []string{"shell-[*"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc:     "name of excluded package",
+			args:     []string{"scratch"},
+			wantPkgs: []pkgRef{{"source1", "scratch", ""}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			testfs := errfs.New()
+			errfs.Add(testfs, sourceConfig("source1", `{"exclude": ["scratch*"]}`))
+			errfs.Add(testfs, sourceDir("source2"))
+			for _, dir := range []string{
+				"source1/.hidden",
+				"source1/scratch",
+				"source1/shell-bash",
+				"source1/shell-zsh",
+				"source1/tmux",
+				"source2/git",
+				"source2/shell-fish",
+				"source2/work/git",
+				"source2/work/tools/jq",
+				"source2/work/.hidden",
+			} {
+				errfs.AddDir(testfs, dir, 0o755)
+			}
+			errfs.Add(testfs, groupDir("source2/work"))
+			errfs.Add(testfs, groupDir("source2/work/tools"))
+			errfs.AddFile(testfs, "source2/shell-file", 0o644)
+
+			r := newResolver(testfs, "", []string{"source1", "source2"})
+			var errs []error
+			if test.all {
+				errs = append(errs, r.resolveAll())
+			}
+			for _, arg := range test.args {
+				errs = append(errs, r.resolve(arg))
+			}
+			err := errors.Join(errs...)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error:\n got: %v\nwant: %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.wantPkgs, r.pkgs, cmp.AllowUnexported(pkgRef{}), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("packages:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExpandRequirements(t *testing.T) {
+	tests := []struct {
+		desc     string        // Description of the test.
+		files    []*errfs.File // Files on the file system.
+		pkgs     []string      // The packages to expand.
+		wantPkgs []string      // The expanded packages.
+		wantErr  error         // The error result.
+	}{
+		{
+			desc: "no requirements",
+			files: []*errfs.File{
+				errfs.NewDir("source/pkg1", 0o755),
+				errfs.NewDir("source/pkg2", 0o755),
+			},
+			pkgs:     []string{"pkg2", "pkg1"},
+			wantPkgs: []string{"pkg2", "pkg1"},
+		},
+		{
+			desc: "requirements precede requiring package",
+			files: []*errfs.File{
+				requires("nvim", "fonts", "shell-common"),
+				errfs.NewDir("source/fonts", 0o755),
+				errfs.NewDir("source/shell-common", 0o755),
+			},
+			pkgs:     []string{"nvim"},
+			wantPkgs: []string{"fonts", "shell-common", "nvim"},
+		},
+		{
+			desc: "transitive requirements",
+			files: []*errfs.File{
+				requires("a", "b"),
+				requires("b", "c"),
+				errfs.NewDir("source/c", 0o755),
+			},
+			pkgs:     []string{"a"},
+			wantPkgs: []string{"c", "b", "a"},
+		},
+		{
+			desc: "shared requirement appears once",
+			files: []*errfs.File{
+				requires("a", "common"),
+				requires("b", "common"),
+				errfs.NewDir("source/common", 0o755),
+			},
+			pkgs:     []string{"a", "b"},
+			wantPkgs: []string{"common", "a", "b"},
+		},
+		{
+			desc: "requested package also required",
+			files: []*errfs.File{
+				requires("a", "b"),
+				errfs.NewDir("source/b", 0o755),
+			},
+			pkgs:     []string{"b", "a"},
+			wantPkgs: []string{"b", "a"},
+		},
+		{
+			desc: "missing requirement",
+			files: []*errfs.File{
+				requires("a", "missing"),
+			},
+			pkgs:    []string{"a"},
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc: "requirement cycle",
+			files: []*errfs.File{
+				requires("a", "b"),
+				requires("b", "c"),
+				requires("c", "a"),
+			},
+			pkgs:    []string{"a"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "package requires itself",
+			files: []*errfs.File{
+				requires("a", "a"),
+			},
+			pkgs:    []string{"a"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc: "malformed package config",
+			files: []*errfs.File{
+				errfs.NewFileData(path.Join("source/a", config.PackageFile), 0o644, []byte("{")),
+			},
+			pkgs:    []string{"a"},
+			wantErr: fs.ErrInvalid,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			testfs := errfs.New()
+			errfs.Add(testfs, sourceDir("source"))
+			for _, file := range test.files {
+				errfs.Add(testfs, file)
+			}
+
+			var pkgs []pkgRef
+			for _, p := range test.pkgs {
+				pkgs = append(pkgs, pkgRef{"source", p, ""})
+			}
+
+			got, err := expandRequirements(testfs, pkgs)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error:\n got: %v\nwant: %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var gotPkgs []string
+			for _, p := range got {
+				gotPkgs = append(gotPkgs, p.pkg)
+			}
+			if diff := cmp.Diff(test.wantPkgs, gotPkgs, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("packages:\n%s", diff)
+			}
+		})
+	}
+}
+
+// requires returns a config file for pkg that requires reqs.
+func requires(pkg string, reqs ...string) *errfs.File {
+	var quoted []string
+	for _, r := range reqs {
+		quoted = append(quoted, `"`+r+`"`)
+	}
+	data := `{"requires": [` + strings.Join(quoted, ", ") + `]}`
+	return errfs.NewFileData(path.Join("source", pkg, config.PackageFile), 0o644, []byte(data))
+}
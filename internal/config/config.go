@@ -0,0 +1,160 @@
+// Package config reads the configuration files
+// in duffel sources and packages.
+package config
+
+import (
+	"bytes"
+	"encoding/json/v2"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/dhemery/duffel/internal/file"
+)
+
+// Source is the configuration of a duffel source.
+// It is read from the source's [file.SourceMarkerFile].
+type Source struct {
+	// Profiles maps each profile name to the packages in the profile.
+	// A member that starts with @ names another profile,
+	// whose packages are included in the profile.
+	Profiles map[string][]string `json:"profiles,omitempty"`
+
+	// Exclude lists patterns for the names of packages
+	// to skip when installing all packages or packages that match a pattern.
+	// The pattern syntax is that of [path.Match].
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Vars maps variable names to values
+	// for rendering the templates in the source.
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+// ReadSource reads the configuration of the source in dir.
+// If the source marker file is empty or does not hold a JSON object,
+// the configuration is empty.
+// Earlier versions of duffel ignored the marker file's content,
+// so a marker may hold any text, such as the path to the source.
+func ReadSource(fsys fs.FS, dir string) (Source, error) {
+	var s Source
+	name := path.Join(dir, file.SourceMarkerFile)
+	data, err := readData(fsys, name)
+	if err != nil {
+		return Source{}, err
+	}
+	if !bytes.HasPrefix(data, []byte("{")) {
+		return Source{}, nil
+	}
+	if err := decode(name, data, &s); err != nil {
+		return Source{}, err
+	}
+	for _, pattern := range s.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Source{}, fmt.Errorf("%s: exclude %q: %w: %w", name, pattern, fs.ErrInvalid, err)
+		}
+	}
+	return s, nil
+}
+
+// Excludes reports whether s's exclude patterns match the package name.
+func (s Source) Excludes(pkg string) bool {
+	for _, pattern := range s.Exclude {
+		if ok, _ := path.Match(pattern, pkg); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PackageFile is the name of the configuration file in a package directory.
+const PackageFile = ".duffel-package"
+
+// Package is the configuration of a duffel package.
+type Package struct {
+	// Requires names the packages that must be installed with the package.
+	// Each is a package in the same source.
+	Requires []string `json:"requires,omitempty"`
+
+	// Priority ranks the package against other packages
+	// that provide items for the same target file.
+	// The package with the higher priority provides the file.
+	Priority int `json:"priority,omitempty"`
+
+	// Paths maps item path prefixes in the package
+	// to the path prefixes at which to install them in the target.
+	// An item is installed at the mapping of its longest matching prefix.
+	// For example, the mapping "bin": ".local/bin"
+	// installs the item bin/tool at .local/bin/tool.
+	// A mapped path may refer to variables as described by [Expand].
+	// If the expanded path is absolute, it must be inside the target.
+	Paths map[string]string `json:"paths,omitempty"`
+
+	// Target is the directory in which to install the package's items,
+	// instead of the target given to the command.
+	// The target may refer to variables as described by [Expand].
+	// A relative target is relative to the target given to the command.
+	Target string `json:"target,omitempty"`
+}
+
+// ReadPackage reads the configuration of the package in dir.
+// If dir has no configuration file, the configuration is empty.
+func ReadPackage(fsys fs.FS, dir string) (Package, error) {
+	var p Package
+	name := path.Join(dir, PackageFile)
+	if err := read(fsys, name, &p); err != nil {
+		return Package{}, err
+	}
+
+	targets := map[string]string{}
+	for from, to := range p.Paths {
+		if !isItemPath(from) || !isItemPath(to) {
+			return Package{}, fmt.Errorf("%s: paths %q: %q: %w: not a relative item path",
+				name, from, to, fs.ErrInvalid)
+		}
+		if other, ok := targets[to]; ok {
+			return Package{}, fmt.Errorf("%s: paths %q and %q: %w: both map to %q",
+				name, min(from, other), max(from, other), fs.ErrInvalid, to)
+		}
+		targets[to] = from
+	}
+	return p, nil
+}
+
+// isItemPath reports whether p is a path from a directory
+// to an item within it.
+func isItemPath(p string) bool {
+	return p != "." && fs.ValidPath(p)
+}
+
+// read decodes the JSON value in the named file into v.
+// If the file does not exist or is empty, v is unchanged.
+func read(fsys fs.FS, name string, v any) error {
+	data, err := readData(fsys, name)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	return decode(name, data, v)
+}
+
+// readData returns the content of the named file,
+// without leading or trailing white space.
+// If the file does not exist, the content is empty.
+func readData(fsys fs.FS, name string) ([]byte, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(data), nil
+}
+
+// decode decodes the JSON value in data, read from the named file, into v.
+func decode(name string, data []byte, v any) error {
+	if err := json.Unmarshal(data, v, json.RejectUnknownMembers(true)); err != nil {
+		return fmt.Errorf("%s: %w: %w", name, fs.ErrInvalid, err)
+	}
+	return nil
+}
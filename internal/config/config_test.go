@@ -0,0 +1,172 @@
+package config_test
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	. "github.com/dhemery/duffel/internal/config"
+	"github.com/dhemery/duffel/internal/errfs"
+	"github.com/dhemery/duffel/internal/file"
+)
+
+func TestReadSource(t *testing.T) {
+	tests := map[string]struct {
+		data       string // The contents of the source marker file.
+		wantConfig Source // The Source result.
+		wantErr    error  // The error result.
+	}{
+		"empty marker file": {
+			data:       "",
+			wantConfig: Source{},
+		},
+		"marker file with text": {
+			data:       "/home/user/dotfiles/.duffel\n",
+			wantConfig: Source{},
+		},
+		"marker file with a JSON array": {
+			data:       `["git"]`,
+			wantConfig: Source{},
+		},
+		"profiles": {
+			data: `{"profiles": {"work": ["git", "nvim", "@base"], "base": ["shell"]}}`,
+			wantConfig: Source{Profiles: map[string][]string{
+				"work": {"git", "nvim", "@base"},
+				"base": {"shell"},
+			}},
+		},
+		"exclude": {
+			data:       `{"exclude": ["old-*", "scratch"]}`,
+			wantConfig: Source{Exclude: []string{"old-*", "scratch"}},
+		},
+		"malformed exclude pattern": {
+			data:    `{"exclude": ["[old"]}`,
+			wantErr: fs.ErrInvalid,
+		},
+		"malformed config": {
+			data:    `{"profiles": [}`,
+			wantErr: fs.ErrInvalid,
+		},
+		"unknown member": {
+			data:    `{"profile": {}}`,
+			wantErr: fs.ErrInvalid,
+		},
+	}
+
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			testFS := errfs.New()
+			errfs.Add(testFS, errfs.NewFileData(path.Join("source", file.SourceMarkerFile), 0o644, []byte(test.data)))
+
+			got, err := ReadSource(testFS, "source")
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error:\n got: %v\nwant: %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.wantConfig, got); diff != "" {
+				t.Errorf("config:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSourceExcludes(t *testing.T) {
+	s := Source{Exclude: []string{"old-*", "scratch"}}
+	tests := map[string]bool{
+		"old-git":   true,
+		"scratch":   true,
+		"git":       false,
+		"scratches": false,
+	}
+	for pkg, want := range tests {
+		if got := s.Excludes(pkg); got != want {
+			t.Errorf("Excludes(%q): got %t, want %t", pkg, got, want)
+		}
+	}
+}
+
+func TestReadPackage(t *testing.T) {
+	tests := map[string]struct {
+		file       *errfs.File // The package config file, if any.
+		wantConfig Package     // The Package result.
+		wantErr    error       // The error result.
+	}{
+		"no config file": {
+			wantConfig: Package{},
+		},
+		"empty config file": {
+			file:       packageFile(""),
+			wantConfig: Package{},
+		},
+		"requires": {
+			file:       packageFile(`{"requires": ["pkg1", "pkg2"]}`),
+			wantConfig: Package{Requires: []string{"pkg1", "pkg2"}},
+		},
+		"priority": {
+			file:       packageFile(`{"priority": 10}`),
+			wantConfig: Package{Priority: 10},
+		},
+		"paths": {
+			file:       packageFile(`{"paths": {"bin": ".local/bin", "config": ".config"}}`),
+			wantConfig: Package{Paths: map[string]string{"bin": ".local/bin", "config": ".config"}},
+		},
+		"target": {
+			file:       packageFile(`{"target": "$XDG_CONFIG_DIRS/app"}`),
+			wantConfig: Package{Target: "$XDG_CONFIG_DIRS/app"},
+		},
+		"path outside package": {
+			file:    packageFile(`{"paths": {"../bin": ".local/bin"}}`),
+			wantErr: fs.ErrInvalid,
+		},
+		"paths with variables": {
+			file:       packageFile(`{"paths": {"config": "$XDG_CONFIG_HOME", "bin": "~/.local/bin"}}`),
+			wantConfig: Package{Paths: map[string]string{"config": "$XDG_CONFIG_HOME", "bin": "~/.local/bin"}},
+		},
+		"empty mapped path": {
+			file:    packageFile(`{"paths": {"bin": ""}}`),
+			wantErr: fs.ErrInvalid,
+		},
+		"paths map to same target": {
+			file:    packageFile(`{"paths": {"bin": ".local/bin", "scripts": ".local/bin"}}`),
+			wantErr: fs.ErrInvalid,
+		},
+		"malformed config": {
+			file:    packageFile(`{"requires": `),
+			wantErr: fs.ErrInvalid,
+		},
+		"unknown member": {
+			file:    packageFile(`{"required": ["pkg1"]}`),
+			wantErr: fs.ErrInvalid,
+		},
+		"read error": {
+			file:    errfs.NewFile(path.Join("source/pkg", PackageFile), 0o644, errfs.ErrRead),
+			wantErr: errfs.ErrRead,
+		},
+	}
+
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			testFS := errfs.New()
+			errfs.AddDir(testFS, "source/pkg", 0o755)
+			if test.file != nil {
+				errfs.Add(testFS, test.file)
+			}
+
+			got, err := ReadPackage(testFS, "source/pkg")
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error:\n got: %v\nwant: %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.wantConfig, got); diff != "" {
+				t.Errorf("config:\n%s", diff)
+			}
+		})
+	}
+}
+
+func packageFile(data string) *errfs.File {
+	return errfs.NewFileData(path.Join("source/pkg", PackageFile), 0o644, []byte(data))
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// Expand replaces each reference to a variable in s by its value in env.
+// A reference has the form $VAR or ${VAR}.
+// A leading ~ followed by a slash or by the end of s
+// refers to the HOME variable.
+// It is an error for s to refer to a variable that is not in env.
+func Expand(s string, env map[string]string) (string, error) {
+	ref := s
+	if ref == "~" || strings.HasPrefix(ref, "~/") {
+		ref = "${HOME}" + ref[1:]
+	}
+
+	var undefined []string
+	expanded := os.Expand(ref, func(name string) string {
+		value, ok := env[name]
+		if !ok {
+			undefined = append(undefined, name)
+		}
+		return value
+	})
+	if len(undefined) > 0 {
+		return "", fmt.Errorf("%s: %w: undefined variable %s",
+			s, fs.ErrInvalid, strings.Join(undefined, ", "))
+	}
+	return expanded, nil
+}
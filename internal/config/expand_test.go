@@ -0,0 +1,46 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	env := map[string]string{
+		"HOME":            "/home/user",
+		"XDG_CONFIG_HOME": "/home/user/.xdg",
+		"EMPTY":           "",
+	}
+	tests := map[string]struct {
+		s       string
+		want    string
+		wantErr error
+	}{
+		"no references":       {s: "/etc/xdg", want: "/etc/xdg"},
+		"variable":            {s: "$XDG_CONFIG_HOME/app", want: "/home/user/.xdg/app"},
+		"braced variable":     {s: "${HOME}.d", want: "/home/user.d"},
+		"empty variable":      {s: "a$EMPTY/b", want: "a/b"},
+		"tilde":               {s: "~", want: "/home/user"},
+		"tilde slash":         {s: "~/.config", want: "/home/user/.config"},
+		"tilde not leading":   {s: "a/~/b", want: "a/~/b"},
+		"tilde user":          {s: "~other/.config", want: "~other/.config"},
+		"undefined variable":  {s: "$NO_SUCH_VAR/app", wantErr: fs.ErrInvalid},
+		"undefined in braces": {s: "${NO_SUCH_VAR}", wantErr: fs.ErrInvalid},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			got, err := Expand(test.s, env)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Expand(%q) error:\n got: %v\nwant: %v", test.s, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("Expand(%q)=%q, want %q", test.s, got, test.want)
+			}
+		})
+	}
+
+	if _, err := Expand("~/app", map[string]string{}); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expand(~/app) with no HOME error:\n got: %v\nwant: %v", err, fs.ErrInvalid)
+	}
+}
@@ -3,6 +3,7 @@
 package errfs
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -15,15 +16,17 @@ import (
 )
 
 const (
-	lstatOp    = "lstat"
-	openOp     = "open"
-	mkdirOp    = "mkdir" // For Error, use writeOp error on parent.
-	readOp     = "read"
-	readDirOp  = "readdir"
-	readLinkOp = "readlink"
-	removeOp   = "remove" // For Error, use writeOp error on parent.
-	statOp     = "stat"
-	symlinkOp  = "symlink" // For Error, use writeOp error on parent.
+	lstatOp     = "lstat"
+	openOp      = "open"
+	mkdirOp     = "mkdir" // For Error, use writeOp error on parent.
+	readOp      = "read"
+	readDirOp   = "readdir"
+	readFileOp  = "readfile"
+	readLinkOp  = "readlink"
+	removeOp    = "remove" // For Error, use writeOp error on parent.
+	statOp      = "stat"
+	symlinkOp   = "symlink"   // For Error, use writeOp error on parent.
+	writeFileOp = "writefile" // For Error, use writeOp error on the file or its parent.
 
 	fsOp    = "errfs."      // Prefix added FS ops in error messages.
 	fileOp  = "errfs.file." // Prefix added to File ops in error messages.
@@ -150,7 +153,7 @@ func (fsys *FS) Open(name string) (fs.File, error) {
 	if opErr, ok := file.errors[openOp]; ok {
 		return nil, &fs.PathError{Op: op, Path: name, Err: opErr}
 	}
-	return file, nil
+	return &openFile{file, bytes.NewReader(file.data)}, nil
 }
 
 // Lstat returns a [fs.FileInfo] that describes the named file.
@@ -220,6 +223,28 @@ func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 	return entries, nil
 }
 
+// ReadFile returns the data of the named regular file.
+// If the file was created with a Read [Error],
+// that error is returned instead.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	const op = fsOp + readFileOp
+	node, err := fsys.find(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	file := node.file
+	if !file.mode.IsRegular() {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	if opErr, ok := file.errors[readOp]; ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: opErr}
+	}
+
+	return bytes.Clone(file.data), nil
+}
+
 // ReadLink returns the Dest of the named symlink file.
 // If the file was created with a ReadLink [Error],
 // that error is returned instead.
@@ -271,13 +296,49 @@ func (fsys *FS) Symlink(oldname, newname string) error {
 			Err: fmt.Errorf("parent dir %s: %w", dir, err)}
 	}
 
-	if _, err := parent.add(NewLink(oldname, newname)); err != nil {
+	if _, err := parent.add(NewLink(newname, oldname)); err != nil {
 		return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
 	}
 
 	return nil
 }
 
+// WriteFile writes data to the named regular file,
+// creating it if necessary.
+// If the file exists and was created with a Write [Error],
+// that error is returned instead.
+// If the file does not exist and its parent was created with a Write [Error],
+// that error is returned instead.
+func (fsys *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	const op = fsOp + writeFileOp
+
+	dir := path.Dir(name)
+	parent, err := fsys.find(dir)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("parent dir %s: %w", dir, err)}
+	}
+
+	existing, ok := parent.entries[name]
+	if !ok {
+		if _, err := parent.add(NewFileData(name, perm, bytes.Clone(data))); err != nil {
+			return &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		return nil
+	}
+
+	file := existing.file
+	if !file.mode.IsRegular() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	if opErr, ok := file.errors[writeOp]; ok {
+		return &fs.PathError{Op: op, Path: name, Err: opErr}
+	}
+
+	file.data = bytes.Clone(data)
+	return nil
+}
+
 // Stat returns a [fs.FileInfo] that describes the named file.
 // This implementation of Stat does not follow symlinks.
 // If the file was created with a Stat [Error],
@@ -323,14 +384,16 @@ type File struct {
 	name   string           // The full name used to create the file.
 	mode   fs.FileMode      // The file mode.
 	dest   string           // The link destination if the file is a symlink.
+	data   []byte           // The contents if the file is a regular file.
 	errors map[string]Error // Errors to return from relevant operations.
 }
 
-func newFile(name string, mode fs.FileMode, dest string, errs []Error) *File {
+func newFile(name string, mode fs.FileMode, dest string, data []byte, errs []Error) *File {
 	f := &File{
 		name:   name,
 		mode:   mode,
 		dest:   dest,
+		data:   data,
 		errors: map[string]Error{},
 	}
 	for _, e := range errs {
@@ -344,7 +407,7 @@ func (f *File) entry() fs.DirEntry {
 }
 
 func (f *File) info() fs.FileInfo {
-	return info{path.Base(f.name), f.mode}
+	return info{path.Base(f.name), f.mode, int64(len(f.data))}
 }
 
 // Close implements fs.File.
@@ -395,9 +458,27 @@ func (f *File) String() string {
 	return out.String()
 }
 
+// An openFile is a [File] opened for reading.
+type openFile struct {
+	*File
+	r *bytes.Reader
+}
+
+// Read implements fs.File.
+// If the file was created with a Read [Error],
+// that error is returned instead.
+func (f *openFile) Read(b []byte) (int, error) {
+	const op = fileOp + readOp
+	if opErr, ok := f.errors[readOp]; ok {
+		return 0, &fs.PathError{Op: op, Path: f.name, Err: opErr}
+	}
+	return f.r.Read(b)
+}
+
 type info struct {
 	name string
 	mode fs.FileMode
+	size int64
 }
 
 // IsDir implements fs.FileInfo.
@@ -422,9 +503,9 @@ func (fi info) Name() string {
 }
 
 // Size implements fs.FileInfo.
-// It always returns 0.
+// It returns the length of a regular file's data, and 0 for other files.
 func (fi info) Size() int64 {
-	return 0
+	return fi.size
 }
 
 // Sys implements fs.FileInfo.
@@ -9,9 +9,10 @@ import (
 var (
 	ErrLstat    = generalOpErr(lstatOp)    // General error for Lstat.
 	ErrOpen     = generalOpErr(openOp)     // General error for Open.
+	ErrRead     = generalOpErr(readOp)     // General error for Read and ReadFile.
 	ErrReadDir  = generalOpErr(readDirOp)  // General error for ReadDir.
 	ErrReadLink = generalOpErr(readLinkOp) // General error for ReadLink.
-	ErrWrite    = generalOpErr(writeOp)    // General error for directory write oprations.
+	ErrWrite    = generalOpErr(writeOp)    // General error for directory write oprations and WriteFile.
 	ErrStat     = generalOpErr(statOp)     // General error for Stat.
 	errGeneral  = errors.New("general error")
 )
@@ -44,6 +45,11 @@ func OpenErr(err error) Error {
 	return Error{openOp, err}
 }
 
+// ReadErr wraps err in an Error for Read and ReadFile.
+func ReadErr(err error) Error {
+	return Error{readOp, err}
+}
+
 // ReadDirErr wraps err in an Error for ReadDir.
 func ReadDirErr(err error) Error {
 	return Error{readDirOp, err}
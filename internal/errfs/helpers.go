@@ -10,21 +10,28 @@ import (
 // Each [Error] configures the associated operation
 // on the directory to return that error.
 func NewDir(name string, perm fs.FileMode, errs ...Error) *File {
-	return newFile(name, fs.ModeDir|perm.Perm(), "", errs)
+	return newFile(name, fs.ModeDir|perm.Perm(), "", nil, errs)
 }
 
 // NewFile creates a new regular file.
 // Each [Error] configures the associated operation
 // on the file to return that error.
 func NewFile(name string, perm fs.FileMode, errs ...Error) *File {
-	return newFile(name, perm.Perm(), "", errs)
+	return newFile(name, perm.Perm(), "", nil, errs)
+}
+
+// NewFileData creates a new regular file that contains data.
+// Each [Error] configures the associated operation
+// on the file to return that error.
+func NewFileData(name string, perm fs.FileMode, data []byte, errs ...Error) *File {
+	return newFile(name, perm.Perm(), "", data, errs)
 }
 
 // NewLink creates a new symlink file.
 // Each [Error] configures the associated operation
 // on the symlink to return that error.
 func NewLink(name string, dest string, errs ...Error) *File {
-	return newFile(name, fs.ModeSymlink, dest, errs)
+	return newFile(name, fs.ModeSymlink, dest, nil, errs)
 }
 
 // FileName returns the name used to create f.
@@ -38,7 +45,7 @@ func FileDirEntry(f *File) fs.DirEntry {
 }
 
 func DirEntry(name string, mode fs.FileMode) fs.DirEntry {
-	return fs.FileInfoToDirEntry(info{name, mode})
+	return fs.FileInfoToDirEntry(info{name: name, mode: mode})
 }
 
 // Add adds f to fsys,
@@ -0,0 +1,87 @@
+// Package facts describes the system on which duffel installs packages.
+package facts
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"maps"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Facts maps the name of each known fact to its value.
+type Facts map[string]string
+
+// Names of the facts that [System] provides.
+const (
+	Arch   = "arch"   // The machine architecture, as reported by [runtime.GOARCH].
+	Distro = "distro" // The ID of the operating system distribution, from /etc/os-release.
+	Host   = "host"   // The short host name, without the domain.
+	OS     = "os"     // The operating system, as reported by [runtime.GOOS].
+)
+
+// System returns the facts about the running system.
+// A fact that cannot be determined is omitted.
+func System() Facts {
+	f := Facts{
+		Arch: runtime.GOARCH,
+		OS:   runtime.GOOS,
+	}
+	if host, err := os.Hostname(); err == nil {
+		f.setHost(host)
+	}
+	f.readDistro(os.DirFS("/"))
+	return f
+}
+
+// Rooted returns a copy of f
+// with the host and distro facts of the system image rooted at fsys,
+// such as a container image or a mounted disk.
+// The host fact comes from etc/hostname,
+// and the distro fact from etc/os-release.
+// A fact that the image does not describe is omitted.
+func (f Facts) Rooted(fsys fs.FS) Facts {
+	rooted := maps.Clone(f)
+	if rooted == nil {
+		rooted = Facts{}
+	}
+	delete(rooted, Host)
+	delete(rooted, Distro)
+	if data, err := fs.ReadFile(fsys, "etc/hostname"); err == nil {
+		rooted.setHost(strings.TrimSpace(string(data)))
+	}
+	rooted.readDistro(fsys)
+	return rooted
+}
+
+// setHost sets the host fact to the short form of host,
+// without the domain.
+func (f Facts) setHost(host string) {
+	host, _, _ = strings.Cut(host, ".")
+	if host != "" {
+		f[Host] = host
+	}
+}
+
+// readDistro sets the distro fact from etc/os-release in fsys.
+func (f Facts) readDistro(fsys fs.FS) {
+	if data, err := fs.ReadFile(fsys, "etc/os-release"); err == nil {
+		if id := osReleaseID(data); id != "" {
+			f[Distro] = id
+		}
+	}
+}
+
+// osReleaseID returns the value of the ID field in os-release data.
+func osReleaseID(data []byte) string {
+	lines := bufio.NewScanner(bytes.NewReader(data))
+	for lines.Scan() {
+		value, ok := strings.CutPrefix(lines.Text(), "ID=")
+		if ok {
+			return strings.Trim(value, `"'`)
+		}
+	}
+	return ""
+}
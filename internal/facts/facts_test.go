@@ -0,0 +1,98 @@
+package facts
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dhemery/duffel/internal/errfs"
+)
+
+func TestSystem(t *testing.T) {
+	f := System()
+	if got := f[OS]; got != runtime.GOOS {
+		t.Errorf("os: got %q, want %q", got, runtime.GOOS)
+	}
+	if got := f[Arch]; got != runtime.GOARCH {
+		t.Errorf("arch: got %q, want %q", got, runtime.GOARCH)
+	}
+}
+
+func TestRooted(t *testing.T) {
+	system := Facts{Arch: "amd64", Distro: "debian", Host: "laptop", OS: "linux"}
+	tests := map[string]struct {
+		osRelease *string // The content of etc/os-release in the image, if any.
+		hostname  *string // The content of etc/hostname in the image, if any.
+		want      Facts
+	}{
+		"image describes host and distro": {
+			osRelease: ptr("ID=alpine\n"),
+			hostname:  ptr("builder.example.com\n"),
+			want:      Facts{Arch: "amd64", Distro: "alpine", Host: "builder", OS: "linux"},
+		},
+		"image does not describe host or distro": {
+			want: Facts{Arch: "amd64", OS: "linux"},
+		},
+		"empty hostname": {
+			hostname: ptr("\n"),
+			want:     Facts{Arch: "amd64", OS: "linux"},
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			image := errfs.New()
+			errfs.AddDir(image, "etc", 0o755)
+			if test.osRelease != nil {
+				errfs.Add(image, errfs.NewFileData("etc/os-release", 0o644, []byte(*test.osRelease)))
+			}
+			if test.hostname != nil {
+				errfs.Add(image, errfs.NewFileData("etc/hostname", 0o644, []byte(*test.hostname)))
+			}
+
+			got := system.Rooted(image)
+
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("facts:\n%s", diff)
+			}
+			if system[Host] != "laptop" || system[Distro] != "debian" {
+				t.Errorf("changed original facts: %v", system)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestOSReleaseID(t *testing.T) {
+	tests := map[string]struct {
+		data string
+		want string
+	}{
+		"unquoted": {
+			data: "NAME=\"Debian GNU/Linux\"\nID=debian\nVERSION_ID=\"12\"\n",
+			want: "debian",
+		},
+		"quoted": {
+			data: "ID=\"fedora\"\n",
+			want: "fedora",
+		},
+		"ID_LIKE is not ID": {
+			data: "ID_LIKE=debian\n",
+			want: "",
+		},
+		"empty": {
+			data: "",
+			want: "",
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			if got := osReleaseID([]byte(test.data)); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"cmp"
 	"fmt"
 	"io/fs"
 )
@@ -8,6 +9,7 @@ import (
 var (
 	actMkdir     = "mkdir"   // Create a directory with permission 0o755.
 	actRemove    = "remove"  // Remove a file or (empty) directory.
+	actRender    = "render"  // Write rendered content to a regular file.
 	actSymlink   = "symlink" // Create a symlink.
 	removeAction = Action{Action: actRemove}
 	mkdirAction  = Action{Action: actMkdir}
@@ -23,6 +25,9 @@ type ActionFS interface {
 
 	// Symlink creates newname as a symbolic link to oldname.
 	Symlink(oldname, newname string) error
+
+	// WriteFile writes data to the named file, creating it if necessary.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
 }
 
 // Action describes a change to make to a file.
@@ -32,6 +37,14 @@ type Action struct {
 
 	// Dest is the link destination if the action is [ActSymlink].
 	Dest string `json:"dest,omitempty"`
+
+	// Content is the content to write if the action is [ActRender].
+	Content string `json:"content,omitempty"`
+
+	// Perm is the permission bits of the file to write if the action is [ActRender].
+	// If Perm is zero, the permission bits are 0o644.
+	// Writing to an existing file does not change its permission bits.
+	Perm fs.FileMode `json:"perm,omitzero"`
 }
 
 // Execute performs the action on the named file.
@@ -41,6 +54,8 @@ func (a Action) Execute(fsys ActionFS, name string) error {
 		return fsys.Mkdir(name, 0o755)
 	case actRemove:
 		return fsys.Remove(name)
+	case actRender:
+		return fsys.WriteFile(name, []byte(a.Content), cmp.Or(a.Perm, 0o644))
 	case actSymlink:
 		return fsys.Symlink(a.Dest, name)
 	}
@@ -55,6 +70,10 @@ func RemoveAction() Action {
 	return removeAction
 }
 
+func RenderAction(content string, perm fs.FileMode) Action {
+	return Action{Action: actRender, Content: content, Perm: perm}
+}
+
 func SymlinkAction(dest string) Action {
 	return Action{Action: actSymlink, Dest: dest}
 }
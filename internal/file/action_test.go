@@ -1,6 +1,7 @@
 package file
 
 import (
+	"io/fs"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -17,6 +18,8 @@ func TestActions(t *testing.T) {
 		name      string
 		action    Action
 		wantFiles []*errfs.File
+		wantData  string      // If not empty, the wanted content of the named file.
+		wantPerm  fs.FileMode // If not zero, the wanted permission bits of the named file.
 		wantErr   error
 	}{
 		{
@@ -50,6 +53,43 @@ func TestActions(t *testing.T) {
 			action:  RemoveAction(),
 			wantErr: errfs.ErrWrite,
 		},
+		{
+			desc:     "render new file",
+			files:    []*errfs.File{errfs.NewDir("parent", 0o755)},
+			name:     "parent/file",
+			action:   RenderAction("rendered content", 0o644),
+			wantData: "rendered content",
+		},
+		{
+			desc:     "render executable file",
+			files:    []*errfs.File{errfs.NewDir("parent", 0o755)},
+			name:     "parent/file",
+			action:   RenderAction("#!/bin/sh", 0o755),
+			wantData: "#!/bin/sh",
+			wantPerm: 0o755,
+		},
+		{
+			desc:     "render file without permission bits",
+			files:    []*errfs.File{errfs.NewDir("parent", 0o755)},
+			name:     "parent/file",
+			action:   RenderAction("content", 0),
+			wantData: "content",
+			wantPerm: 0o644,
+		},
+		{
+			desc:     "render existing file",
+			files:    []*errfs.File{errfs.NewFileData("parent/file", 0o644, []byte("old content"))},
+			name:     "parent/file",
+			action:   RenderAction("new content", 0o644),
+			wantData: "new content",
+		},
+		{
+			desc:    "render error",
+			files:   []*errfs.File{errfs.NewDir("unmodifiable-dir", 0o755, errfs.ErrWrite)},
+			name:    "unmodifiable-dir/file",
+			action:  RenderAction("content", 0o644),
+			wantErr: errfs.ErrWrite,
+		},
 		{
 			desc:    "symlink",
 			files:   []*errfs.File{errfs.NewDir("parent", 0o755)},
@@ -78,6 +118,26 @@ func TestActions(t *testing.T) {
 			if diff := cmp.Diff(test.wantErr, err, cmpopts.EquateErrors()); diff != "" {
 				t.Errorf("error:\n got: %v\nwant: %v", err, test.wantErr)
 			}
+
+			if test.wantData != "" {
+				gotData, err := fs.ReadFile(testfs, test.name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(gotData) != test.wantData {
+					t.Errorf("data: got %q, want %q", gotData, test.wantData)
+				}
+			}
+
+			if test.wantPerm != 0 {
+				info, err := fs.Stat(testfs, test.name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := info.Mode().Perm(); got != test.wantPerm {
+					t.Errorf("perm: got %v, want %v", got, test.wantPerm)
+				}
+			}
 		})
 	}
 }
@@ -0,0 +1,174 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewCacheFS returns a [CacheFS] that reads from fsys.
+func NewCacheFS(fsys fs.ReadLinkFS) *CacheFS {
+	return &CacheFS{fsys: fsys}
+}
+
+// A CacheFS is an [fs.ReadLinkFS] that remembers what it reads from another file system,
+// for use while the files do not change.
+// It reads each directory, symlink, and file once,
+// and stats each file once.
+// It answers Lstat calls for a directory's entries from the directory's listing.
+// Open always opens the file in the underlying file system.
+// A CacheFS is safe for concurrent use.
+//
+// The [fs.FileInfo] that Lstat returns for a directory entry
+// describes only the file's name and type.
+type CacheFS struct {
+	fsys  fs.ReadLinkFS
+	dirs  cache[[]fs.DirEntry]
+	infos cache[fs.FileInfo]
+	stats cache[fs.FileInfo]
+	links cache[string]
+	files cache[[]byte]
+}
+
+// Open opens the named file in the underlying file system.
+func (c *CacheFS) Open(name string) (fs.File, error) {
+	return c.fsys.Open(name)
+}
+
+// Stat returns a [fs.FileInfo] that describes the named file.
+func (c *CacheFS) Stat(name string) (fs.FileInfo, error) {
+	return c.stats.get(name, func() (fs.FileInfo, error) {
+		return fs.Stat(c.fsys, name)
+	})
+}
+
+// ReadFile returns the content of the named file.
+func (c *CacheFS) ReadFile(name string) ([]byte, error) {
+	data, err := c.files.get(name, func() ([]byte, error) {
+		return fs.ReadFile(c.fsys, name)
+	})
+	return bytes.Clone(data), err
+}
+
+// ReadDir returns the entries of the named directory,
+// sorted by file name.
+func (c *CacheFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := c.dirs.get(name, func() ([]fs.DirEntry, error) {
+		return fs.ReadDir(c.fsys, name)
+	})
+	return slices.Clone(entries), err
+}
+
+// ReadLink returns the destination of the named symlink.
+func (c *CacheFS) ReadLink(name string) (string, error) {
+	return c.links.get(name, func() (string, error) {
+		return c.fsys.ReadLink(name)
+	})
+}
+
+// Lstat returns a [fs.FileInfo] that describes the named file
+// without following a symlink.
+// If Lstat can read the directory that contains the file,
+// it describes the file from the directory's entries.
+// Otherwise it calls Lstat on the underlying file system.
+func (c *CacheFS) Lstat(name string) (fs.FileInfo, error) {
+	lstat := func() (fs.FileInfo, error) {
+		return c.infos.get(name, func() (fs.FileInfo, error) {
+			return c.fsys.Lstat(name)
+		})
+	}
+	if name == "." || !fs.ValidPath(name) {
+		return lstat()
+	}
+
+	entries, err := c.dirs.get(path.Dir(name), func() ([]fs.DirEntry, error) {
+		return fs.ReadDir(c.fsys, path.Dir(name))
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		// A file cannot exist in a dir that does not exist.
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		// The dir may be a file, or may be searchable but not readable.
+		return lstat()
+	}
+
+	base := path.Base(name)
+	i, found := slices.BinarySearchFunc(entries, base, func(e fs.DirEntry, base string) int {
+		return strings.Compare(e.Name(), base)
+	})
+	if !found {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	return entryInfo{entries[i]}, nil
+}
+
+// An entryInfo is an [fs.FileInfo] that describes only the name and type
+// of a directory entry.
+type entryInfo struct {
+	entry fs.DirEntry
+}
+
+func (ei entryInfo) Name() string {
+	return ei.entry.Name()
+}
+
+func (ei entryInfo) Size() int64 {
+	return 0
+}
+
+func (ei entryInfo) Mode() fs.FileMode {
+	return ei.entry.Type()
+}
+
+func (ei entryInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (ei entryInfo) IsDir() bool {
+	return ei.entry.IsDir()
+}
+
+func (ei entryInfo) Sys() any {
+	return nil
+}
+
+// A cache remembers the result of reading each named file.
+type cache[T any] struct {
+	mu      sync.Mutex
+	results map[string]*result[T]
+}
+
+// A result is the result of reading a file.
+type result[T any] struct {
+	once  sync.Once
+	value T
+	err   error
+}
+
+// get returns the remembered result for the named file.
+// If c has no result for the file, get calls read to read it,
+// and remembers the result.
+// Concurrent calls for the same file call read only once.
+func (c *cache[T]) get(name string, read func() (T, error)) (T, error) {
+	c.mu.Lock()
+	if c.results == nil {
+		c.results = map[string]*result[T]{}
+	}
+	r, ok := c.results[name]
+	if !ok {
+		r = &result[T]{}
+		c.results[name] = r
+	}
+	c.mu.Unlock()
+
+	r.once.Do(func() {
+		r.value, r.err = read()
+	})
+	return r.value, r.err
+}
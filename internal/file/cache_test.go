@@ -0,0 +1,145 @@
+package file
+
+import (
+	"errors"
+	"io/fs"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dhemery/duffel/internal/errfs"
+)
+
+func TestCacheFSLstat(t *testing.T) {
+	files := []*errfs.File{
+		errfs.NewDir("dir/sub", 0o755),
+		errfs.NewFile("dir/file", 0o644),
+		errfs.NewLink("dir/link", "sub"),
+		errfs.NewDir("unreadable", 0o755, errfs.ReadDirErr(fs.ErrPermission)),
+		errfs.NewFile("unreadable/file", 0o644),
+	}
+	tests := map[string]struct {
+		name     string
+		wantType Type
+		wantErr  error
+	}{
+		"root":                    {name: ".", wantType: TypeDir},
+		"top level dir":           {name: "dir", wantType: TypeDir},
+		"dir":                     {name: "dir/sub", wantType: TypeDir},
+		"file":                    {name: "dir/file", wantType: TypeFile},
+		"link":                    {name: "dir/link", wantType: TypeSymlink},
+		"missing file":            {name: "dir/missing", wantErr: fs.ErrNotExist},
+		"missing dir":             {name: "missing/file", wantErr: fs.ErrNotExist},
+		"file in unreadable dir":  {name: "unreadable/file", wantType: TypeFile},
+		"missing unreadable file": {name: "unreadable/missing", wantErr: fs.ErrNotExist},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			testFS := errfs.New()
+			for _, f := range files {
+				errfs.Add(testFS, f)
+			}
+			cfs := NewCacheFS(testFS)
+
+			info, err := cfs.Lstat(test.name)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Lstat(%q) error:\n got: %v\nwant: %v", test.name, err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			gotType, err := TypeOf(info.Mode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotType != test.wantType {
+				t.Errorf("Lstat(%q) type: got %s, want %s", test.name, gotType, test.wantType)
+			}
+		})
+	}
+}
+
+func TestCacheFSReadsOnce(t *testing.T) {
+	testFS := errfs.New()
+	errfs.AddFile(testFS, "dir/a", 0o644)
+	errfs.AddFile(testFS, "dir/b", 0o644)
+	errfs.AddLink(testFS, "dir/link", "a")
+	counter := &countingFS{ReadLinkFS: testFS, calls: map[string]int{}}
+	cfs := NewCacheFS(counter)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for _, name := range []string{"dir/a", "dir/b", "dir/link", "dir/missing"} {
+				if _, err := cfs.Lstat(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("Lstat(%q): %v", name, err)
+				}
+			}
+			if _, err := cfs.ReadLink("dir/link"); err != nil {
+				t.Errorf("ReadLink: %v", err)
+			}
+			if _, err := cfs.ReadDir("dir"); err != nil {
+				t.Errorf("ReadDir: %v", err)
+			}
+			if _, err := cfs.Stat("dir/link"); err != nil {
+				t.Errorf("Stat: %v", err)
+			}
+			if _, err := cfs.ReadFile("dir/a"); err != nil {
+				t.Errorf("ReadFile: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	wantCalls := map[string]int{
+		"readdir dir":       1,
+		"readlink dir/link": 1,
+		"stat dir/link":     1,
+		"readfile dir/a":    1,
+	}
+	if diff := cmp.Diff(wantCalls, counter.calls); diff != "" {
+		t.Errorf("calls to underlying file system:\n%s", diff)
+	}
+}
+
+// A countingFS counts the calls to the ReadDir, ReadLink, Lstat, Stat, and ReadFile methods
+// of an [fs.ReadLinkFS].
+// It is safe for concurrent use.
+type countingFS struct {
+	fs.ReadLinkFS
+	mu    sync.Mutex
+	calls map[string]int // The number of calls for each method and name.
+}
+
+func (c *countingFS) count(op, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[op+" "+name]++
+}
+
+func (c *countingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	c.count("readdir", name)
+	return fs.ReadDir(c.ReadLinkFS, name)
+}
+
+func (c *countingFS) ReadLink(name string) (string, error) {
+	c.count("readlink", name)
+	return c.ReadLinkFS.ReadLink(name)
+}
+
+func (c *countingFS) Lstat(name string) (fs.FileInfo, error) {
+	c.count("lstat", name)
+	return c.ReadLinkFS.Lstat(name)
+}
+
+func (c *countingFS) Stat(name string) (fs.FileInfo, error) {
+	c.count("stat", name)
+	return fs.Stat(c.ReadLinkFS, name)
+}
+
+func (c *countingFS) ReadFile(name string) ([]byte, error) {
+	c.count("readfile", name)
+	return fs.ReadFile(c.ReadLinkFS, name)
+}
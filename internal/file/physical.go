@@ -0,0 +1,74 @@
+package file
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// maxLinks is the maximum number of symlinks to follow
+// when resolving a name.
+const maxLinks = 255
+
+// Physical returns the physical path to the named file,
+// with each symlink in the path replaced by its destination.
+// A symlink with an absolute destination
+// is resolved with respect to the root of fsys.
+// If some component of the name does not exist,
+// the rest of the name is joined lexically to the resolved part.
+// The result is clean, relative to the root of fsys,
+// and "." if the name resolves to the root.
+// If resolving the name follows too many symlinks,
+// Physical returns an error that wraps [syscall.ELOOP].
+func Physical(fsys fs.ReadLinkFS, name string) (string, error) {
+	var resolved string // The physical path resolved so far. Empty means the root.
+	rest := strings.Split(name, "/")
+	links := 0
+	for len(rest) > 0 {
+		c := rest[0]
+		rest = rest[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			if resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+
+		next := path.Join(resolved, c)
+		info, err := fsys.Lstat(next)
+		if errors.Is(err, fs.ErrNotExist) {
+			return path.Join(append([]string{next}, rest...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode().Type() != fs.ModeSymlink {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxLinks {
+			return "", &fs.PathError{Op: "physical", Path: name, Err: syscall.ELOOP}
+		}
+		dest, err := fsys.ReadLink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(dest) {
+			resolved = ""
+		}
+		rest = append(strings.Split(dest, "/"), rest...)
+	}
+
+	if resolved == "" {
+		return ".", nil
+	}
+	return resolved, nil
+}
@@ -0,0 +1,113 @@
+package file
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/dhemery/duffel/internal/errfs"
+)
+
+func TestPhysical(t *testing.T) {
+	tests := map[string]struct {
+		files   []*errfs.File
+		name    string
+		want    string
+		wantErr error
+	}{
+		"no links": {
+			files: []*errfs.File{errfs.NewDir("home/user", 0o755)},
+			name:  "home/user",
+			want:  "home/user",
+		},
+		"root": {
+			name: ".",
+			want: ".",
+		},
+		"linked ancestor with relative dest": {
+			files: []*errfs.File{
+				errfs.NewDir("var/home/user", 0o755),
+				errfs.NewLink("home", "var/home"),
+			},
+			name: "home/user",
+			want: "var/home/user",
+		},
+		"linked ancestor with absolute dest": {
+			files: []*errfs.File{
+				errfs.NewDir("var/home/user", 0o755),
+				errfs.NewLink("home", "/var/home"),
+			},
+			name: "home/user",
+			want: "var/home/user",
+		},
+		"linked file": {
+			files: []*errfs.File{
+				errfs.NewDir("home/user/dotfiles", 0o755),
+				errfs.NewLink("home/user/src", "dotfiles"),
+			},
+			name: "home/user/src",
+			want: "home/user/dotfiles",
+		},
+		"chain of links": {
+			files: []*errfs.File{
+				errfs.NewDir("data/user", 0o755),
+				errfs.NewLink("home", "var/home"),
+				errfs.NewLink("var/home", "../data"),
+			},
+			name: "home/user",
+			want: "data/user",
+		},
+		"dot dot after link": {
+			files: []*errfs.File{
+				errfs.NewDir("var/home/user", 0o755),
+				errfs.NewDir("var/other", 0o755),
+				errfs.NewLink("home", "var/home"),
+			},
+			name: "home/../other",
+			want: "var/other", // The parent of the link's destination.
+		},
+		"dot dot in link dest": {
+			files: []*errfs.File{
+				errfs.NewDir("var/home/user", 0o755),
+				errfs.NewDir("var/other", 0o755),
+				errfs.NewLink("home", "var/home"),
+				errfs.NewLink("var/home/user/up", "../../other"),
+			},
+			name: "home/user/up",
+			want: "var/other",
+		},
+		"missing file under link": {
+			files: []*errfs.File{
+				errfs.NewDir("var/home", 0o755),
+				errfs.NewLink("home", "var/home"),
+			},
+			name: "home/user/dotfiles",
+			want: "var/home/user/dotfiles",
+		},
+		"link loop": {
+			files: []*errfs.File{
+				errfs.NewLink("a", "b"),
+				errfs.NewLink("b", "a"),
+			},
+			name:    "a/file",
+			wantErr: syscall.ELOOP,
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			testFS := errfs.New()
+			for _, f := range test.files {
+				errfs.Add(testFS, f)
+			}
+
+			got, err := Physical(testFS, test.name)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Physical(%q) error:\n got: %v\nwant: %v", test.name, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("Physical(%q)=%q, want %q", test.name, got, test.want)
+			}
+		})
+	}
+}
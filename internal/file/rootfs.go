@@ -2,18 +2,22 @@ package file
 
 import (
 	"io/fs"
+	"os"
 )
 
 // A Root implements [ActionFS] and can supply an [fs.FS] that implements [fs.ReadLinkFS].
+// It can also open a Root for a directory within it.
 type Root interface {
 	FS() fs.FS
+	OpenRoot(name string) (*os.Root, error)
 	ActionFS
 }
 
-// A RootFS implements [fs.ReadLinkFS] and [ActionFS] by delegating to a [Root].
+// A RootFS implements [fs.ReadLinkFS] and [SubActionFS] by delegating to a [Root].
 type RootFS struct {
 	fs.ReadLinkFS
 	ActionFS
+	root Root
 }
 
 // NewRootFS returns a [RootFS] that delegates to r.
@@ -21,5 +25,13 @@ func NewRootFS(r Root) RootFS {
 	return RootFS{
 		ActionFS:   r,
 		ReadLinkFS: r.FS().(fs.ReadLinkFS),
+		root:       r,
 	}
 }
+
+// Sub returns an [ActionFS] backed by an [os.Root] opened at dir,
+// which cannot change any file outside of dir.
+// The caller should close the [os.Root] when done with it.
+func (fsys RootFS) Sub(dir string) (ActionFS, error) {
+	return fsys.root.OpenRoot(dir)
+}
@@ -2,6 +2,7 @@ package file_test
 
 import (
 	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"path"
@@ -309,3 +310,34 @@ func checkNotExist(name string) filesFunc {
 		}
 	}
 }
+
+func TestRootFSSub(t *testing.T) {
+	must := duftest.Must(t)
+	tdir := t.TempDir()
+	must.MkdirAll(filepath.Join(tdir, "target"), 0o755)
+	must.MkdirAll(filepath.Join(tdir, "outside"), 0o755)
+	must.Symlink("../outside", filepath.Join(tdir, "target/escape"))
+
+	fsys := file.NewRootFS(must.OpenRoot(tdir))
+
+	sub, err := file.Sub(fsys, "target")
+	if err != nil {
+		t.Fatal("Sub(target):", err)
+	}
+	if c, ok := sub.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if err := sub.Mkdir("dir", 0o755); err != nil {
+		t.Error("Mkdir(dir):", err)
+	}
+	must.Lstat(filepath.Join(tdir, "target/dir"))
+
+	// The sub FS does not follow a link out of the target.
+	if err := sub.Mkdir("escape/dir", 0o755); err == nil {
+		t.Error("Mkdir(escape/dir): want error, got nil")
+	}
+	if _, err := os.Lstat(filepath.Join(tdir, "outside/dir")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Lstat(outside/dir) error: got %v, want %v", err, fs.ErrNotExist)
+	}
+}
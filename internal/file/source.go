@@ -8,6 +8,10 @@ import (
 
 const SourceMarkerFile = ".duffel"
 
+// GroupMarkerFile is the name of the file that marks a directory in a source
+// as a group of packages rather than a package.
+const GroupMarkerFile = ".duffel-group"
+
 func SourceDir(fsys fs.ReadLinkFS, name string) (string, error) {
 	dfName := path.Join(name, SourceMarkerFile)
 	_, err := fsys.Lstat(dfName)
@@ -26,3 +30,12 @@ func SourceDir(fsys fs.ReadLinkFS, name string) (string, error) {
 
 	return "", err
 }
+
+// IsGroup reports whether the named directory is a package group.
+func IsGroup(fsys fs.ReadLinkFS, name string) (bool, error) {
+	_, err := fsys.Lstat(path.Join(name, GroupMarkerFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -81,6 +81,50 @@ func TestSourceDir(t *testing.T) {
 	}
 }
 
+func TestIsGroup(t *testing.T) {
+	tests := map[string]struct {
+		files     []*errfs.File // Files on the file system.
+		name      string        // The name of the directory to check.
+		wantGroup bool          // The bool result from IsGroup.
+		wantErr   error         // The error result from IsGroup.
+	}{
+		"group": {
+			files:     []*errfs.File{errfs.NewFile(path.Join("source/group", GroupMarkerFile), 0o644)},
+			name:      "source/group",
+			wantGroup: true,
+		},
+		"not a group": {
+			files:     []*errfs.File{errfs.NewDir("source/pkg", 0o755)},
+			name:      "source/pkg",
+			wantGroup: false,
+		},
+		"lstat error": {
+			files: []*errfs.File{
+				errfs.NewFile(path.Join("source/group", GroupMarkerFile), 0o644, errfs.ErrLstat),
+			},
+			name:    "source/group",
+			wantErr: errfs.ErrLstat,
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			testfs := errfs.New()
+			for _, file := range test.files {
+				errfs.Add(testfs, file)
+			}
+
+			group, err := IsGroup(testfs, test.name)
+
+			if group != test.wantGroup {
+				t.Errorf("IsGroup(%q) group: got %t, want %t", test.name, group, test.wantGroup)
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("IsGroup(%q) error:\n got: %v\nwant %v", test.name, err, test.wantErr)
+			}
+		})
+	}
+}
+
 func sourceDir(dir string) *errfs.File {
 	return errfs.NewFile(path.Join(dir, SourceMarkerFile), 0o644)
 }
@@ -85,10 +85,23 @@ func (t Type) MarshalJSONTo(e *jsontext.Encoder) error {
 type State struct {
 	Type      // The type of file.
 	Dest Dest // The destination if the file is a symbolic link.
+
+	// Rendered reports whether the file is a regular file
+	// planned to hold Content.
+	Rendered bool
+	Content  string      // The content of a rendered file.
+	Perm     fs.FileMode // The permission bits of a rendered file.
 }
 
 // String formats s as a string.
 func (s State) String() string {
+	if s.Rendered {
+		return fmt.Sprintf("rendered %s (%d bytes)", s.Type, len(s.Content))
+	}
+	if s.Type == TypeSymlink && s.Dest.Resolved != "" {
+		return fmt.Sprintf("%s to %s (%s) resolving to %s (%s)",
+			s.Type, s.Dest.Type, s.Dest.Path, s.Dest.ResolvedType, s.Dest.Resolved)
+	}
 	if s.Type == TypeSymlink {
 		return fmt.Sprintf("%s to %s (%s)", s.Type, s.Dest.Type, s.Dest.Path)
 	}
@@ -104,6 +117,22 @@ func (s State) MarshalJSONTo(e *jsontext.Encoder) error {
 type Dest struct {
 	Path string // The path to the link's destination.
 	Type        // The type of file at the link's destination.
+
+	// Resolved is the full path to the file at the end of the chain of links
+	// that begins at the link's destination,
+	// or empty if the destination is not a symlink
+	// or the chain was not resolved.
+	Resolved     string
+	ResolvedType Type // The type of the file at Resolved.
+}
+
+// FinalType returns the type of the file at the end of d's chain of links.
+// If the chain was not resolved, FinalType returns d's type.
+func (d Dest) FinalType() Type {
+	if d.Resolved != "" {
+		return d.ResolvedType
+	}
+	return d.Type
 }
 
 // NewStater creates a [Stater] that reads file states from fsys.
@@ -117,6 +146,13 @@ type Stater struct {
 }
 
 // State returns the state of the named file.
+// If the file is a symlink to a symlink,
+// State follows the chain of links to the final destination
+// and records it in the state's [Dest].
+// A symlink with an absolute destination
+// is resolved with respect to the root of s's FS.
+// If the chain has too many links,
+// State returns an error that wraps [syscall.ELOOP].
 func (s Stater) State(name string) (State, error) {
 	t, err := s.statType(name)
 	if err != nil {
@@ -130,11 +166,30 @@ func (s Stater) State(name string) (State, error) {
 			return State{}, err
 		}
 		fullDest := path.Join(path.Dir(name), dest)
+		if path.IsAbs(dest) {
+			// Resolve an absolute destination with respect to the root of the FS,
+			// as Physical does.
+			fullDest = path.Join(".", dest)
+		}
 		destType, err := s.statType(fullDest)
 		if err != nil {
 			return State{}, err
 		}
-		state.Dest = Dest{dest, destType}
+		state.Dest = Dest{Path: dest, Type: destType}
+
+		if destType == TypeSymlink {
+			// Follow the chain of links to the final destination.
+			resolved, err := Physical(s.FS, fullDest)
+			if err != nil {
+				return State{}, err
+			}
+			resolvedType, err := s.statType(resolved)
+			if err != nil {
+				return State{}, err
+			}
+			state.Dest.Resolved = resolved
+			state.Dest.ResolvedType = resolvedType
+		}
 	}
 	return state, nil
 }
@@ -170,7 +225,14 @@ func FileState() State {
 // LinkState returns a [State] with type [TypeLink]
 // and the given destination and destination type.
 func LinkState(dest string, destType Type) State {
-	return State{TypeSymlink, Dest{dest, destType}}
+	return State{Type: TypeSymlink, Dest: Dest{Path: dest, Type: destType}}
+}
+
+// RenderedState returns a [State] with type [TypeFile]
+// that is planned to hold the rendered content
+// with the given permission bits.
+func RenderedState(content string, perm fs.FileMode) State {
+	return State{Type: TypeFile, Rendered: true, Content: content, Perm: perm}
 }
 
 // NoFileState returns a [Stete] with type [TypeNoFile].
@@ -2,6 +2,7 @@ package file_test
 
 import (
 	"errors"
+	"syscall"
 	"testing"
 
 	"github.com/dhemery/duffel/internal/duftest"
@@ -20,11 +21,12 @@ func add(tfs *errfs.FS, f *errfs.File) {
 
 func TestStater(t *testing.T) {
 	tests := map[string]struct {
-		name      string      // The name of the file.
-		file      *errfs.File // The fila with that name.
-		destFile  *errfs.File // The file's symlink destination if it is a symlink.
-		wantState State       // The State result.
-		wantError error       // The Error result.
+		name      string        // The name of the file.
+		file      *errfs.File   // The fila with that name.
+		destFile  *errfs.File   // The file's symlink destination if it is a symlink.
+		moreFiles []*errfs.File // Other files on the file system.
+		wantState State         // The State result.
+		wantError error         // The Error result.
 	}{
 		"file": {
 			name:      "dir/file",
@@ -42,6 +44,84 @@ func TestStater(t *testing.T) {
 			destFile:  errfs.NewFile("dest-dir/dest-file", 0o644),
 			wantState: LinkState("../dest-dir/dest-file", TypeFile),
 		},
+		"link to link": {
+			name:     "dir/link",
+			file:     errfs.NewLink("dir/link", "../hop"),
+			destFile: errfs.NewLink("hop", "dest-dir/dest-file"),
+			moreFiles: []*errfs.File{
+				errfs.NewFile("dest-dir/dest-file", 0o644),
+			},
+			wantState: State{
+				Type: TypeSymlink,
+				Dest: Dest{
+					Path:         "../hop",
+					Type:         TypeSymlink,
+					Resolved:     "dest-dir/dest-file",
+					ResolvedType: TypeFile,
+				},
+			},
+		},
+		"chain through linked dir": {
+			name:     "dir/link",
+			file:     errfs.NewLink("dir/link", "../hop"),
+			destFile: errfs.NewLink("hop", "linked-dir/dest-file"),
+			moreFiles: []*errfs.File{
+				errfs.NewLink("linked-dir", "dest-dir"),
+				errfs.NewDir("dest-dir/dest-file", 0o755),
+			},
+			wantState: State{
+				Type: TypeSymlink,
+				Dest: Dest{
+					Path:         "../hop",
+					Type:         TypeSymlink,
+					Resolved:     "dest-dir/dest-file",
+					ResolvedType: TypeDir,
+				},
+			},
+		},
+		"link to dangling link": {
+			name:     "dir/link",
+			file:     errfs.NewLink("dir/link", "../hop"),
+			destFile: errfs.NewLink("hop", "missing/file"),
+			wantState: State{
+				Type: TypeSymlink,
+				Dest: Dest{
+					Path:         "../hop",
+					Type:         TypeSymlink,
+					Resolved:     "missing/file",
+					ResolvedType: TypeNoFile,
+				},
+			},
+		},
+		"absolute link": {
+			name:      "home/user/.link",
+			file:      errfs.NewLink("home/user/.link", "/home/user/dotfiles/file"),
+			destFile:  errfs.NewFile("home/user/dotfiles/file", 0o644),
+			wantState: LinkState("/home/user/dotfiles/file", TypeFile),
+		},
+		"absolute link to link": {
+			name:     "home/user/.link",
+			file:     errfs.NewLink("home/user/.link", "/home/user/dotfiles/hop"),
+			destFile: errfs.NewLink("home/user/dotfiles/hop", "dest-dir"),
+			moreFiles: []*errfs.File{
+				errfs.NewDir("home/user/dotfiles/dest-dir", 0o755),
+			},
+			wantState: State{
+				Type: TypeSymlink,
+				Dest: Dest{
+					Path:         "/home/user/dotfiles/hop",
+					Type:         TypeSymlink,
+					Resolved:     "home/user/dotfiles/dest-dir",
+					ResolvedType: TypeDir,
+				},
+			},
+		},
+		"link loop": {
+			name:      "dir/link",
+			file:      errfs.NewLink("dir/link", "../hop"),
+			destFile:  errfs.NewLink("hop", "dir/link"),
+			wantError: syscall.ELOOP,
+		},
 		"file lstat error": {
 			name:      "dir/file",
 			file:      errfs.NewFile("dir/file", 0o644, errfs.ErrLstat),
@@ -73,6 +153,9 @@ func TestStater(t *testing.T) {
 
 			add(testFS, test.file)
 			add(testFS, test.destFile)
+			for _, f := range test.moreFiles {
+				add(testFS, f)
+			}
 
 			stater := NewStater(testFS)
 
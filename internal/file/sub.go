@@ -0,0 +1,87 @@
+package file
+
+import (
+	"io/fs"
+	"path"
+)
+
+// A SubActionFS is an [ActionFS] with a Sub method.
+type SubActionFS interface {
+	ActionFS
+
+	// Sub returns an ActionFS corresponding to the subtree rooted at dir.
+	// The returned ActionFS must not change any file outside the subtree,
+	// even by following a symlink.
+	Sub(dir string) (ActionFS, error)
+}
+
+// Sub returns an [ActionFS] corresponding to the subtree rooted at fsys's dir.
+// Each name given to the returned ActionFS is relative to dir,
+// and must satisfy [fs.ValidPath].
+//
+// If fsys implements [SubActionFS], Sub returns fsys.Sub(dir).
+// Otherwise Sub returns an ActionFS that joins each name to dir,
+// which does not guard against symlinks in the subtree
+// that lead outside of it.
+func Sub(fsys ActionFS, dir string) (ActionFS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	if dir == "." {
+		return fsys, nil
+	}
+	if fsys, ok := fsys.(SubActionFS); ok {
+		return fsys.Sub(dir)
+	}
+	return &subActionFS{fsys, dir}, nil
+}
+
+// A subActionFS is an [ActionFS] for the subtree rooted at dir in fsys.
+type subActionFS struct {
+	fsys ActionFS
+	dir  string
+}
+
+// fullName returns the name in f.fsys of the file named by name in f.
+func (f *subActionFS) fullName(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(f.dir, name), nil
+}
+
+// Mkdir implements [ActionFS].
+func (f *subActionFS) Mkdir(name string, perm fs.FileMode) error {
+	full, err := f.fullName("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return f.fsys.Mkdir(full, perm)
+}
+
+// Remove implements [ActionFS].
+func (f *subActionFS) Remove(name string) error {
+	full, err := f.fullName("remove", name)
+	if err != nil {
+		return err
+	}
+	return f.fsys.Remove(full)
+}
+
+// Symlink implements [ActionFS].
+func (f *subActionFS) Symlink(oldname, newname string) error {
+	full, err := f.fullName("symlink", newname)
+	if err != nil {
+		return err
+	}
+	return f.fsys.Symlink(oldname, full)
+}
+
+// WriteFile implements [ActionFS].
+func (f *subActionFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	full, err := f.fullName("writefile", name)
+	if err != nil {
+		return err
+	}
+	return f.fsys.WriteFile(full, data, perm)
+}
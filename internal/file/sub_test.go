@@ -0,0 +1,49 @@
+package file
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/dhemery/duffel/internal/errfs"
+)
+
+func TestSub(t *testing.T) {
+	testFS := errfs.New()
+	errfs.AddDir(testFS, "target/dir", 0o755)
+
+	sub, err := Sub(testFS, "target")
+	if err != nil {
+		t.Fatal("Sub(target):", err)
+	}
+
+	if err := sub.Mkdir("dir/new", 0o755); err != nil {
+		t.Error("Mkdir(dir/new):", err)
+	}
+	if _, err := testFS.Lstat("target/dir/new"); err != nil {
+		t.Error("Lstat(target/dir/new):", err)
+	}
+
+	if err := sub.Symlink("../some/dest", "dir/link"); err != nil {
+		t.Error("Symlink(dir/link):", err)
+	}
+	if got, err := testFS.ReadLink("target/dir/link"); err != nil || got != "../some/dest" {
+		t.Errorf("ReadLink(target/dir/link) got %q, %v, want %q", got, err, "../some/dest")
+	}
+
+	for _, name := range []string{"../escape", "/abs", "dir/../../escape"} {
+		if err := sub.Mkdir(name, 0o755); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Mkdir(%q) error: got %v, want %v", name, err, fs.ErrInvalid)
+		}
+	}
+	if _, err := testFS.Lstat("escape"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Lstat(escape) error: got %v, want %v", err, fs.ErrNotExist)
+	}
+
+	if got, err := Sub(testFS, "."); err != nil || got != ActionFS(testFS) {
+		t.Errorf("Sub(.) got %v, %v, want the FS itself", got, err)
+	}
+	if _, err := Sub(testFS, "../target"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Sub(../target) error: got %v, want %v", err, fs.ErrInvalid)
+	}
+}
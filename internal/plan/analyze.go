@@ -3,33 +3,142 @@
 package plan
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"io/fs"
 	"log/slog"
+	"path"
+	"slices"
 
+	"github.com/dhemery/duffel/internal/config"
 	"github.com/dhemery/duffel/internal/file"
 )
 
 // InstallPackage creates a [DirGoal] to install the items in a package.
 func InstallPackage(source, pkg string) DirGoal {
+	return InstallItem(source, pkg, "")
+}
+
+// InstallItem creates a [DirGoal] to install a single item in a package.
+// If the item is a directory, the goal installs its contents.
+// Each ancestor of the item within the package
+// is installed as a real directory in the target tree
+// rather than as a link to the package.
+// If item is empty, the goal installs the whole package.
+func InstallItem(source, pkg, item string) DirGoal {
 	return DirGoal{
-		dir:  newSourcePath(source, pkg, ""),
+		dir:  newSourcePath(source, pkg, item),
 		goal: goalInstall,
 	}
 }
 
+// UninstallPackage creates a [DirGoal] to uninstall the items in a package.
+func UninstallPackage(source, pkg string) DirGoal {
+	return UninstallItem(source, pkg, "")
+}
+
+// UninstallItem creates a [DirGoal] to uninstall a single item in a package.
+// If the item is a directory, the goal uninstalls its contents.
+// If an ancestor of the item is installed as a link to the package,
+// the goal replaces the link with a directory
+// that holds links to the ancestor's other contents.
+// If item is empty, the goal uninstalls the whole package.
+//
+// The goal removes only links to the package's items
+// and files rendered from its templates,
+// whichever variant of each item they install.
+// It leaves directories in place.
+func UninstallItem(source, pkg, item string) DirGoal {
+	return DirGoal{
+		dir:  newSourcePath(source, pkg, item),
+		goal: goalUninstall,
+	}
+}
+
+// StatusPackage creates a [DirGoal] to report
+// whether each item in a package is installed.
+func StatusPackage(source, pkg string) DirGoal {
+	return StatusItem(source, pkg, "")
+}
+
+// StatusItem creates a [DirGoal] to report
+// whether a single item in a package is installed.
+// If the item is a directory, the goal reports the status of its contents.
+// If item is empty, the goal reports the status of the whole package.
+// The goal changes nothing in the target tree.
+// See [Plan.Status].
+func StatusItem(source, pkg, item string) DirGoal {
+	return DirGoal{
+		dir:  newSourcePath(source, pkg, item),
+		goal: goalStatus,
+	}
+}
+
 // DirGoal identifies a goal for the items in a directory.
 type DirGoal struct {
-	dir  sourcePath // The directory that contains the items.
-	goal itemGoal   // The goal to achieve for the items.
+	dir      sourcePath       // The directory that contains the items.
+	goal     itemGoal         // The goal to achieve for the items.
+	profiles []string         // The chain of profiles that introduced the goal, if any.
+	filter   *patternSelector // Selects the items to analyze. If nil, analyze every item.
+	target   string           // The target tree in which to achieve the goal. If empty, the planner's target.
+	at       string           // For a merge goal, the target item that becomes the merged dir.
+	listed   bool             // Whether the user listed the goal's package explicitly.
+	order    int              // The package's position among the packages the user listed.
+}
+
+// WithFilter returns a copy of g that analyzes only the items selected by
+// the include and exclude patterns.
+// If include is not empty, g analyzes only items
+// that match or are inside a dir that matches an include pattern.
+// G does not analyze items that match or are inside a dir that matches an exclude pattern.
+// A pattern that contains a slash matches the item's path within its package.
+// A pattern without a slash matches the item's base name.
+// The pattern syntax is that of [path.Match].
+func (g DirGoal) WithFilter(include, exclude []string) DirGoal {
+	if len(include) == 0 && len(exclude) == 0 {
+		g.filter = nil
+		return g
+	}
+	g.filter = &patternSelector{include: include, exclude: exclude}
+	return g
+}
+
+// WithProfiles returns a copy of g
+// that records the chain of profiles that introduced g.
+func (g DirGoal) WithProfiles(profiles []string) DirGoal {
+	g.profiles = profiles
+	return g
+}
+
+// WithTarget returns a copy of g
+// that achieves its goal in the target tree rooted at target
+// instead of the planner's target tree.
+func (g DirGoal) WithTarget(target string) DirGoal {
+	g.target = target
+	return g
+}
+
+// WithListed returns a copy of g
+// whose package the user listed explicitly,
+// at the given position among the listed packages.
+// Among packages with the same configured priority,
+// a package listed later outranks one listed earlier.
+// A package that is not listed outranks no package with the same priority.
+func (g DirGoal) WithListed(position int) DirGoal {
+	g.listed = true
+	g.order = position
+	return g
 }
 
 // mergeDir creates a [DirGoal] to merge a previously installed directory
-// into the directory being installed.
-func mergeDir(dir sourcePath) DirGoal {
+// into the directory being installed at the target item.
+func mergeDir(dir sourcePath, at targetPath) DirGoal {
 	return DirGoal{
-		dir:  dir,
-		goal: goalMerge,
+		dir:    dir,
+		goal:   goalMerge,
+		target: at.target,
+		at:     at.item,
 	}
 }
 
@@ -42,36 +151,131 @@ const (
 
 	// Merge a previously installed directory into the directory being installed.
 	goalMerge itemGoal = "merge"
+
+	// Uninstall the package from the target tree.
+	goalUninstall itemGoal = "uninstall"
+
+	// Report whether the package is installed in the target tree.
+	goalStatus itemGoal = "status"
 )
 
-func newAnalyzer(fsys fs.ReadLinkFS, target string, index *specIndex) *analyzer {
+func newAnalyzer(fsys fs.ReadLinkFS, target string, sys System, index *specIndex, layers *layers, variants *variants, renderer *renderer) *analyzer {
 	analyst := &analyzer{
-		fsys:   fsys,
-		target: target,
-		index:  index,
+		fsys:     fsys,
+		target:   target,
+		sys:      sys,
+		index:    index,
+		variants: variants,
 	}
 	itemizer := itemizer{fsys}
 	merger := newMerger(itemizer, analyst)
-	analyst.install = &installer{merger}
+	analyst.install = &installer{merger, layers, renderer, fsys}
+	analyst.uninstall = &uninstaller{merger, renderer, fsys}
+	analyst.status = newInspector(renderer, fsys)
 	return analyst
 }
 
 type analyzer struct {
-	fsys    fs.FS
-	target  string
-	index   *specIndex
-	install *installer
+	fsys      fs.FS
+	target    string
+	sys       System
+	index     *specIndex
+	install   *installer
+	uninstall *uninstaller
+	status    *inspector
+	variants  *variants
 }
 
-func (a *analyzer) analyze(goal DirGoal, l *slog.Logger) error {
+// analyze analyzes the items in the goal's dir
+// to plan the target states that achieve the goal.
+// Analysis stops with ctx's error once ctx is done.
+func (a *analyzer) analyze(ctx context.Context, goal DirGoal, l *slog.Logger) error {
+	root := goal.dir
+	var selectors selectors
+	if goal.goal != goalMerge && root.item != "" {
+		// Walk the whole package, but analyze only the item,
+		// its contents, and its ancestors.
+		selectors = append(selectors, itemSelector(a.fsys, root))
+		root = root.withItem("")
+	}
+	if goal.filter != nil {
+		selectors = append(selectors, goal.filter)
+	}
+
+	var selector selector
+	if len(selectors) > 0 {
+		selector = selectors
+	}
+
+	target := cmp.Or(goal.target, a.target)
+	paths, err := a.paths(root, target)
+	if err != nil {
+		return err
+	}
+	if goal.at != "" {
+		// Install the merged dir's items inside the target item,
+		// wherever the link to the dir was made.
+		paths = paths.with(stripConditions(root.item), goal.at)
+	}
+
 	entryAnalyzer := entryAnalyzer{
-		root:         goal.dir,
-		target:       a.target,
+		ctx:          ctx,
+		fsys:         a.fsys,
+		root:         root,
+		target:       target,
 		itemAnalyzer: a.install,
 		index:        a.index,
+		selector:     selector,
+		variants:     a.variants,
+		paths:        paths,
+		subtrees:     subtrees{},
+		makesParents: true,
 		logger:       l.With(slog.Any("goal", goal.goal)),
 	}
-	return fs.WalkDir(a.fsys, goal.dir.String(), entryAnalyzer.analyze)
+	switch goal.goal {
+	case goalUninstall:
+		// Uninstall whichever variant of each item is installed,
+		// and create no dirs.
+		entryAnalyzer.itemAnalyzer = a.uninstall
+		entryAnalyzer.variants = nil
+		entryAnalyzer.makesParents = false
+	case goalStatus:
+		entryAnalyzer.itemAnalyzer = a.status
+		entryAnalyzer.makesParents = false
+	}
+	if a.index.workers > 1 {
+		entryAnalyzer.prefetcher = a.index
+		entryAnalyzer.workers = a.index.workers
+	}
+	return fs.WalkDir(a.fsys, root.String(), entryAnalyzer.analyze)
+}
+
+// itemSelector returns a selector that selects s's item,
+// its contents, and its ancestors.
+// Selectors select items by their names in the target tree,
+// so the selector omits the item's conditions and template suffix.
+// If the item does not exist, it names variants,
+// and is treated as a regular file.
+func itemSelector(fsys fs.FS, s sourcePath) selector {
+	regular := true
+	if info, err := fs.Lstat(fsys, s.String()); err == nil {
+		regular = info.Mode().IsRegular()
+	}
+	return subtreeSelector(targetName(s.item, regular))
+}
+
+// paths returns the [pathMap] that installs the items in s's package
+// into the target tree.
+func (a *analyzer) paths(s sourcePath, target string) (pathMap, error) {
+	conf, err := config.ReadPackage(a.fsys, s.packageDir())
+	if err != nil {
+		return nil, err
+	}
+	paths, err := newPathMap(conf.Paths, a.sys.Paths, target, a.sys.Env)
+	if err != nil {
+		return nil, fmt.Errorf("package %s: %w", s.packageDir(), err)
+	}
+	return paths, nil
 }
 
 // itemAnalyzer identifies the goal states for target items.
@@ -80,7 +284,18 @@ type itemAnalyzer interface {
 	// If the target was planned by previous operations,
 	// the target item describes the previously planned goal state.
 	// Otherwise it describes the state of the file in the target tree.
-	analyze(sourceItem, targetItem, *slog.Logger) (file.State, error)
+	analyze(context.Context, sourceItem, targetItem, *slog.Logger) (file.State, error)
+
+	// analyzeParent is like analyze,
+	// but identifies a goal state that installs only some of the source dir's contents.
+	// The goal state for the target item must be a directory,
+	// unless the target already links to the source dir.
+	analyzeParent(context.Context, sourceItem, targetItem, *slog.Logger) (file.State, error)
+
+	// unfold is like analyzeParent,
+	// but the goal state must be a directory
+	// even if the target already links to the source dir.
+	unfold(context.Context, sourceItem, targetItem, *slog.Logger) (file.State, error)
 }
 
 // An index maintains the planned states of items in the target tree.
@@ -89,24 +304,106 @@ type index interface {
 	setState(targetPath, file.State, *slog.Logger)
 }
 
+// A prefetcher reads the states of target items ahead of analysis.
+type prefetcher interface {
+	prefetch(names []string)
+}
+
 type entryAnalyzer struct {
-	root         sourcePath   // The root dir that contains the items to analyze.
-	target       string       // The root of the target tree in which to achieve the goal states.
-	itemAnalyzer itemAnalyzer // Analyzes each item to identify the goal state.
-	index        index        // The known or planned states of target items.
+	ctx          context.Context // Stops the analysis when done.
+	fsys         fs.FS           // The file system that contains the source items.
+	root         sourcePath      // The root dir that contains the items to analyze.
+	target       string          // The root of the target tree in which to achieve the goal states.
+	itemAnalyzer itemAnalyzer    // Analyzes each item to identify the goal state.
+	index        index           // The known or planned states of target items.
+	selector     selector        // Selects the items to analyze. If nil, analyze every item.
+	variants     *variants       // Chooses the variant of each item to analyze. If nil, analyze every item.
+	paths        pathMap         // Maps items to the target paths at which to install them.
+	prefetcher   prefetcher      // Reads target states ahead of analysis. If nil, read each state as analyzed.
+	workers      int             // The maximum number of source dirs to read concurrently while prefetching.
+	subtrees     subtrees        // Describes the source dirs already read. If nil, read each dir as needed.
+	makesParents bool            // Whether to plan the missing ancestors of items installed at remapped paths.
 	logger       *slog.Logger
 }
 
+// subtrees maps each source dir to a description of its contents.
+type subtrees map[string]subtree
+
 func (ea entryAnalyzer) analyze(name string, entry fs.DirEntry, err error) error {
 	if err != nil {
 		return err
 	}
+	if err := ea.ctx.Err(); err != nil {
+		return err
+	}
 	if name == ea.root.String() {
 		// Skip the root dir being walked, but walk its contents.
+		ea.prefetch(name)
 		return nil
 	}
 
 	sourcePath := ea.root.withItemFrom(name)
+	if sourcePath.item == config.PackageFile {
+		// The package's configuration file is not an item to install.
+		return nil
+	}
+
+	// The target item is named without the conditions
+	// that distinguish the source item's variants,
+	// and without any template suffix.
+	item := targetName(sourcePath.item, entry.Type().IsRegular())
+
+	selection := ea.selection(item)
+	if selection == selectParent && !entry.IsDir() {
+		// Only a dir can be the parent of selected items.
+		selection = selectNone
+	}
+	chosen := selection != selectNone
+	if chosen {
+		// Choose among the variants only of selected items,
+		// so that a malformed variant of an unselected item
+		// does not stop the analysis.
+		chosen, err = ea.choose(name)
+		if err != nil {
+			return err
+		}
+	}
+	if !chosen {
+		if entry.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	}
+
+	analyze := ea.itemAnalyzer.analyze
+	switch selection {
+	case selectParent:
+		selected, err := ea.containsSelected(name)
+		if err != nil {
+			return err
+		}
+		if !selected {
+			// Nothing in the dir will be installed,
+			// so the dir is not needed in the target tree.
+			return fs.SkipDir
+		}
+		analyze = ea.itemAnalyzer.analyzeParent
+	case selectItem:
+		if !entry.IsDir() {
+			break
+		}
+		unfold, err := ea.mustUnfold(name)
+		if err != nil {
+			return err
+		}
+		if unfold {
+			// Linking to the dir would install its unselected contents,
+			// install variants under their conditional names,
+			// or link to templates instead of rendering them.
+			analyze = ea.itemAnalyzer.unfold
+		}
+	}
+
 	sourceType, err := file.TypeOf(entry.Type())
 	if err != nil {
 		return fmt.Errorf("%q: %w", sourcePath, err)
@@ -115,7 +412,17 @@ func (ea entryAnalyzer) analyze(name string, entry fs.DirEntry, err error) error
 	sourceItem := sourceItem{sourcePath, sourceType}
 	indexLogger := ea.logger.With(slog.Any("source", sourceItem))
 
-	targetPath := newTargetPath(ea.target, sourcePath.item)
+	targetPath := newTargetPath(ea.target, ea.paths.apply(item))
+	if ea.makesParents && ea.paths.begins(item) {
+		// The item is installed at a place unrelated to its parent's,
+		// so its ancestors in the target tree must be created.
+		if err := ea.makeParents(sourceItem, targetPath, indexLogger); err != nil {
+			return err
+		}
+	}
+	if ea.variants != nil && isVariant(sourcePath.item) {
+		ea.variants.record(targetPath, sourcePath)
+	}
 
 	targetState, err := ea.index.state(targetPath, indexLogger)
 	if err != nil {
@@ -124,11 +431,201 @@ func (ea entryAnalyzer) analyze(name string, entry fs.DirEntry, err error) error
 
 	targetItem := targetItem{targetPath, targetState}
 
-	newState, err := ea.itemAnalyzer.analyze(sourceItem, targetItem, ea.logger)
+	newState, err := analyze(ea.ctx, sourceItem, targetItem, ea.logger)
 
 	if err == nil || err == fs.SkipDir {
 		ea.index.setState(targetPath, newState, indexLogger)
 	}
+	if err == nil && entry.IsDir() {
+		// The walk will analyze the dir's contents next.
+		ea.prefetch(name)
+	}
 
 	return err
 }
+
+// prefetch asks ea's prefetcher to read the states of the target items
+// for the entries in the named source dir.
+// Then it reads ahead the listings of the source dirs among the entries
+// and of the target dirs at which they are installed,
+// up to ea's number of workers at once,
+// so that analyzing their contents reads the listings from the file system's cache.
+// See [file.CacheFS].
+// If a dir cannot be read, prefetch skips it,
+// and leaves the walk to report the error.
+func (ea entryAnalyzer) prefetch(dir string) {
+	if ea.prefetcher == nil {
+		return
+	}
+	entries, err := fs.ReadDir(ea.fsys, dir)
+	if err != nil {
+		return
+	}
+	var names, dirs []string
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		target, ok := ea.prefetchTarget(name, entry)
+		if !ok {
+			continue
+		}
+		names = append(names, target)
+		if entry.IsDir() {
+			dirs = append(dirs, name, target)
+		}
+	}
+	ea.prefetcher.prefetch(names)
+
+	concurrently(ea.workers, len(dirs), func(n int) {
+		fs.ReadDir(ea.fsys, dirs[n])
+	})
+}
+
+// prefetchTarget returns the name of the target item
+// for the named source entry,
+// and reports whether to prefetch the target item's state.
+func (ea entryAnalyzer) prefetchTarget(name string, entry fs.DirEntry) (string, bool) {
+	item := ea.root.withItemFrom(name).item
+	if item == config.PackageFile {
+		return "", false
+	}
+	target := targetName(item, entry.Type().IsRegular())
+	if ea.selection(target) == selectNone {
+		return "", false
+	}
+	return newTargetPath(ea.target, ea.paths.apply(target)).String(), true
+}
+
+// selection returns ea's selection for the item.
+func (ea entryAnalyzer) selection(item string) selection {
+	if ea.selector == nil {
+		return selectItem
+	}
+	return ea.selector.selection(item)
+}
+
+// choose reports whether the named source file
+// is the variant to install for its item.
+func (ea entryAnalyzer) choose(name string) (bool, error) {
+	if ea.variants == nil {
+		return true, nil
+	}
+	return ea.variants.choose(name)
+}
+
+// containsSelected reports whether the named source dir
+// contains a file that ea would install:
+// a chosen variant that ea's selector selects.
+func (ea entryAnalyzer) containsSelected(name string) (bool, error) {
+	st, err := ea.subtree(name)
+	return st.selected, err
+}
+
+// mustUnfold reports whether the named source dir
+// must be installed as a real dir rather than a link.
+// It must be unfolded if ea's selector
+// declines to select any file in the dir,
+// if any file in the dir is a variant or a template,
+// or if ea's paths install any file in the dir
+// or in the dir's target somewhere other than the link would.
+func (ea entryAnalyzer) mustUnfold(name string) (bool, error) {
+	item := targetName(ea.root.withItemFrom(name).item, false)
+	if ea.paths.holds(ea.paths.apply(item)) {
+		// Some item is installed inside the target dir
+		// from elsewhere in the package.
+		return true, nil
+	}
+	st, err := ea.subtree(name)
+	return st.unfold, err
+}
+
+// A subtree describes the contents of a source dir.
+type subtree struct {
+	selected bool // The dir contains a file that ea would install.
+	unfold   bool // The dir contains a variant, a template, a remapped item, or an item that is not selected.
+}
+
+// subtree describes the contents of the named source dir.
+// It reads each dir in the subtree at most once,
+// and remembers the description of each dir it reads,
+// so that describing a dir's subdirs later reads nothing.
+func (ea entryAnalyzer) subtree(name string) (subtree, error) {
+	if st, ok := ea.subtrees[name]; ok {
+		return st, nil
+	}
+	entries, err := fs.ReadDir(ea.fsys, name)
+	if err != nil {
+		return subtree{}, err
+	}
+
+	var st subtree
+	for _, entry := range entries {
+		if st.selected && st.unfold {
+			// Nothing more in the dir can change its description.
+			break
+		}
+		n := path.Join(name, entry.Name())
+		item := ea.root.withItemFrom(n).item
+		regular := entry.Type().IsRegular()
+		target := targetName(item, regular)
+		selection := ea.selection(target)
+		if isVariant(item) || regular && target != stripConditions(item) ||
+			ea.paths.begins(target) || selection != selectItem {
+			st.unfold = true
+		}
+		if selection != selectNone {
+			chosen, err := ea.choose(n)
+			if err != nil {
+				return subtree{}, err
+			}
+			if !chosen {
+				selection = selectNone
+			}
+		}
+		if selection == selectItem {
+			st.selected = true
+		}
+
+		descend := selection == selectParent && !st.selected ||
+			selection == selectItem && !st.unfold
+		if !entry.IsDir() || !descend {
+			continue
+		}
+		sub, err := ea.subtree(n)
+		if err != nil {
+			return subtree{}, err
+		}
+		st.unfold = st.unfold || sub.unfold
+		if selection == selectParent {
+			st.selected = st.selected || sub.selected
+		}
+	}
+	if ea.subtrees != nil {
+		ea.subtrees[name] = st
+	}
+	return st, nil
+}
+
+// makeParents plans a directory for each ancestor of the target item
+// that does not exist.
+// Each existing or planned ancestor must be a directory.
+func (ea entryAnalyzer) makeParents(s sourceItem, t targetPath, l *slog.Logger) error {
+	var parents []string
+	for p := path.Dir(t.item); p != "."; p = path.Dir(p) {
+		parents = append(parents, p)
+	}
+	for _, p := range slices.Backward(parents) {
+		parent := newTargetPath(ea.target, p)
+		state, err := ea.index.state(parent, l)
+		if err != nil {
+			return err
+		}
+		switch {
+		case state.IsDir():
+		case state.IsNoFile():
+			ea.index.setState(parent, file.DirState(), l)
+		default:
+			return newConflictError(s, targetItem{parent, state})
+		}
+	}
+	return nil
+}
@@ -2,16 +2,22 @@ package plan
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io/fs"
 	"log/slog"
+	"path"
+	"slices"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 
+	"github.com/dhemery/duffel/internal/config"
 	"github.com/dhemery/duffel/internal/duftest"
 	"github.com/dhemery/duffel/internal/errfs"
+	"github.com/dhemery/duffel/internal/facts"
 	"github.com/dhemery/duffel/internal/file"
 	"github.com/dhemery/duffel/internal/log"
 )
@@ -19,10 +25,18 @@ import (
 func TestEntryAnalyzer(t *testing.T) {
 	itemAnalyzerSuite.run(t)
 	earlyExitSuite.run(t)
+	selectorSuite.run(t)
+	variantSuite.run(t)
 }
 
 type entryAnalyzerTest struct {
 	desc              string         // Description of the test.
+	selector          selector       // The selector for the entry analyzer.
+	wantParent        bool           // Whether to call AnalyzeParent instead of Analyze.
+	wantUnfold        bool           // Whether to call Unfold instead of Analyze.
+	sourceContents    []string       // Files in the source item dir, relative to the dir.
+	sourceSiblings    []string       // Other files in the source item's parent dir.
+	facts             facts.Facts    // If not nil, the facts for choosing variants.
 	targetItem        testTargetItem // The state of the target item in the index.
 	sourceItem        testSourceItem // The source item state passed to Analyze.
 	itemAnalyzerState file.State     // The state result from ItemAnalyzer.
@@ -111,6 +125,11 @@ var earlyExitSuite = entryAnalyzerSuite{
 			sourceItem: sourceDirItem("source", "pkg", "."),
 			wantErr:    nil,
 		},
+		{
+			desc:       "package config file",
+			sourceItem: sourceFileItem("source", "pkg", config.PackageFile),
+			wantErr:    nil,
+		},
 		{
 			desc:       "error arg for package directory",
 			sourceItem: sourceDirErrorItem("source", "pkg", ".", errPassedToAnalyze),
@@ -130,6 +149,154 @@ var earlyExitSuite = entryAnalyzerSuite{
 	},
 }
 
+// Scenarios where a selector selects only some items.
+var selectorSuite = entryAnalyzerSuite{
+	name: "Selector",
+	tests: []entryAnalyzerTest{
+		{
+			desc:              "selected item",
+			selector:          subtreeSelector("dir/item"),
+			targetItem:        targetNoFileItem("target", "dir/item"),
+			sourceItem:        sourceFileItem("source", "pkg", "dir/item"),
+			itemAnalyzerState: file.LinkState("item/func/dest", file.TypeFile),
+			wantState:         file.LinkState("item/func/dest", file.TypeFile),
+		},
+		{
+			desc:              "content of selected item",
+			selector:          subtreeSelector("dir"),
+			targetItem:        targetNoFileItem("target", "dir/item"),
+			sourceItem:        sourceFileItem("source", "pkg", "dir/item"),
+			itemAnalyzerState: file.LinkState("item/func/dest", file.TypeFile),
+			wantState:         file.LinkState("item/func/dest", file.TypeFile),
+		},
+		{
+			desc:              "parent of selected item",
+			selector:          subtreeSelector("dir/item"),
+			targetItem:        targetNoFileItem("target", "dir"),
+			sourceItem:        sourceDirItem("source", "pkg", "dir"),
+			sourceContents:    []string{"item"},
+			itemAnalyzerState: file.DirState(),
+			wantParent:        true,
+			wantState:         file.DirState(),
+		},
+		{
+			desc:       "unselected dir",
+			selector:   subtreeSelector("dir/item"),
+			targetItem: targetNoFileItem("target", "other"),
+			sourceItem: sourceDirItem("source", "pkg", "other"),
+			wantErr:    fs.SkipDir,
+		},
+		{
+			desc:       "unselected file",
+			selector:   subtreeSelector("dir/item"),
+			targetItem: targetNoFileItem("target", "dir/other"),
+			sourceItem: sourceFileItem("source", "pkg", "dir/other"),
+			wantErr:    nil,
+		},
+		{
+			desc:       "item whose name extends the selected item",
+			selector:   subtreeSelector("dir/item"),
+			targetItem: targetNoFileItem("target", "dir/item2"),
+			sourceItem: sourceFileItem("source", "pkg", "dir/item2"),
+			wantErr:    nil,
+		},
+		{
+			desc:              "selected dir with unselected contents",
+			selector:          &patternSelector{exclude: []string{"secret"}},
+			targetItem:        targetNoFileItem("target", "dir"),
+			sourceItem:        sourceDirItem("source", "pkg", "dir"),
+			sourceContents:    []string{"keep", "sub/secret"},
+			itemAnalyzerState: file.DirState(),
+			wantUnfold:        true,
+			wantState:         file.DirState(),
+		},
+		{
+			desc:              "selected dir with only selected contents",
+			selector:          &patternSelector{exclude: []string{"secret"}},
+			targetItem:        targetNoFileItem("target", "dir"),
+			sourceItem:        sourceDirItem("source", "pkg", "dir"),
+			sourceContents:    []string{"keep", "sub/keep"},
+			itemAnalyzerState: file.LinkState("item/func/dest", file.TypeDir),
+			wantState:         file.LinkState("item/func/dest", file.TypeDir),
+		},
+		{
+			desc:              "dir that contains a deeply nested include match",
+			selector:          &patternSelector{include: []string{"*.lua"}},
+			targetItem:        targetNoFileItem("target", "dir"),
+			sourceItem:        sourceDirItem("source", "pkg", "dir"),
+			sourceContents:    []string{"init.vim", "sub/lua/init.lua"},
+			itemAnalyzerState: file.DirState(),
+			wantParent:        true,
+			wantState:         file.DirState(),
+		},
+		{
+			desc:           "dir that contains no include match",
+			selector:       &patternSelector{include: []string{".bashrc"}},
+			targetItem:     targetNoFileItem("target", ".config"),
+			sourceItem:     sourceDirItem("source", "pkg", ".config"),
+			sourceContents: []string{"app/config", "app/deep/file"},
+			wantErr:        fs.SkipDir,
+		},
+		{
+			desc:           "dir that contains only excluded include matches",
+			selector:       &patternSelector{include: []string{"*.lua"}, exclude: []string{"old"}},
+			targetItem:     targetNoFileItem("target", "dir"),
+			sourceItem:     sourceDirItem("source", "pkg", "dir"),
+			sourceContents: []string{"old/init.lua"},
+			wantErr:        fs.SkipDir,
+		},
+		{
+			desc:       "file that may not contain selected items",
+			selector:   &patternSelector{include: []string{"*.lua"}},
+			targetItem: targetNoFileItem("target", "dir/init.vim"),
+			sourceItem: sourceFileItem("source", "pkg", "dir/init.vim"),
+			wantErr:    nil,
+		},
+	},
+}
+
+// Scenarios where the entry analyzer chooses among an item's variants.
+var variantSuite = entryAnalyzerSuite{
+	name: "Variant",
+	tests: []entryAnalyzerTest{
+		{
+			desc:              "chosen variant",
+			facts:             facts.Facts{facts.OS: "linux"},
+			sourceItem:        sourceFileItem("source", "pkg", "dir/.bashrc##os.linux"),
+			sourceSiblings:    []string{".bashrc"},
+			targetItem:        targetNoFileItem("target", "dir/.bashrc"),
+			itemAnalyzerState: file.LinkState("item/func/dest", file.TypeFile),
+			wantState:         file.LinkState("item/func/dest", file.TypeFile),
+		},
+		{
+			desc:           "item without conditions when a variant is chosen",
+			facts:          facts.Facts{facts.OS: "linux"},
+			sourceItem:     sourceFileItem("source", "pkg", "dir/.bashrc"),
+			sourceSiblings: []string{".bashrc##os.linux"},
+			targetItem:     targetNoFileItem("target", "dir/.bashrc"),
+			wantErr:        nil,
+		},
+		{
+			desc:           "unsatisfied variant dir",
+			facts:          facts.Facts{facts.OS: "linux"},
+			sourceItem:     sourceDirItem("source", "pkg", "dir##os.darwin"),
+			sourceSiblings: []string{"dir"},
+			targetItem:     targetNoFileItem("target", "dir"),
+			wantErr:        fs.SkipDir,
+		},
+		{
+			desc:              "dir that contains variants",
+			facts:             facts.Facts{facts.OS: "linux"},
+			sourceItem:        sourceDirItem("source", "pkg", "dir"),
+			sourceContents:    []string{"sub/.bashrc##os.linux"},
+			targetItem:        targetNoFileItem("target", "dir"),
+			itemAnalyzerState: file.DirState(),
+			wantUnfold:        true,
+			wantState:         file.DirState(),
+		},
+	},
+}
+
 type entryAnalyzerSuite struct {
 	name  string
 	tests []entryAnalyzerTest
@@ -154,11 +321,33 @@ func (test entryAnalyzerTest) run(t *testing.T) {
 			err:   test.itemAnalyzerError,
 		}
 
+		sourceFS := errfs.New()
+		if test.sourceItem.fmode.IsDir() {
+			errfs.AddDir(sourceFS, test.NameArg(), test.sourceItem.fmode.Perm())
+		} else {
+			errfs.AddFile(sourceFS, test.NameArg(), test.sourceItem.fmode.Perm())
+		}
+		for _, name := range test.sourceContents {
+			errfs.AddFile(sourceFS, path.Join(test.NameArg(), name), 0o644)
+		}
+		for _, name := range test.sourceSiblings {
+			errfs.AddFile(sourceFS, path.Join(path.Dir(test.NameArg()), name), 0o644)
+		}
+
+		var variants *variants
+		if test.facts != nil {
+			variants = newVariants(sourceFS, test.facts)
+		}
+
 		ea := entryAnalyzer{
+			ctx:          context.Background(),
+			fsys:         sourceFS,
 			root:         test.WalkRoot(),
 			target:       test.TargetDir(),
 			index:        &test.targetItem,
 			itemAnalyzer: testItemAnalyzer,
+			selector:     test.selector,
+			variants:     variants,
 			logger:       logger,
 		}
 
@@ -170,6 +359,12 @@ func (test entryAnalyzerTest) run(t *testing.T) {
 
 		test.targetItem.checkSetState(t, test.TargetPath(), test.wantState)
 		testItemAnalyzer.checkCall(t, test.SourceItem(), test.TargetItem())
+		if testItemAnalyzer.gotParent != test.wantParent {
+			t.Errorf("called AnalyzeParent: got %t, want %t", testItemAnalyzer.gotParent, test.wantParent)
+		}
+		if testItemAnalyzer.gotUnfold != test.wantUnfold {
+			t.Errorf("called Unfold: got %t, want %t", testItemAnalyzer.gotUnfold, test.wantUnfold)
+		}
 	})
 }
 
@@ -320,13 +515,25 @@ type testItemAnalyzer struct {
 	err       error       // Error to return from Analyze.
 	gotSource *sourceItem // SourceItem passed to Analyze.
 	gotTarget *targetItem // TargetItem passed to Analyze.
+	gotParent bool        // Whether the call was to AnalyzeParent.
+	gotUnfold bool        // Whether the call was to Unfold.
 }
 
-func (tia *testItemAnalyzer) analyze(gotSource sourceItem, gotTarget targetItem, l *slog.Logger) (file.State, error) {
+func (tia *testItemAnalyzer) analyze(_ context.Context, gotSource sourceItem, gotTarget targetItem, l *slog.Logger) (file.State, error) {
 	tia.gotSource, tia.gotTarget = &gotSource, &gotTarget
 	return tia.state, tia.err
 }
 
+func (tia *testItemAnalyzer) analyzeParent(ctx context.Context, gotSource sourceItem, gotTarget targetItem, l *slog.Logger) (file.State, error) {
+	tia.gotParent = true
+	return tia.analyze(ctx, gotSource, gotTarget, l)
+}
+
+func (tia *testItemAnalyzer) unfold(ctx context.Context, gotSource sourceItem, gotTarget targetItem, l *slog.Logger) (file.State, error) {
+	tia.gotUnfold = true
+	return tia.analyze(ctx, gotSource, gotTarget, l)
+}
+
 func (tia *testItemAnalyzer) checkCall(t *testing.T, wantSource sourceItem, wantTarget targetItem) {
 	t.Helper()
 	if tia.gotSource == nil {
@@ -339,3 +546,104 @@ func (tia *testItemAnalyzer) checkCall(t *testing.T, wantSource sourceItem, want
 		t.Errorf("item func target arg:\n%s", diff)
 	}
 }
+
+func TestEntryAnalyzerPrefetch(t *testing.T) {
+	testFS := errfs.New()
+	errfs.AddFile(testFS, "source/pkg/"+config.PackageFile, 0o644)
+	errfs.AddFile(testFS, "source/pkg/file", 0o644)
+	errfs.AddFile(testFS, "source/pkg/dir/file", 0o644)
+	errfs.AddFile(testFS, "source/pkg/other/file", 0o644)
+	errfs.AddFile(testFS, "target/dir/file", 0o644)
+	fsys := &readDirRecorder{FS: testFS}
+	testPrefetcher := &prefetchRecorder{}
+
+	ea := entryAnalyzer{
+		ctx:        context.Background(),
+		fsys:       fsys,
+		root:       newSourcePath("source", "pkg", ""),
+		target:     "target",
+		selector:   subtreeSelector("dir"),
+		prefetcher: testPrefetcher,
+		workers:    4,
+	}
+
+	ea.prefetch("source/pkg")
+
+	// The prefetcher reads the states only of selected items.
+	wantNames := []string{"target/dir"}
+	if diff := cmp.Diff(wantNames, testPrefetcher.names); diff != "" {
+		t.Errorf("prefetched names:\n%s", diff)
+	}
+	// Prefetch reads ahead the listings of the selected dir
+	// and of the target dir at which it is installed.
+	wantDirs := []string{"source/pkg", "source/pkg/dir", "target/dir"}
+	slices.Sort(fsys.dirs)
+	if diff := cmp.Diff(wantDirs, fsys.dirs); diff != "" {
+		t.Errorf("read dirs:\n%s", diff)
+	}
+}
+
+func TestEntryAnalyzerReadsEachSubtreeOnce(t *testing.T) {
+	testFS := errfs.New()
+	errfs.AddFile(testFS, "source/pkg/a/b/c/selected", 0o644)
+	errfs.AddFile(testFS, "source/pkg/a/b/c/other", 0o644)
+	errfs.AddFile(testFS, "source/pkg/a/b/other", 0o644)
+	fsys := &readDirRecorder{FS: testFS}
+
+	ea := entryAnalyzer{
+		ctx:      context.Background(),
+		fsys:     fsys,
+		root:     newSourcePath("source", "pkg", ""),
+		target:   "target",
+		selector: &patternSelector{include: []string{"selected"}},
+		subtrees: subtrees{},
+	}
+
+	// Ask about each dir from the top down, as the walk does.
+	for _, dir := range []string{"source/pkg/a", "source/pkg/a/b", "source/pkg/a/b/c"} {
+		selected, err := ea.containsSelected(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !selected {
+			t.Errorf("containsSelected(%s) is false, want true", dir)
+		}
+		unfold, err := ea.mustUnfold(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !unfold {
+			t.Errorf("mustUnfold(%s) is false, want true", dir)
+		}
+	}
+
+	wantDirs := []string{"source/pkg/a", "source/pkg/a/b", "source/pkg/a/b/c"}
+	slices.Sort(fsys.dirs)
+	if diff := cmp.Diff(wantDirs, fsys.dirs); diff != "" {
+		t.Errorf("read dirs:\n%s", diff)
+	}
+}
+
+// A prefetchRecorder is a prefetcher that records the names to prefetch.
+type prefetchRecorder struct {
+	names []string
+}
+
+func (p *prefetchRecorder) prefetch(names []string) {
+	p.names = append(p.names, names...)
+}
+
+// A readDirRecorder is a file system that records the names of the dirs it reads.
+// It is safe for concurrent use.
+type readDirRecorder struct {
+	*errfs.FS
+	mu   sync.Mutex
+	dirs []string
+}
+
+func (f *readDirRecorder) ReadDir(name string) ([]fs.DirEntry, error) {
+	f.mu.Lock()
+	f.dirs = append(f.dirs, name)
+	f.mu.Unlock()
+	return f.FS.ReadDir(name)
+}
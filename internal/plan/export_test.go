@@ -4,7 +4,7 @@ import (
 	"errors"
 )
 
-func (me *mergeError) Equal(o *mergeError) bool {
+func (me *MergeError) Equal(o *MergeError) bool {
 	if !sameNullity(me, o) {
 		return false
 	}
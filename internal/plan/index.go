@@ -4,6 +4,8 @@ import (
 	"iter"
 	"log/slog"
 	"maps"
+	"path"
+	"sync"
 
 	"github.com/dhemery/duffel/internal/file"
 )
@@ -15,17 +17,29 @@ type spec struct {
 }
 
 // newIndex returns a new, empty specIndex that reads file states from s.
-func newIndex(s stater) *specIndex {
+// The index prefetches states using up to the given number of workers.
+// See [specIndex.prefetch].
+func newIndex(s stater, workers int) *specIndex {
 	return &specIndex{
-		specs:  map[string]spec{},
-		stater: s,
+		specs:   map[string]spec{},
+		fetched: map[string]fetched{},
+		stater:  s,
+		workers: workers,
 	}
 }
 
 // A specIndex maintains a spec for each known file.
 type specIndex struct {
-	specs  map[string]spec
-	stater stater
+	specs   map[string]spec
+	fetched map[string]fetched // The prefetched states of files not yet in specs.
+	stater  stater
+	workers int // The maximum number of files whose states to read concurrently.
+}
+
+// A fetched is the result of reading a file's state ahead of need.
+type fetched struct {
+	state file.State
+	err   error
 }
 
 type stater interface {
@@ -38,13 +52,20 @@ type stater interface {
 // this method reads the current state of the file,
 // stores it as both the current and planned states,
 // and returns the state.
+// If the plan replaces a link in the file's path with a dir,
+// the file is inside a dir that the plan creates,
+// and so its current state is no file.
 func (i *specIndex) state(t targetPath, l *slog.Logger) (file.State, error) {
 	name := t.String()
 	s, ok := i.specs[name]
 	if !ok {
-		state, err := i.stater.State(name)
-		if err != nil {
-			return file.State{}, err
+		state := file.NoFileState()
+		if !i.replacesLinkAbove(name) {
+			var err error
+			state, err = i.read(name)
+			if err != nil {
+				return file.State{}, err
+			}
 		}
 		attrs := slog.GroupAttrs("target", slog.Any("path", t), slog.Any("file_state", state))
 		l.Debug("read target file state", attrs)
@@ -54,6 +75,83 @@ func (i *specIndex) state(t targetPath, l *slog.Logger) (file.State, error) {
 	return s.planned, nil
 }
 
+// read returns the current state of the named file,
+// either from the state prefetched for the file or from i's stater.
+func (i *specIndex) read(name string) (file.State, error) {
+	if f, ok := i.fetched[name]; ok {
+		delete(i.fetched, name)
+		return f.state, f.err
+	}
+	return i.stater.State(name)
+}
+
+// prefetch reads the current states of the named files concurrently,
+// using up to i's number of workers,
+// and holds the results for later calls to state.
+// Prefetch skips files whose states i already knows or holds,
+// and files inside a link that i plans to replace with a dir,
+// whose current states are not needed.
+// If i has fewer than two workers, prefetch does nothing.
+//
+// An error reading a file's state is held like a state,
+// and returned only if the state is needed.
+func (i *specIndex) prefetch(names []string) {
+	if i.workers < 2 {
+		return
+	}
+	var todo []string
+	for _, name := range names {
+		_, known := i.specs[name]
+		_, held := i.fetched[name]
+		if known || held || i.replacesLinkAbove(name) {
+			continue
+		}
+		i.fetched[name] = fetched{} // Reserve the name so that a duplicate is read only once.
+		todo = append(todo, name)
+	}
+
+	results := make([]fetched, len(todo))
+	concurrently(i.workers, len(todo), func(n int) {
+		state, err := i.stater.State(todo[n])
+		results[n] = fetched{state, err}
+	})
+
+	for n, name := range todo {
+		i.fetched[name] = results[n]
+	}
+}
+
+// concurrently calls f for each integer in [0, n),
+// running up to workers calls at once.
+func concurrently(workers, n int, f func(n int)) {
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, n) {
+		wg.Go(func() {
+			for n := range next {
+				f(n)
+			}
+		})
+	}
+	for n := range n {
+		next <- n
+	}
+	close(next)
+	wg.Wait()
+}
+
+// replacesLinkAbove reports whether i plans to replace
+// a link in the named file's path with a dir.
+func (i *specIndex) replacesLinkAbove(name string) bool {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		s, ok := i.specs[dir]
+		if ok && s.current.IsLink() && s.planned.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
 // setState sets the planned state of the target file.
 func (i *specIndex) setState(t targetPath, s file.State, l *slog.Logger) {
 	name := t.String()
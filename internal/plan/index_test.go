@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"maps"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -30,7 +31,7 @@ func TestIndex(t *testing.T) {
 		err:      nil,
 	}
 
-	index := newIndex(testStater)
+	index := newIndex(testStater, 1)
 
 	// First call returns the state from stater.
 	state, err := index.state(targetPath, logger)
@@ -81,7 +82,7 @@ func TestIndexStaterError(t *testing.T) {
 		err:      errors.New("error from stater"),
 	}
 
-	index := newIndex(testStater)
+	index := newIndex(testStater, 1)
 
 	_, err := index.state(targetPath, logger)
 
@@ -91,6 +92,36 @@ func TestIndexStaterError(t *testing.T) {
 	checkRecordedSpecs(t, ctx, index, wantSpecs)
 }
 
+func TestIndexReplacedLinkAbove(t *testing.T) {
+	var logbuf bytes.Buffer
+	logger := log.Logger(&logbuf, duftest.LogLevel)
+	defer duftest.Dump(t, "log", &logbuf)
+
+	dirPath := newTargetPath("target", "dir")
+	itemPath := newTargetPath("target", "dir/sub/item")
+
+	testStater := oneTimeStater{
+		t:        t,
+		wantName: dirPath.String(),
+		state:    file.LinkState("some/dest", file.TypeDir),
+	}
+
+	index := newIndex(testStater, 1)
+
+	if _, err := index.state(dirPath, logger); err != nil {
+		t.Fatal(err)
+	}
+	// Plan to replace the link with a dir.
+	index.setState(dirPath, file.DirState(), logger)
+
+	// The item is inside the planned dir, not the link's dest,
+	// so index must not call stater.
+	state, err := index.state(itemPath, logger)
+	ctx := "index.State() below replaced link"
+	checkState(t, ctx, state, file.NoFileState())
+	checkErr(t, ctx, err, nil)
+}
+
 func checkErr(t *testing.T, ctx string, got, want error) {
 	t.Helper()
 	if diff := cmp.Diff(want, got, cmpopts.EquateErrors()); diff != "" {
@@ -136,3 +167,128 @@ func (ots oneTimeStater) State(name string) (file.State, error) {
 
 	return ots.state, ots.err
 }
+
+func TestIndexPrefetch(t *testing.T) {
+	var logbuf bytes.Buffer
+	logger := log.Logger(&logbuf, duftest.LogLevel)
+	defer duftest.Dump(t, "log", &logbuf)
+
+	errStater := errors.New("error from stater")
+	testStater := &countingStater{
+		states: map[string]file.State{
+			"target/known": file.FileState(),
+			"target/dir":   file.DirState(),
+			"target/link":  file.LinkState("some/dest", file.TypeDir),
+		},
+		errs:  map[string]error{"target/bad": errStater},
+		calls: map[string]int{},
+	}
+
+	index := newIndex(testStater, 4)
+
+	known := newTargetPath("target", "known")
+	if _, err := index.state(known, logger); err != nil {
+		t.Fatal(err)
+	}
+	index.setState(known, file.DirState(), logger)
+
+	index.prefetch([]string{"target/known", "target/dir", "target/link", "target/bad", "target/dir", "target/none"})
+	index.prefetch([]string{"target/dir"})
+
+	wantCalls := map[string]int{
+		"target/known": 1, // Read before prefetch, and not again.
+		"target/dir":   1, // Read once despite duplicate and repeated prefetches.
+		"target/link":  1,
+		"target/bad":   1,
+		"target/none":  1,
+	}
+	if diff := cmp.Diff(wantCalls, testStater.calls); diff != "" {
+		t.Errorf("stater calls after prefetch:\n%s", diff)
+	}
+	// Prefetching does not record specs.
+	checkRecordedSpecs(t, "after prefetch", index, map[string]spec{
+		"target/known": {current: file.FileState(), planned: file.DirState()},
+	})
+
+	for _, test := range []struct {
+		item    string
+		want    file.State
+		wantErr error
+	}{
+		{item: "known", want: file.DirState()},
+		{item: "dir", want: file.DirState()},
+		{item: "link", want: file.LinkState("some/dest", file.TypeDir)},
+		{item: "bad", wantErr: errStater},
+		{item: "none", want: file.NoFileState()},
+	} {
+		state, err := index.state(newTargetPath("target", test.item), logger)
+		ctx := "index.State(" + test.item + ") after prefetch"
+		checkState(t, ctx, state, test.want)
+		checkErr(t, ctx, err, test.wantErr)
+	}
+	if diff := cmp.Diff(wantCalls, testStater.calls); diff != "" {
+		t.Errorf("stater calls after index.State():\n%s", diff)
+	}
+}
+
+func TestIndexPrefetchOneWorker(t *testing.T) {
+	testStater := &countingStater{calls: map[string]int{}}
+
+	index := newIndex(testStater, 1)
+	index.prefetch([]string{"target/item"})
+
+	if len(testStater.calls) != 0 {
+		t.Errorf("stater calls: %v, want none", testStater.calls)
+	}
+}
+
+func TestIndexPrefetchBelowReplacedLink(t *testing.T) {
+	var logbuf bytes.Buffer
+	logger := log.Logger(&logbuf, duftest.LogLevel)
+	defer duftest.Dump(t, "log", &logbuf)
+
+	testStater := &countingStater{
+		states: map[string]file.State{"target/dir": file.LinkState("some/dest", file.TypeDir)},
+		calls:  map[string]int{},
+	}
+
+	index := newIndex(testStater, 4)
+
+	dirPath := newTargetPath("target", "dir")
+	if _, err := index.state(dirPath, logger); err != nil {
+		t.Fatal(err)
+	}
+	// Plan to replace the link with a dir.
+	index.setState(dirPath, file.DirState(), logger)
+
+	// The items are inside the planned dir, not the link's dest,
+	// so index must not read their states.
+	index.prefetch([]string{"target/dir/item", "target/dir/sub/item"})
+
+	wantCalls := map[string]int{"target/dir": 1}
+	if diff := cmp.Diff(wantCalls, testStater.calls); diff != "" {
+		t.Errorf("stater calls:\n%s", diff)
+	}
+}
+
+// A countingStater is a Stater that counts calls for each name.
+// It is safe for concurrent use.
+type countingStater struct {
+	mu     sync.Mutex
+	states map[string]file.State // The state for each name. Unlisted names have no file.
+	errs   map[string]error      // The error for each name.
+	calls  map[string]int
+}
+
+func (cs *countingStater) State(name string) (file.State, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.calls[name]++
+	if err, ok := cs.errs[name]; ok {
+		return file.State{}, err
+	}
+	if s, ok := cs.states[name]; ok {
+		return s, nil
+	}
+	return file.NoFileState(), nil
+}
@@ -1,33 +1,58 @@
 package plan
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"log/slog"
+	"path"
 
 	"github.com/dhemery/duffel/internal/file"
 )
 
 type installMerger interface {
-	merge(name string, l *slog.Logger) error
+	merge(ctx context.Context, name string, t targetPath, l *slog.Logger) error
+}
+
+type installLayers interface {
+	rank(sourceItem, targetItem) (sourcePath, int, error)
+	ownerOf(targetItem) (sourcePath, bool, error)
+	shadow(t targetPath, winner, shadowed sourcePath)
+}
+
+type installRenderer interface {
+	render(sourceItem, targetItem, *slog.Logger) (file.State, error)
 }
 
 // installer describes the installed state
 // of the target item file that corresponds
 // to each given source item file.
 type installer struct {
-	merger installMerger
+	merger   installMerger
+	layers   installLayers
+	renderer installRenderer
+	fsys     fs.ReadLinkFS // Resolves link destinations. If nil, links are compared by their destination paths.
 }
 
 // analyze returns the state of the target item file
 // that would result from installing the source item file.
-func (i installer) analyze(s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+func (i installer) analyze(ctx context.Context, s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
 	var state file.State
 	targetPath := t.Path
 	targetState := t.State
 	sourceType := s.Type
 	itemAsDest := targetPath.PathTo(s.Path.String())
 
+	if isTemplate(s) {
+		// Render the template into a regular file instead of linking to it.
+		state, err := i.renderer.render(s, t, l)
+		if _, ok := err.(*ConflictError); ok {
+			// Identify the package item that the target links to, if any.
+			return state, i.conflict(s, t)
+		}
+		return state, err
+	}
+
 	if targetState.IsNoFile() {
 		// There is no target file, so we're free to create a link to the source item.
 		var err error
@@ -44,7 +69,7 @@ func (i installer) analyze(s sourceItem, t targetItem, l *slog.Logger) (file.Sta
 
 	if targetState.IsRegular() {
 		// Cannot modify an existing regular target file.
-		return state, &conflictError{Source: s, Target: t}
+		return state, i.conflict(s, t)
 	}
 
 	if targetState.IsDir() {
@@ -57,19 +82,23 @@ func (i installer) analyze(s sourceItem, t targetItem, l *slog.Logger) (file.Sta
 
 		// The target item is a dir, but the source item is not.
 		// Cannot merge the target dir with a non-dir source item.
-		return state, &conflictError{Source: s, Target: t}
+		return state, i.conflict(s, t)
 	}
 
 	if !targetState.IsLink() {
 		// Target item is not file, dir, or link.
-		return state, &conflictError{Source: s, Target: t}
+		return state, i.conflict(s, t)
 	}
 
 	// At this point, we know that the target is a symlink.
 
 	targetDest := targetState.Dest
 
-	if targetDest.Path == itemAsDest {
+	linked, err := i.linksTo(t, s)
+	if err != nil {
+		return state, err
+	}
+	if linked {
 		// The target symlink already points to the source item.
 		// There's nothing more to do.
 		var err error
@@ -80,7 +109,7 @@ func (i installer) analyze(s sourceItem, t targetItem, l *slog.Logger) (file.Sta
 		return targetState, err
 	}
 
-	if targetDest.IsNoFile() {
+	if targetDest.FinalType().IsNoFile() {
 		// The target links to nothing, so replace it with a link to the source item.
 		var err error
 		if sourceType.IsDir() {
@@ -91,22 +120,19 @@ func (i installer) analyze(s sourceItem, t targetItem, l *slog.Logger) (file.Sta
 		return file.LinkState(itemAsDest, sourceType), err
 	}
 
-	if !targetDest.IsDir() {
-		// The target item's link destination is not a dir. Cannot merge.
-		return state, &conflictError{Source: s, Target: t}
-	}
-
-	if !sourceType.IsDir() {
-		// Tne entry is not a dir. Cannot merge.
-		return state, &conflictError{Source: s, Target: t}
+	if !targetDest.FinalType().IsDir() || !sourceType.IsDir() {
+		// The target item's link destination or the source item is not a dir.
+		// Cannot merge, but the package with the higher priority
+		// may provide the target item.
+		return i.layer(s, t, l)
 	}
 
-	// The package item is a dir and the target is a link to a dir.
+	// The package item is a dir and the target is a link to a dir,
+	// perhaps through a chain of links.
 	// Try to merge the target item.
-	mergeDir := targetPath.resolve(targetState.Dest.Path)
+	mergeDir := t.dest()
 	l.Info("merging", slog.Any("source", s), slog.Any("target", t), slog.String("merge_dir", mergeDir))
-	err := i.merger.merge(mergeDir, l)
-	if err != nil {
+	if err := i.merger.merge(ctx, mergeDir, targetPath, l); err != nil {
 		return state, err
 	}
 
@@ -116,14 +142,180 @@ func (i installer) analyze(s sourceItem, t targetItem, l *slog.Logger) (file.Sta
 	return file.DirState(), nil
 }
 
-// A conflict error indicates that a source item conflicts with a target item
+// linksTo reports whether the target item is a link to the source item.
+// See [linksTo].
+func (i installer) linksTo(t targetItem, s sourceItem) (bool, error) {
+	return linksTo(i.fsys, t, s)
+}
+
+// linksTo reports whether the target item is a link to the source item.
+// The link need not have the destination path that installing the item would give it.
+// It links to the item if its destination and the item are the same entry
+// in the same physical dir. See [file.Physical].
+// So a link made through a symlink to the source or target
+// still links to the item.
+// If fsys is nil, linksTo compares only destination paths.
+func linksTo(fsys fs.ReadLinkFS, t targetItem, s sourceItem) (bool, error) {
+	if !t.State.IsLink() {
+		return false, nil
+	}
+	dest := t.State.Dest.Path
+	if dest == t.Path.PathTo(s.Path.String()) {
+		return true, nil
+	}
+	if fsys == nil {
+		return false, nil
+	}
+
+	if !path.IsAbs(dest) {
+		// Do not clean the joined name.
+		// A .. in dest leads from the physical dir that holds the link.
+		dest = t.Path.parent() + "/" + dest
+	}
+	destDir, destBase := path.Split(dest)
+	if destBase == "" || destBase == "." || destBase == ".." {
+		return false, nil
+	}
+	physDestDir, err := file.Physical(fsys, destDir)
+	if err != nil {
+		return false, err
+	}
+	physItemDir, err := file.Physical(fsys, path.Dir(s.Path.String()))
+	if err != nil {
+		return false, err
+	}
+	return physDestDir == physItemDir && destBase == path.Base(s.Path.String()), nil
+}
+
+// conflict returns a [ConflictError] for the source and target items.
+// If the target item links to an item in a package,
+// the error identifies the package and item.
+func (i installer) conflict(s sourceItem, t targetItem) error {
+	ce := newConflictError(s, t)
+	if !t.State.IsLink() {
+		return ce
+	}
+	owner, ok, err := i.layers.ownerOf(t)
+	if err != nil {
+		return err
+	}
+	if ok {
+		ce.Owner = owner.packageDir()
+		ce.OwnerItem = owner.item
+	}
+	return ce
+}
+
+// layer returns the state of the target item file
+// when the source item and the target item's link destination
+// both provide the target item, but cannot be merged.
+// If the source item's package outranks the destination's package,
+// the resulting state links to the source item.
+// If the destination's package outranks the source item's package,
+// the resulting state is the target's current state.
+// Otherwise the source item conflicts with the target item.
+func (i installer) layer(s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	owner, rank, err := i.layers.rank(s, t)
+	if err != nil {
+		return file.State{}, err
+	}
+
+	var skip error
+	if s.Type.IsDir() {
+		// Whichever item provides the target, do not walk the source item's contents.
+		skip = fs.SkipDir
+	}
+
+	switch {
+	case rank > 0:
+		l.Info("shadowing", slog.Any("source", s), slog.Any("target", t), slog.Any("shadowed", owner))
+		i.layers.shadow(t.Path, s.Path, owner)
+		return file.LinkState(t.Path.PathTo(s.Path.String()), s.Type), skip
+	case rank < 0:
+		l.Info("shadowed", slog.Any("source", s), slog.Any("target", t), slog.Any("shadowed_by", owner))
+		i.layers.shadow(t.Path, owner, s.Path)
+		return t.State, skip
+	}
+	return file.State{}, i.conflict(s, t)
+}
+
+// analyzeParent returns the state of the target item file
+// that would result from installing some of the contents
+// of the source item directory, but not the whole directory.
+// The resulting state is a directory,
+// unless the target item already links to the source item.
+func (i installer) analyzeParent(ctx context.Context, s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	targetState := t.State
+
+	if targetState.IsNoFile() || targetState.IsLink() && targetState.Dest.FinalType().IsNoFile() {
+		// There is no target file, or it links to nothing.
+		// Create a directory to hold the installed contents.
+		return file.DirState(), nil
+	}
+
+	linked, err := i.linksTo(t, s)
+	if err != nil {
+		return file.State{}, err
+	}
+	if linked {
+		// The target already links to the whole source item,
+		// and so already provides the selected contents.
+		return targetState, fs.SkipDir
+	}
+
+	return i.analyze(ctx, s, t, l)
+}
+
+// unfold returns the state of the target item file
+// that would result from installing some of the contents
+// of the source item directory, but not the whole directory.
+// The resulting state is a directory,
+// even if the target item already links to the source item.
+func (i installer) unfold(ctx context.Context, s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	linked, err := i.linksTo(t, s)
+	if err != nil {
+		return file.State{}, err
+	}
+	if linked {
+		// The target links to the whole source item.
+		// Replace the link with a directory to hold the selected contents.
+		return file.DirState(), nil
+	}
+
+	return i.analyzeParent(ctx, s, t, l)
+}
+
+// A ConflictError indicates that a source item conflicts with a target item
 // and cannot be installed.
-type conflictError struct {
-	Source sourceItem // The conflicting source item.
-	Target targetItem // The conflicting target item.
+type ConflictError struct {
+	Source      string     // The full path to the conflicting source item.
+	SourceType  file.Type  // The type of the conflicting source item.
+	Target      string     // The full path to the conflicting target item.
+	TargetState file.State // The existing or planned state of the target item.
+
+	// Owner is the full path to the package that provides the target item,
+	// or empty if the target item does not link to an item in a package.
+	// The link may exist in the target tree
+	// or be planned by an earlier goal.
+	Owner     string
+	OwnerItem string // The item in the Owner package that the target item links to.
 }
 
-func (ce *conflictError) Error() string {
-	return fmt.Sprintf("install conflict: source item %q is %s, target item %q is %s",
-		ce.Source.Path, ce.Source.Type, ce.Target.Path, ce.Target.State)
+// newConflictError returns a [ConflictError] for the source and target items.
+func newConflictError(s sourceItem, t targetItem) *ConflictError {
+	return &ConflictError{
+		Source:      s.Path.String(),
+		SourceType:  s.Type,
+		Target:      t.Path.String(),
+		TargetState: t.State,
+	}
+}
+
+func (ce *ConflictError) Error() string {
+	msg := fmt.Sprintf("install conflict: source item %q is %s, target item %q is %s",
+		ce.Source, ce.SourceType, ce.Target, ce.TargetState)
+	if ce.Owner != "" {
+		msg += fmt.Sprintf(", provided by item %q in package %q", ce.OwnerItem, ce.Owner)
+	}
+	return msg
 }
@@ -2,11 +2,13 @@ package plan
 
 import (
 	"bytes"
+	"context"
 	"io/fs"
 	"log/slog"
 	"testing"
 
 	"github.com/dhemery/duffel/internal/duftest"
+	"github.com/dhemery/duffel/internal/errfs"
 	"github.com/dhemery/duffel/internal/file"
 	"github.com/dhemery/duffel/internal/log"
 	"github.com/google/go-cmp/cmp"
@@ -17,15 +19,24 @@ func TestInstall(t *testing.T) {
 	entryAndStateSuite.run(t)
 	conflictSuite.run(t)
 	mergeSuite.run(t)
+	parentSuite.run(t)
+	unfoldSuite.run(t)
+	layerSuite.run(t)
+	templateSuite.run(t)
 }
 
 type installTest struct {
-	desc       string      // Description of the test.
-	sourceItem sourceItem  // The state of the source item.
-	targetItem targetItem  // The state of the target item as of any earlier planning.
-	merger     *testMerger // The merger for the installer to call.
-	wantState  file.State  // State result.
-	wantErr    error       // Error result.
+	desc       string        // Description of the test.
+	parent     bool          // Whether to analyze the source item as a parent of selected items.
+	unfold     bool          // Whether to analyze the source item as a dir to unfold.
+	sourceItem sourceItem    // The state of the source item.
+	targetItem targetItem    // The state of the target item as of any earlier planning.
+	merger     *testMerger   // The merger for the installer to call.
+	layers     *testLayers   // The layers for the installer to call.
+	renderer   *testRenderer // The renderer for the installer to call.
+	files      []*errfs.File // Files on the file system. If empty, the installer has no file system.
+	wantState  file.State    // State result.
+	wantErr    error         // Error result.
 }
 
 // Simpler scenarios that do not involve merging or conflicts.
@@ -64,6 +75,13 @@ var entryAndStateSuite = installSuite{
 				file.LinkState("link/to/nowhere", file.TypeNoFile)),
 			wantState: file.LinkState("../source/pkg/item", file.TypeFile),
 		},
+		{
+			desc:       "existing target is link to link to nowhere",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item",
+				chainState("../hop", "link/to/nowhere", file.TypeNoFile)),
+			wantState: file.LinkState("../source/pkg/item", file.TypeFile),
+		},
 		{
 			desc:       "install dir item contents to existing target dir",
 			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
@@ -95,6 +113,43 @@ var entryAndStateSuite = installSuite{
 			wantState: file.LinkState("../../../../source/pkg/dir/sub1/sub2/item", file.TypeFile),
 			wantErr:   nil,
 		},
+		{
+			desc: "target links to item through a symlink to the source",
+			files: []*errfs.File{
+				errfs.NewLink("home/dotfiles", "../data/dotfiles"),
+				errfs.NewFile("data/dotfiles/pkg/item", 0o644),
+			},
+			sourceItem: newSourceItem("data/dotfiles", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("home", "item", file.LinkState("dotfiles/pkg/item", file.TypeFile)),
+			wantState:  file.LinkState("dotfiles/pkg/item", file.TypeFile),
+		},
+		{
+			desc: "target links to dir item through a symlink to the source",
+			files: []*errfs.File{
+				errfs.NewLink("home/dotfiles", "/data/dotfiles"),
+				errfs.NewDir("data/dotfiles/pkg/item", 0o755),
+			},
+			sourceItem: newSourceItem("data/dotfiles", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("home", "item", file.LinkState("dotfiles/pkg/item", file.TypeDir)),
+			wantState:  file.LinkState("dotfiles/pkg/item", file.TypeDir),
+			wantErr:    fs.SkipDir,
+		},
+		{
+			desc: "target links to a file with the item's name in another dir",
+			files: []*errfs.File{
+				errfs.NewFile("home/other/pkg/item", 0o644),
+				errfs.NewFile("data/dotfiles/pkg/item", 0o644),
+			},
+			sourceItem: newSourceItem("data/dotfiles", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("home", "item", file.LinkState("other/pkg/item", file.TypeFile)),
+			layers:     &testLayers{},
+			wantErr: &ConflictError{
+				Source:      "data/dotfiles/pkg/item",
+				SourceType:  file.TypeFile,
+				Target:      "home/item",
+				TargetState: file.LinkState("other/pkg/item", file.TypeFile),
+			},
+		},
 	},
 }
 
@@ -117,8 +172,17 @@ var mergeSuite = installSuite{
 			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
 			targetItem: newTargetItem("target", "item",
 				file.LinkState("../duffel/source-dir", file.TypeDir)),
-			merger:  mergeFails(&mergeError{Dir: "duffel/source-dir", Err: errIsSource}),
-			wantErr: &mergeError{Dir: "duffel/source-dir", Err: errIsSource},
+			merger:  mergeFails(&MergeError{Dir: "duffel/source-dir", Err: errIsSource}),
+			wantErr: &MergeError{Dir: "duffel/source-dir", Err: errIsSource},
+		},
+		{
+			desc:       "merge dir at end of link chain",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item",
+				chainState("../hop", "duffel/source-dir", file.TypeDir)),
+			merger:    mergeSucceeds("duffel/source-dir"),
+			wantState: file.DirState(),
+			wantErr:   nil,
 		},
 	},
 }
@@ -132,46 +196,253 @@ var conflictSuite = installSuite{
 			desc:       "target is a file, source is a dir",
 			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
 			targetItem: newTargetItem("target", "item", file.FileState()),
-			wantErr: &conflictError{
+			wantErr: newConflictError(
 				sourceItem{newSourcePath("source", "pkg", "item"), file.TypeDir},
 				targetItem{newTargetPath("target", "item"), file.FileState()},
-			},
+			),
 		},
 		{
 			desc:       "target links to a non-dir, source is a dir",
 			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
 			targetItem: newTargetItem("target", "item",
 				file.LinkState("link/to/file", file.TypeFile)),
-			wantErr: &conflictError{
+			wantErr: newConflictError(
 				sourceItem{newSourcePath("source", "pkg", "item"), file.TypeDir},
 				targetItem{
 					newTargetPath("target", "item"),
 					file.LinkState("link/to/file", file.TypeFile),
 				},
-			},
+			),
 		},
 		{
 			desc:       "target is a dir, source is not a dir",
 			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
 			targetItem: newTargetItem("target", "item", file.DirState()),
-			wantErr: &conflictError{
+			wantErr: newConflictError(
 				sourceItem{newSourcePath("source", "pkg", "item"), file.TypeFile},
 				targetItem{newTargetPath("target", "item"), file.DirState()},
-			},
+			),
 		},
 		{
 			desc:       "target links to a dir, source is not a dir",
 			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
 			targetItem: newTargetItem("target", "item",
 				file.LinkState("target/some/dest", file.TypeDir)),
-			wantErr: &conflictError{
+			wantErr: newConflictError(
 				sourceItem{newSourcePath("source", "pkg", "item"), file.TypeFile},
 				targetItem{
 					newTargetPath("target", "item"),
 					file.LinkState("target/some/dest", file.TypeDir),
 				},
+			),
+		},
+	},
+}
+
+// Scenarios where the source item is the parent of selected items,
+// and so must be installed as a directory.
+var parentSuite = installSuite{
+	name: "Parent",
+	tests: []installTest{
+		{
+			desc:       "no target file",
+			parent:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.NoFileState()),
+			wantState:  file.DirState(),
+			wantErr:    nil, // Walk the dir to install the selected items.
+		},
+		{
+			desc:       "target links to nowhere",
+			parent:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item",
+				file.LinkState("link/to/nowhere", file.TypeNoFile)),
+			wantState: file.DirState(),
+			wantErr:   nil,
+		},
+		{
+			desc:       "target is a dir",
+			parent:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.DirState()),
+			wantState:  file.DirState(),
+			wantErr:    nil,
+		},
+		{
+			desc:       "target already links to the source item",
+			parent:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item",
+				file.LinkState("../source/pkg/item", file.TypeDir)),
+			wantState: file.LinkState("../source/pkg/item", file.TypeDir),
+			wantErr:   fs.SkipDir, // The link already provides the selected items.
+		},
+		{
+			desc:       "target links to a dir in another package",
+			parent:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item",
+				file.LinkState("../duffel/source-dir", file.TypeDir)),
+			merger:    mergeSucceeds("duffel/source-dir"),
+			wantState: file.DirState(),
+			wantErr:   nil,
+		},
+		{
+			desc:       "target is a file",
+			parent:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.FileState()),
+			wantErr: newConflictError(
+				sourceItem{newSourcePath("source", "pkg", "item"), file.TypeDir},
+				targetItem{newTargetPath("target", "item"), file.FileState()},
+			),
+		},
+	},
+}
+
+// Scenarios where the installer unfolds a dir to install only some of its contents.
+var unfoldSuite = installSuite{
+	name: "Unfold",
+	tests: []installTest{
+		{
+			desc:       "no target file",
+			unfold:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.NoFileState()),
+			wantState:  file.DirState(),
+			wantErr:    nil, // Walk the dir to install the selected items.
+		},
+		{
+			desc:       "target already links to the source item",
+			unfold:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item",
+				file.LinkState("../source/pkg/item", file.TypeDir)),
+			wantState: file.DirState(), // The link would install unselected items.
+			wantErr:   nil,
+		},
+		{
+			desc:       "target is a file",
+			unfold:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.FileState()),
+			wantErr: newConflictError(
+				sourceItem{newSourcePath("source", "pkg", "item"), file.TypeDir},
+				targetItem{newTargetPath("target", "item"), file.FileState()},
+			),
+		},
+	},
+}
+
+// Scenarios where the source item and the target's link destination
+// cannot be merged, and the installer consults the packages' priorities.
+var layerSuite = installSuite{
+	name: "Layer",
+	tests: []installTest{
+		{
+			desc:       "source package outranks linked package",
+			sourceItem: newSourceItem("source", "override", ".gitconfig", file.TypeFile),
+			targetItem: newTargetItem("target", ".gitconfig",
+				file.LinkState("../source/base/.gitconfig", file.TypeFile)),
+			layers: &testLayers{
+				owner:  newSourcePath("source", "base", ".gitconfig"),
+				result: 1,
+				wantShadow: &shadowArgs{
+					winner:   newSourcePath("source", "override", ".gitconfig"),
+					shadowed: newSourcePath("source", "base", ".gitconfig"),
+				},
+			},
+			wantState: file.LinkState("../source/override/.gitconfig", file.TypeFile),
+		},
+		{
+			desc:       "linked package outranks source package",
+			sourceItem: newSourceItem("source", "base", ".gitconfig", file.TypeFile),
+			targetItem: newTargetItem("target", ".gitconfig",
+				file.LinkState("../source/override/.gitconfig", file.TypeFile)),
+			layers: &testLayers{
+				owner:  newSourcePath("source", "override", ".gitconfig"),
+				result: -1,
+				wantShadow: &shadowArgs{
+					winner:   newSourcePath("source", "override", ".gitconfig"),
+					shadowed: newSourcePath("source", "base", ".gitconfig"),
+				},
+			},
+			wantState: file.LinkState("../source/override/.gitconfig", file.TypeFile),
+		},
+		{
+			desc:       "source dir outranks linked file",
+			sourceItem: newSourceItem("source", "override", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item",
+				file.LinkState("../source/base/item", file.TypeFile)),
+			layers: &testLayers{
+				owner:  newSourcePath("source", "base", "item"),
+				result: 1,
+				wantShadow: &shadowArgs{
+					winner:   newSourcePath("source", "override", "item"),
+					shadowed: newSourcePath("source", "base", "item"),
+				},
+			},
+			wantState: file.LinkState("../source/override/item", file.TypeDir),
+			wantErr:   fs.SkipDir, // Linking to the dir installs its contents.
+		},
+		{
+			desc:       "neither package outranks the other",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item",
+				file.LinkState("../source/other/item", file.TypeFile)),
+			layers: &testLayers{owner: newSourcePath("source", "other", "item")},
+			wantErr: &ConflictError{
+				Source:      "source/pkg/item",
+				SourceType:  file.TypeFile,
+				Target:      "target/item",
+				TargetState: file.LinkState("../source/other/item", file.TypeFile),
+				Owner:       "source/other",
+				OwnerItem:   "item",
 			},
 		},
+		{
+			desc:       "rank error",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item",
+				file.LinkState("../source/other/item", file.TypeFile)),
+			layers:  &testLayers{err: fs.ErrPermission},
+			wantErr: fs.ErrPermission,
+		},
+	},
+}
+
+// Scenarios where the source item is a template.
+var templateSuite = installSuite{
+	name: "Template",
+	tests: []installTest{
+		{
+			desc:       "render template",
+			sourceItem: newSourceItem("source", "pkg", ".gitconfig.tmpl", file.TypeFile),
+			targetItem: newTargetItem("target", ".gitconfig", file.NoFileState()),
+			renderer:   &testRenderer{state: file.RenderedState("rendered", 0o644)},
+			wantState:  file.RenderedState("rendered", 0o644),
+		},
+		{
+			desc:       "render error",
+			sourceItem: newSourceItem("source", "pkg", ".gitconfig.tmpl", file.TypeFile),
+			targetItem: newTargetItem("target", ".gitconfig", file.NoFileState()),
+			renderer:   &testRenderer{err: fs.ErrInvalid},
+			wantErr:    fs.ErrInvalid,
+		},
+		{
+			desc:       "template variant",
+			sourceItem: newSourceItem("source", "pkg", ".gitconfig.tmpl##os.linux", file.TypeFile),
+			targetItem: newTargetItem("target", ".gitconfig", file.NoFileState()),
+			renderer:   &testRenderer{state: file.RenderedState("rendered", 0o644)},
+			wantState:  file.RenderedState("rendered", 0o644),
+		},
+		{
+			desc:       "link to template symlink",
+			sourceItem: newSourceItem("source", "pkg", "item.tmpl", file.TypeSymlink),
+			targetItem: newTargetItem("target", "item.tmpl", file.NoFileState()),
+			wantState:  file.LinkState("../source/pkg/item.tmpl", file.TypeSymlink),
+		},
 	},
 }
 
@@ -194,16 +465,31 @@ func (test installTest) run(t *testing.T) {
 		logger := log.Logger(&logbuf, duftest.LogLevel)
 		defer duftest.Dump(t, "log", &logbuf)
 
-		install := &installer{test.merger}
+		install := &installer{test.merger, test.layers, test.renderer, nil}
+		if len(test.files) > 0 {
+			testFS := errfs.New()
+			for _, f := range test.files {
+				errfs.Add(testFS, f)
+			}
+			install.fsys = testFS
+		}
+
+		analyze := install.analyze
+		switch {
+		case test.parent:
+			analyze = install.analyzeParent
+		case test.unfold:
+			analyze = install.unfold
+		}
 
-		gotState, gotErr := install.analyze(test.sourceItem, test.targetItem, logger)
+		gotState, gotErr := analyze(context.Background(), test.sourceItem, test.targetItem, logger)
 
 		if diff := cmp.Diff(test.wantState, gotState); diff != "" {
 			t.Errorf("state:\n%s", diff)
 		}
 
 		switch want := test.wantErr.(type) {
-		case *conflictError, *mergeError:
+		case *ConflictError, *MergeError:
 			if diff := cmp.Diff(want, gotErr, cmpopts.EquateComparable(sourcePath{}, targetPath{})); diff != "" {
 				t.Errorf("error:\n%s", diff)
 			}
@@ -214,6 +500,7 @@ func (test installTest) run(t *testing.T) {
 		}
 
 		test.merger.checkCall(t)
+		test.layers.checkShadow(t)
 	})
 }
 
@@ -232,11 +519,11 @@ func mergeSucceeds(name string) *testMerger {
 	return &testMerger{wantCall: &mergeArgs{name, nil}}
 }
 
-func mergeFails(e *mergeError) *testMerger {
+func mergeFails(e *MergeError) *testMerger {
 	return &testMerger{wantCall: &mergeArgs{e.Dir, e}}
 }
 
-func (m *testMerger) merge(gotName string, _ *slog.Logger) error {
+func (m *testMerger) merge(_ context.Context, gotName string, _ targetPath, _ *slog.Logger) error {
 	m.gotCall = true
 	m.gotName = gotName
 	if m.wantCall != nil {
@@ -265,3 +552,75 @@ func (m *testMerger) checkCall(t *testing.T) {
 		t.Errorf("Merge() called with %q, want %q", m.gotName, m.wantCall.name)
 	}
 }
+
+type shadowArgs struct {
+	winner   sourcePath
+	shadowed sourcePath
+}
+
+// testLayers is an installLayers for tests.
+// A nil *testLayers ranks every pair of packages equally.
+type testLayers struct {
+	owner      sourcePath  // Owner to return from rank and ownerOf.
+	result     int         // Result to return from rank.
+	err        error       // Error to return from rank.
+	wantShadow *shadowArgs // Args for the wanted call to shadow, if any.
+	gotShadow  *shadowArgs // Args passed to shadow.
+}
+
+func (tl *testLayers) rank(sourceItem, targetItem) (sourcePath, int, error) {
+	if tl == nil {
+		return sourcePath{}, 0, nil
+	}
+	return tl.owner, tl.result, tl.err
+}
+
+func (tl *testLayers) ownerOf(targetItem) (sourcePath, bool, error) {
+	if tl == nil || tl.owner == (sourcePath{}) {
+		return sourcePath{}, false, nil
+	}
+	return tl.owner, true, nil
+}
+
+func (tl *testLayers) shadow(_ targetPath, winner, shadowed sourcePath) {
+	tl.gotShadow = &shadowArgs{winner, shadowed}
+}
+
+func (tl *testLayers) checkShadow(t *testing.T) {
+	t.Helper()
+	if tl == nil {
+		return
+	}
+	if diff := cmp.Diff(tl.wantShadow, tl.gotShadow, cmp.AllowUnexported(shadowArgs{}, sourcePath{})); diff != "" {
+		t.Errorf("shadow args:\n%s", diff)
+	}
+}
+
+// testRenderer is an installRenderer, an uninstallRenderer,
+// and a statusRenderer for tests.
+type testRenderer struct {
+	state      file.State // State to return from render or remove.
+	isRendered bool       // Result to return from rendered.
+	err        error      // Error to return from render, remove, or rendered.
+}
+
+func (tr *testRenderer) render(sourceItem, targetItem, *slog.Logger) (file.State, error) {
+	return tr.state, tr.err
+}
+
+func (tr *testRenderer) remove(sourceItem, targetItem, *slog.Logger) (file.State, error) {
+	return tr.state, tr.err
+}
+
+func (tr *testRenderer) rendered(sourceItem, targetItem) (bool, error) {
+	return tr.isRendered, tr.err
+}
+
+// chainState returns the state of a link to the symlink dest,
+// which resolves through a chain of links to a file of type resolvedType at resolved.
+func chainState(dest, resolved string, resolvedType file.Type) file.State {
+	state := file.LinkState(dest, file.TypeSymlink)
+	state.Dest.Resolved = resolved
+	state.Dest.ResolvedType = resolvedType
+	return state
+}
@@ -3,12 +3,14 @@ package plan
 import (
 	"errors"
 	"io/fs"
+	"path"
 	"strings"
 
 	"github.com/dhemery/duffel/internal/file"
 )
 
 var (
+	errIsGroup      = errors.New("is a duffel package group")
 	errIsPackage    = errors.New("is a duffel package")
 	errIsSource     = errors.New("is a duffel source")
 	errNotInPackage = errors.New("not in a duffel package")
@@ -22,7 +24,13 @@ type itemizer struct {
 // If the file is not in a duffel source directory,
 // the method returns an error.
 func (i itemizer) itemize(name string) (sourcePath, error) {
-	source, err := file.SourceDir(i.fsys, name)
+	dir := name
+	if info, err := i.fsys.Lstat(name); err == nil && !info.IsDir() {
+		// A file that is not a dir cannot be a source dir,
+		// but may be inside one.
+		dir = path.Dir(name)
+	}
+	source, err := file.SourceDir(i.fsys, dir)
 	if errors.Is(err, fs.ErrNotExist) {
 		return sourcePath{}, errNotInPackage
 	}
@@ -35,11 +43,28 @@ func (i itemizer) itemize(name string) (sourcePath, error) {
 		return sourcePath{}, errIsSource
 	}
 
-	pkgItem := name[len(source)+1:]
-	pkg, item, found := strings.Cut(pkgItem, "/")
-	if !found {
-		return sourcePath{}, errIsPackage
-	}
+	// Each leading component that names a group
+	// is part of the package name.
+	rest := name[len(source)+1:]
+	var pkg string
+	for {
+		var found bool
+		var dir string
+		dir, rest, found = strings.Cut(rest, "/")
+		pkg = path.Join(pkg, dir)
 
-	return newSourcePath(source, pkg, item), nil
+		isGroup, err := file.IsGroup(i.fsys, path.Join(source, pkg))
+		if err != nil {
+			return sourcePath{}, err
+		}
+
+		switch {
+		case !found && isGroup:
+			return sourcePath{}, errIsGroup
+		case !found:
+			return sourcePath{}, errIsPackage
+		case !isGroup:
+			return newSourcePath(source, pkg, rest), nil
+		}
+	}
 }
@@ -13,7 +13,9 @@ import (
 func TestItemizer(t *testing.T) {
 	tests := map[string]struct {
 		sourceDir      string     // The duffel source dir in the file system.
+		groups         []string   // Package group dirs in the file system.
 		nameArg        string     // The name passed to Itemize.
+		nameIsFile     bool       // Whether the named file is a regular file instead of a dir.
 		wantSourcePath sourcePath // The SourcePath result from Itemize.
 		wantErr        error      // The error result from Itemize.
 	}{
@@ -37,6 +39,45 @@ func TestItemizer(t *testing.T) {
 			nameArg:        "user/home/source/pkg/item",
 			wantSourcePath: newSourcePath("user/home/source", "pkg", "item"),
 		},
+		"group": {
+			sourceDir: "user/home/source",
+			groups:    []string{"user/home/source/group"},
+			nameArg:   "user/home/source/group",
+			wantErr:   errIsGroup,
+		},
+		"package in a group": {
+			sourceDir: "user/home/source",
+			groups:    []string{"user/home/source/group"},
+			nameArg:   "user/home/source/group/pkg",
+			wantErr:   errIsPackage,
+		},
+		"item in a package in a group": {
+			sourceDir:      "user/home/source",
+			groups:         []string{"user/home/source/group"},
+			nameArg:        "user/home/source/group/pkg/item1/item2",
+			wantSourcePath: newSourcePath("user/home/source", "group/pkg", "item1/item2"),
+		},
+		"item in a package in a nested group": {
+			sourceDir: "user/home/source",
+			groups: []string{
+				"user/home/source/group1",
+				"user/home/source/group1/group2",
+			},
+			nameArg:        "user/home/source/group1/group2/pkg/item",
+			wantSourcePath: newSourcePath("user/home/source", "group1/group2/pkg", "item"),
+		},
+		"file in a package": {
+			sourceDir:      "user/home/source",
+			nameArg:        "user/home/source/pkg/item1/item2",
+			nameIsFile:     true,
+			wantSourcePath: newSourcePath("user/home/source", "pkg", "item1/item2"),
+		},
+		"file not in a source dir": {
+			sourceDir:  "elsewhere",
+			nameArg:    "dir1/dir2/file",
+			nameIsFile: true,
+			wantErr:    errNotInPackage,
+		},
 		"deep in a package": {
 			sourceDir:      "user/home/source",
 			nameArg:        "user/home/source/pkg/item1/item2/item3",
@@ -46,10 +87,17 @@ func TestItemizer(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			testFS := errfs.New()
-			errfs.AddDir(testFS, test.nameArg, 0o755)
+			if test.nameIsFile {
+				errfs.AddFile(testFS, test.nameArg, 0o644)
+			} else {
+				errfs.AddDir(testFS, test.nameArg, 0o755)
+			}
 			if test.sourceDir != "" {
 				errfs.AddFile(testFS, path.Join(test.sourceDir, file.SourceMarkerFile), 0o644)
 			}
+			for _, group := range test.groups {
+				errfs.AddFile(testFS, path.Join(group, file.GroupMarkerFile), 0o644)
+			}
 
 			itemizer := itemizer{testFS}
 
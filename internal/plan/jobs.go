@@ -0,0 +1,160 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/dhemery/duffel/internal/file"
+)
+
+// A job executes a task on an item in a target tree.
+type job struct {
+	fsys   file.ActionFS // The file system confined to the item's target tree.
+	target string        // The target tree that contains the item.
+	item   string        // The item to change.
+	task   Task          // The task to execute on the item.
+	deps   int           // The number of unfinished jobs that must precede this one.
+	next   []int         // The jobs that depend on this one.
+}
+
+// newJobs returns a job for each task in p, in plan order.
+// Subs maps each target to the file system confined to the target tree.
+// Each job depends on the jobs for the tasks that its task depends on.
+// See [Tasks.dependencies].
+func newJobs(p Plan, subs map[string]file.ActionFS) ([]*job, error) {
+	var jobs []*job
+	for _, target := range slices.Sorted(maps.Keys(p.Targets)) {
+		tasks := p.Targets[target]
+		items, err := tasks.order()
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", target, err)
+		}
+		deps, err := tasks.dependencies()
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", target, err)
+		}
+
+		index := map[string]int{}
+		for _, item := range items {
+			index[item] = len(jobs)
+			jobs = append(jobs, &job{fsys: subs[target], target: target, item: item, task: tasks[item]})
+		}
+		for _, item := range items {
+			j := jobs[index[item]]
+			for _, dep := range deps[item] {
+				d := jobs[index[dep]]
+				d.next = append(d.next, index[item])
+				j.deps++
+			}
+		}
+	}
+	return jobs, nil
+}
+
+// runJobs executes the jobs, running up to n jobs at a time.
+// A job starts only after every job it depends on has finished.
+// Among the jobs that are ready to start,
+// runJobs starts the earliest in the jobs slice.
+// If a job fails, runJobs starts no more jobs,
+// waits for the running jobs to finish,
+// and returns the errors in the order of the failed jobs in the slice.
+//
+// If ctx is done before every job has started,
+// runJobs starts no more jobs, waits for the running jobs to finish,
+// and includes a [*CanceledError] that lists the finished jobs.
+// A job that has started runs all of its task's actions,
+// so that no item is left partly changed.
+func runJobs(ctx context.Context, jobs []*job, n int) error {
+	n = max(n, 1)
+	type result struct {
+		job int
+		err error
+	}
+	done := make(chan result)
+
+	var ready []int
+	for i, j := range jobs {
+		if j.deps == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	var failed []result
+	var finished []int
+	running := 0
+	for {
+		for len(failed) == 0 && ctx.Err() == nil && running < n && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				done <- result{i, jobs[i].task.Execute(jobs[i].fsys, jobs[i].item)}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+
+		r := <-done
+		running--
+		if r.err != nil {
+			failed = append(failed, r)
+			continue
+		}
+		finished = append(finished, r.job)
+		for _, next := range jobs[r.job].next {
+			jobs[next].deps--
+			if jobs[next].deps == 0 {
+				pos, _ := slices.BinarySearch(ready, next)
+				ready = slices.Insert(ready, pos, next)
+			}
+		}
+	}
+
+	slices.SortFunc(failed, func(a, b result) int { return a.job - b.job })
+	var errs []error
+	for _, r := range failed {
+		errs = append(errs, r.err)
+	}
+	if len(finished)+len(failed) < len(jobs) && ctx.Err() != nil {
+		ce := &CanceledError{Finished: map[string][]string{}, Err: context.Cause(ctx)}
+		for _, i := range finished {
+			ce.Finished[jobs[i].target] = append(ce.Finished[jobs[i].target], jobs[i].item)
+		}
+		errs = append(errs, ce)
+	}
+	return errors.Join(errs...)
+}
+
+// A CanceledError indicates that the execution of a plan was canceled
+// before every task was executed.
+type CanceledError struct {
+	// Finished maps each target
+	// to the items whose tasks finished before execution stopped,
+	// in the order in which they finished.
+	Finished map[string][]string
+
+	Err error // The cause of the cancellation.
+}
+
+func (ce *CanceledError) Error() string {
+	var items []string
+	for _, target := range slices.Sorted(maps.Keys(ce.Finished)) {
+		for _, item := range ce.Finished[target] {
+			items = append(items, path.Join(target, item))
+		}
+	}
+	if len(items) == 0 {
+		return fmt.Sprintf("plan canceled before any task finished: %s", ce.Err)
+	}
+	return fmt.Sprintf("plan canceled: %s: finished tasks for %s", ce.Err, strings.Join(items, ", "))
+}
+
+func (ce *CanceledError) Unwrap() error {
+	return ce.Err
+}
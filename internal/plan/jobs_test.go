@@ -0,0 +1,226 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/dhemery/duffel/internal/file"
+)
+
+func TestRunJobs(t *testing.T) {
+	errA := errors.New("error from a")
+	errB := errors.New("error from b")
+	tests := map[string]struct {
+		tasks       Tasks
+		jobs        int
+		fail        map[string]error  // The error to return for each item.
+		after       map[string]string // The item whose task must finish before each item's task finishes.
+		barrier     int               // The number of tasks that must start before any task finishes.
+		cancelAt    string            // The item whose task cancels the context, or "-" to cancel before running.
+		wantDone    []string          // The items whose tasks ran, in order of completion, if the order is determined.
+		wantRan     []string          // The items whose tasks ran, if the order is not determined.
+		wantRunning int               // The maximum number of concurrent tasks.
+		wantErrs    []error           // The errors, in order.
+	}{
+		"one job at a time in plan order": {
+			tasks:       Tasks{"b": link, "a": mkdir, "a/x": link, "c": remove, "c/x": remove},
+			jobs:        1,
+			wantDone:    []string{"a", "a/x", "b", "c/x", "c"},
+			wantRunning: 1,
+		},
+		"independent tasks run concurrently": {
+			tasks: Tasks{"a": link, "b": link, "c": link},
+			jobs:  3,
+			// No task finishes until all have started.
+			barrier:     3,
+			wantRan:     []string{"a", "b", "c"},
+			wantRunning: 3,
+		},
+		"dependent tasks wait": {
+			tasks:       Tasks{"a": mkdir, "a/x": link, "a/y": link, "b": remove, "b/x": remove},
+			jobs:        8,
+			wantRan:     []string{"a", "a/x", "a/y", "b", "b/x"},
+			wantRunning: 3,
+		},
+		"failures stop scheduling and report in plan order": {
+			tasks: Tasks{"a": mkdir, "a/x": link, "b": link, "c": link},
+			jobs:  2,
+			fail:  map[string]error{"a": errA, "b": errB},
+			// B fails first, but a precedes b in the plan.
+			after:       map[string]string{"a": "b"},
+			barrier:     2,
+			wantDone:    []string{"b", "a"},
+			wantRunning: 2,
+			wantErrs:    []error{errA, errB},
+		},
+		"cancellation stops scheduling and reports finished tasks": {
+			tasks:       Tasks{"a": mkdir, "a/x": link, "b": link, "c": link},
+			jobs:        1,
+			cancelAt:    "a/x",
+			wantDone:    []string{"a", "a/x"},
+			wantRunning: 1,
+			wantErrs: []error{&CanceledError{
+				Finished: map[string][]string{"target": {"a", "a/x"}},
+				Err:      context.Canceled,
+			}},
+		},
+		"cancellation waits for running tasks": {
+			tasks:    Tasks{"a": link, "b": link, "c": link, "d": link},
+			jobs:     2,
+			cancelAt: "a",
+			fail:     map[string]error{"b": errB},
+			// B is running when a cancels, and finishes after a.
+			after:       map[string]string{"b": "a"},
+			barrier:     2,
+			wantDone:    []string{"a", "b"},
+			wantRunning: 2,
+			wantErrs: []error{errB, &CanceledError{
+				Finished: map[string][]string{"target": {"a"}},
+				Err:      context.Canceled,
+			}},
+		},
+		"canceled before any task starts": {
+			tasks:    Tasks{"a": link, "b": link},
+			jobs:     2,
+			cancelAt: "-",
+			wantDone: []string{},
+			wantErrs: []error{&CanceledError{
+				Finished: map[string][]string{},
+				Err:      context.Canceled,
+			}},
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancelAt == "-" {
+				cancel()
+			}
+			fsys := &jobsFS{fail: test.fail, after: test.after, barrier: test.barrier,
+				cancelAt: test.cancelAt, cancel: cancel}
+			subs := map[string]file.ActionFS{"target": fsys}
+			jobs, err := newJobs(Plan{Targets: map[string]Tasks{"target": test.tasks}}, subs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = runJobs(ctx, jobs, test.jobs)
+
+			if diff := cmp.Diff(errors.Join(test.wantErrs...), err, cmp.Comparer(sameErrors)); diff != "" {
+				t.Errorf("error:\n got: %v\nwant: %v", err, test.wantErrs)
+			}
+			if test.wantDone != nil {
+				if diff := cmp.Diff(test.wantDone, fsys.done, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("completion order:\n%s", diff)
+				}
+			}
+			if test.wantRan != nil {
+				if diff := cmp.Diff(test.wantRan, slices.Sorted(slices.Values(fsys.done))); diff != "" {
+					t.Errorf("tasks run:\n%s", diff)
+				}
+			}
+			for _, j := range jobs {
+				for _, next := range j.next {
+					if i, n := slices.Index(fsys.done, j.item), slices.Index(fsys.done, jobs[next].item); n >= 0 && n < i {
+						t.Errorf("%s finished before %s", jobs[next].item, j.item)
+					}
+				}
+			}
+			if fsys.maxRunning > test.wantRunning {
+				t.Errorf("concurrent tasks: got %d, want at most %d", fsys.maxRunning, test.wantRunning)
+			}
+			if (test.after != nil || test.barrier > 0) && fsys.maxRunning != test.wantRunning {
+				t.Errorf("concurrent tasks: got %d, want %d", fsys.maxRunning, test.wantRunning)
+			}
+		})
+	}
+}
+
+// sameErrors reports whether a and b are both nil,
+// or both join the same errors in the same order.
+// Two [*CanceledError] values are the same if they have the same fields.
+func sameErrors(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	ua, aok := a.(interface{ Unwrap() []error })
+	ub, bok := b.(interface{ Unwrap() []error })
+	if !aok || !bok {
+		return errors.Is(a, b)
+	}
+	return slices.EqualFunc(ua.Unwrap(), ub.Unwrap(), func(x, y error) bool {
+		cx, xok := x.(*CanceledError)
+		cy, yok := y.(*CanceledError)
+		if xok && yok {
+			return cx.Err == cy.Err && cmp.Equal(cx.Finished, cy.Finished, cmpopts.EquateEmpty())
+		}
+		return x == y
+	})
+}
+
+// jobsFS is a [file.ActionFS] that records the actions executed on it.
+type jobsFS struct {
+	fail       map[string]error  // The error to return for each item.
+	after      map[string]string // The item whose action must finish before each item's action finishes.
+	barrier    int               // The number of actions that must start before any action finishes.
+	cancelAt   string            // The item whose action calls cancel.
+	cancel     func()
+	mu         sync.Mutex
+	started    int      // The number of actions that have started.
+	done       []string // The items whose actions have finished, in order.
+	running    int      // The number of actions running.
+	maxRunning int      // The maximum number of concurrent actions.
+}
+
+func (f *jobsFS) act(name string) error {
+	f.mu.Lock()
+	f.running++
+	f.started++
+	f.maxRunning = max(f.maxRunning, f.running)
+	f.mu.Unlock()
+
+	if name == f.cancelAt {
+		f.cancel()
+	}
+	before, ok := f.after[name]
+	f.waitFor(func() bool {
+		return (!ok || slices.Contains(f.done, before)) && f.started >= f.barrier
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running--
+	f.done = append(f.done, name)
+	return f.fail[name]
+}
+
+// waitFor waits until cond returns true, or a few seconds pass.
+func (f *jobsFS) waitFor(cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		ok := cond()
+		f.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (f *jobsFS) Mkdir(name string, _ fs.FileMode) error { return f.act(name) }
+
+func (f *jobsFS) Remove(name string) error { return f.act(name) }
+
+func (f *jobsFS) Symlink(_, name string) error { return f.act(name) }
+
+func (f *jobsFS) WriteFile(name string, _ []byte, _ fs.FileMode) error { return f.act(name) }
@@ -0,0 +1,152 @@
+package plan
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+
+	"github.com/dhemery/duffel/internal/config"
+)
+
+// A priority ranks a package against other packages
+// that provide items for the same target file.
+type priority struct {
+	level int // The priority from the package's configuration.
+	order int // The package's position among the listed packages, or -1 if it is not listed.
+}
+
+// outranks reports whether p outranks o.
+// A package with a higher level outranks one with a lower level.
+// Among listed packages with the same level,
+// a package listed later outranks one listed earlier.
+// A package does not outrank a package with the same level
+// unless both are listed.
+func (p priority) outranks(o priority) bool {
+	if p.level != o.level {
+		return p.level > o.level
+	}
+	return o.order >= 0 && p.order > o.order
+}
+
+// newLayers returns a [layers] that ranks the packages in goals
+// by their configured priorities
+// and the order in which the user listed them.
+// See [DirGoal.WithListed].
+func newLayers(fsys fs.ReadLinkFS, goals []DirGoal) *layers {
+	order := map[string]int{}
+	for _, g := range goals {
+		if !g.listed {
+			continue
+		}
+		pkg := g.dir.packageDir()
+		if o, ok := order[pkg]; !ok || g.order < o {
+			order[pkg] = g.order
+		}
+	}
+	return &layers{
+		fsys:     fsys,
+		itemizer: itemizer{fsys},
+		order:    order,
+		levels:   map[string]int{},
+		shadows:  map[string]Shadow{},
+	}
+}
+
+// layers ranks the packages that provide items for the same target file,
+// and records which packages' items shadow which.
+type layers struct {
+	fsys     fs.FS
+	itemizer itemizer
+	order    map[string]int    // The position of each listed package, by package dir.
+	levels   map[string]int    // The configured priority of each known package, by package dir.
+	shadows  map[string]Shadow // The shadows for each target item.
+}
+
+// rank compares the priority of the source item's package
+// with the priority of the package that provides the target link's final destination.
+// It returns the path to the destination item and an int that is
+// positive if the source item's package outranks the other package,
+// negative if the other package outranks the source item's package,
+// and zero if neither outranks the other
+// or the target does not link to an item in another package.
+func (ls *layers) rank(s sourceItem, t targetItem) (sourcePath, int, error) {
+	owner, ok, err := ls.ownerOf(t)
+	switch {
+	case err != nil:
+		return sourcePath{}, 0, err
+	case !ok:
+		return sourcePath{}, 0, nil
+	case owner.packageDir() == s.Path.packageDir():
+		return owner, 0, nil
+	}
+
+	sourcePriority, err := ls.priority(s.Path)
+	if err != nil {
+		return sourcePath{}, 0, err
+	}
+	ownerPriority, err := ls.priority(owner)
+	if err != nil {
+		return sourcePath{}, 0, err
+	}
+
+	switch {
+	case sourcePriority.outranks(ownerPriority):
+		return owner, 1, nil
+	case ownerPriority.outranks(sourcePriority):
+		return owner, -1, nil
+	}
+	return owner, 0, nil
+}
+
+// ownerOf returns the package item that the target item links to,
+// perhaps through a chain of links.
+// If the target item does not link to an item in a package,
+// the bool result is false.
+func (ls *layers) ownerOf(t targetItem) (sourcePath, bool, error) {
+	if !t.State.IsLink() {
+		return sourcePath{}, false, nil
+	}
+	owner, err := ls.itemizer.itemize(t.dest())
+	switch {
+	case errors.Is(err, errNotInPackage), errors.Is(err, errIsSource),
+		errors.Is(err, errIsGroup), errors.Is(err, errIsPackage):
+		return sourcePath{}, false, nil
+	case err != nil:
+		return sourcePath{}, false, err
+	}
+	return owner, true, nil
+}
+
+// priority returns the priority of the package that contains p.
+func (ls *layers) priority(p sourcePath) (priority, error) {
+	pkg := p.packageDir()
+	level, ok := ls.levels[pkg]
+	if !ok {
+		c, err := config.ReadPackage(ls.fsys, pkg)
+		if err != nil {
+			return priority{}, err
+		}
+		level = c.Priority
+		ls.levels[pkg] = level
+	}
+
+	order, ok := ls.order[pkg]
+	if !ok {
+		order = -1
+	}
+	return priority{level, order}, nil
+}
+
+// shadow records that the winner's item shadows the shadowed item
+// at the target path.
+func (ls *layers) shadow(t targetPath, winner, shadowed sourcePath) {
+	s := ls.shadows[t.String()]
+	pkgs := []string{s.Package, shadowed.packageDir()}
+	s.Package = winner.packageDir()
+	for _, pkg := range pkgs {
+		if pkg != "" && pkg != s.Package && !slices.Contains(s.Shadowed, pkg) {
+			s.Shadowed = append(s.Shadowed, pkg)
+		}
+	}
+	ls.shadows[t.String()] = s
+}
@@ -0,0 +1,183 @@
+package plan
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dhemery/duffel/internal/config"
+	"github.com/dhemery/duffel/internal/errfs"
+	"github.com/dhemery/duffel/internal/file"
+)
+
+func TestPriorityOutranks(t *testing.T) {
+	tests := []struct {
+		desc string
+		p, o priority
+		want bool
+	}{
+		{"higher level", priority{1, 0}, priority{0, 1}, true},
+		{"lower level", priority{0, 1}, priority{1, 0}, false},
+		{"same level, listed later", priority{0, 1}, priority{0, 0}, true},
+		{"same level, listed earlier", priority{0, 0}, priority{0, 1}, false},
+		{"same level, other is not listed", priority{0, 1}, priority{0, -1}, false},
+		{"same level, p is not listed", priority{0, -1}, priority{0, 1}, false},
+		{"same level, neither is listed", priority{0, -1}, priority{0, -1}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := test.p.outranks(test.o); got != test.want {
+				t.Errorf("%v.outranks(%v): got %t, want %t", test.p, test.o, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLayersRank(t *testing.T) {
+	const (
+		source = "home/user/source"
+		target = "home/user"
+	)
+	tests := []struct {
+		desc      string
+		levels    map[string]int // Configured priority of each package.
+		goals     []string       // Packages in goal order.
+		listed    bool           // Whether the user listed the goals' packages, in goal order.
+		sourcePkg string         // The package of the source item.
+		dest      string         // The target link's dest.
+		wantOwner sourcePath
+		wantRank  int
+	}{
+		{
+			desc:      "later listed package outranks earlier",
+			goals:     []string{"base", "override"},
+			listed:    true,
+			sourcePkg: "override",
+			dest:      "source/base/.gitconfig",
+			wantOwner: newSourcePath(source, "base", ".gitconfig"),
+			wantRank:  1,
+		},
+		{
+			desc:      "earlier listed package is outranked by later",
+			goals:     []string{"base", "override"},
+			listed:    true,
+			sourcePkg: "base",
+			dest:      "source/override/.gitconfig",
+			wantOwner: newSourcePath(source, "override", ".gitconfig"),
+			wantRank:  -1,
+		},
+		{
+			desc:      "configured priority outranks listed order",
+			levels:    map[string]int{"base": 5},
+			goals:     []string{"base", "override"},
+			listed:    true,
+			sourcePkg: "override",
+			dest:      "source/base/.gitconfig",
+			wantOwner: newSourcePath(source, "base", ".gitconfig"),
+			wantRank:  -1,
+		},
+		{
+			desc:      "goal outranks lower priority non-goal",
+			levels:    map[string]int{"override": 1},
+			goals:     []string{"override"},
+			sourcePkg: "override",
+			dest:      "source/base/.gitconfig",
+			wantOwner: newSourcePath(source, "base", ".gitconfig"),
+			wantRank:  1,
+		},
+		{
+			desc:      "unlisted goals with equal priority do not outrank each other",
+			goals:     []string{"base", "override"},
+			sourcePkg: "override",
+			dest:      "source/base/.gitconfig",
+			wantOwner: newSourcePath(source, "base", ".gitconfig"),
+			wantRank:  0,
+		},
+		{
+			desc:      "configured priority ranks unlisted goals",
+			levels:    map[string]int{"override": 1},
+			goals:     []string{"base", "override"},
+			sourcePkg: "base",
+			dest:      "source/override/.gitconfig",
+			wantOwner: newSourcePath(source, "override", ".gitconfig"),
+			wantRank:  -1,
+		},
+		{
+			desc:      "goal does not outrank equal priority non-goal",
+			goals:     []string{"override"},
+			listed:    true,
+			sourcePkg: "override",
+			dest:      "source/base/.gitconfig",
+			wantOwner: newSourcePath(source, "base", ".gitconfig"),
+			wantRank:  0,
+		},
+		{
+			desc:      "dest is not in a package",
+			goals:     []string{"override"},
+			sourcePkg: "override",
+			dest:      "not-a-source/.gitconfig",
+			wantRank:  0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			testFS := errfs.New()
+			errfs.AddFile(testFS, path.Join(source, file.SourceMarkerFile), 0o644)
+			errfs.AddFile(testFS, path.Join(target, "not-a-source/.gitconfig"), 0o644)
+			for _, pkg := range []string{"base", "override"} {
+				errfs.AddFile(testFS, path.Join(source, pkg, ".gitconfig"), 0o644)
+			}
+			for pkg, level := range test.levels {
+				data := fmt.Appendf(nil, `{"priority": %d}`, level)
+				errfs.Add(testFS, errfs.NewFileData(path.Join(source, pkg, config.PackageFile), 0o644, data))
+			}
+			var goals []DirGoal
+			for i, pkg := range test.goals {
+				goal := InstallPackage(source, pkg)
+				if test.listed {
+					goal = goal.WithListed(i)
+				}
+				goals = append(goals, goal)
+			}
+
+			layers := newLayers(testFS, goals)
+			s := newSourceItem(source, test.sourcePkg, ".gitconfig", file.TypeFile)
+			tgt := newTargetItem(target, ".gitconfig", file.LinkState(test.dest, file.TypeFile))
+
+			gotOwner, gotRank, err := layers.rank(s, tgt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantOwner, gotOwner, cmp.AllowUnexported(sourcePath{})); diff != "" {
+				t.Errorf("owner:\n%s", diff)
+			}
+			if gotRank != test.wantRank {
+				t.Errorf("rank: got %d, want %d", gotRank, test.wantRank)
+			}
+		})
+	}
+}
+
+func TestLayersShadow(t *testing.T) {
+	layers := newLayers(errfs.New(), nil)
+	tgt := newTargetPath("target", ".gitconfig")
+	base := newSourcePath("source", "base", ".gitconfig")
+	host := newSourcePath("source", "host", ".gitconfig")
+	work := newSourcePath("source", "work", ".gitconfig")
+
+	layers.shadow(tgt, host, base)
+	layers.shadow(tgt, work, host)
+	layers.shadow(tgt, work, base)
+
+	want := map[string]Shadow{
+		"target/.gitconfig": {
+			Package:  "source/work",
+			Shadowed: []string{"source/base", "source/host"},
+		},
+	}
+	if diff := cmp.Diff(want, layers.shadows); diff != "" {
+		t.Errorf("shadows:\n%s", diff)
+	}
+}
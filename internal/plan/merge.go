@@ -1,10 +1,16 @@
 package plan
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+
+	"github.com/dhemery/duffel/internal/file"
 )
 
+var errNotInstalledAt = errors.New("package does not install it at the target item")
+
 func newMerger(itemizer itemizer, analyst *analyzer) *merger {
 	return &merger{
 		itemizer: itemizer,
@@ -17,26 +23,50 @@ type merger struct {
 	analyst  *analyzer
 }
 
-func (m merger) merge(name string, logger *slog.Logger) error {
+// merge analyzes the items in the named dir,
+// which is linked from the target item t,
+// so that the target can be replaced by a dir.
+// If the named dir's package remaps the dir's path,
+// the dir must be the item that the package installs at t.
+// Otherwise the link may have been made by hand or by another package,
+// and the dir's items are merged into t wherever t is.
+func (m merger) merge(ctx context.Context, name string, t targetPath, logger *slog.Logger) error {
 	mergeItem, err := m.itemizer.itemize(name)
 	if err != nil {
-		return &mergeError{Dir: name, Err: err}
+		return &MergeError{Dir: name, Err: err}
 	}
 
-	mergeOp := mergeDir(mergeItem)
-	return m.analyst.analyze(mergeOp, logger)
+	paths, err := m.analyst.paths(mergeItem, t.target)
+	if err != nil {
+		return &MergeError{Dir: name, Err: err}
+	}
+	item := stripConditions(mergeItem.item)
+	if installedAt := paths.apply(item); installedAt != item && installedAt != t.item {
+		// The package remaps the item to some other target item,
+		// so the dir's items do not belong inside this one.
+		return &MergeError{Dir: name, Err: errNotInstalledAt}
+	}
+
+	// Plan the dir that replaces the link before analyzing the merged items,
+	// so that the index reads no current state through the replaced link.
+	m.analyst.index.setState(t, file.DirState(), logger)
+
+	mergeOp := mergeDir(mergeItem, t)
+	return m.analyst.analyze(ctx, mergeOp, logger)
 }
 
-// A mergeError is an error that prevents merging the previously items from a directory.
-type mergeError struct {
+// A MergeError indicates that the target item links to a directory
+// whose previously installed items cannot be merged
+// into the directory being installed.
+type MergeError struct {
 	Dir string `json:"dir"` // The name of the directory being merged.
 	Err error  `json:"err"` // The error that prevents merging.
 }
 
-func (me *mergeError) Error() string {
+func (me *MergeError) Error() string {
 	return fmt.Sprintf("cannot merge %q: %s", me.Dir, me.Err)
 }
 
-func (me *mergeError) Unwrap() error {
+func (me *MergeError) Unwrap() error {
 	return me.Err
 }
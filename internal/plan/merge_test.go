@@ -2,11 +2,14 @@ package plan
 
 import (
 	"bytes"
+	"context"
 	"path"
 	"testing"
 
+	"github.com/dhemery/duffel/internal/config"
 	"github.com/dhemery/duffel/internal/duftest"
 	"github.com/dhemery/duffel/internal/errfs"
+	"github.com/dhemery/duffel/internal/facts"
 	"github.com/dhemery/duffel/internal/file"
 	"github.com/dhemery/duffel/internal/log"
 
@@ -19,27 +22,28 @@ func TestMerge(t *testing.T) {
 		target     string                // The target to merge into.
 		files      []*errfs.File         // Files on the file system in addition to the merge dir.
 		nameArg    string                // The name of the directory to merge.
+		targetArg  string                // The target item that links to the directory.
 		wantErr    error                 // Error returned by Merge.
 		wantStates map[string]file.State // States added to index during Merge.
 	}{
 		"not in a package": {
 			files:   []*errfs.File{}, // No other files, so no source marker file
 			nameArg: "dir1/dir2/dir3/dir4/dir5/dir6",
-			wantErr: &mergeError{Dir: "dir1/dir2/dir3/dir4/dir5/dir6", Err: errNotInPackage},
+			wantErr: &MergeError{Dir: "dir1/dir2/dir3/dir4/dir5/dir6", Err: errNotInPackage},
 		},
 		"duffel source dir": {
 			files: []*errfs.File{
 				sourceDir("duffel/source-dir"),
 			},
 			nameArg: "duffel/source-dir",
-			wantErr: &mergeError{Dir: "duffel/source-dir", Err: errIsSource},
+			wantErr: &MergeError{Dir: "duffel/source-dir", Err: errIsSource},
 		},
 		"duffel package": {
 			files: []*errfs.File{
 				sourceDir("duffel/source-dir"),
 			},
 			nameArg: "duffel/source-dir/pkg-dir",
-			wantErr: &mergeError{Dir: "duffel/source-dir/pkg-dir", Err: errIsPackage},
+			wantErr: &MergeError{Dir: "duffel/source-dir/pkg-dir", Err: errIsPackage},
 		},
 		"top level item in a package": {
 			target: "target-dir",
@@ -47,8 +51,10 @@ func TestMerge(t *testing.T) {
 				sourceDir("duffel/source-dir"),
 				errfs.NewFile("duffel/source-dir/pkg-dir/item/content", 0o644),
 			},
-			nameArg: "duffel/source-dir/pkg-dir/item",
+			nameArg:   "duffel/source-dir/pkg-dir/item",
+			targetArg: "item",
 			wantStates: map[string]file.State{
+				"target-dir/item": file.DirState(), // Replaces the link.
 				"target-dir/item/content": file.LinkState(
 					"../../duffel/source-dir/pkg-dir/item/content",
 					file.TypeFile),
@@ -61,14 +67,33 @@ func TestMerge(t *testing.T) {
 				sourceDir("duffel/source-dir"),
 				errfs.NewFile("duffel/source-dir/pkg-dir/item1/item2/item3/content", 0o644),
 			},
-			nameArg: "duffel/source-dir/pkg-dir/item1/item2/item3",
+			nameArg:   "duffel/source-dir/pkg-dir/item1/item2/item3",
+			targetArg: "item1/item2/item3",
 			wantStates: map[string]file.State{
+				"target-dir/item1/item2/item3": file.DirState(), // Replaces the link.
 				"target-dir/item1/item2/item3/content": file.LinkState(
 					"../../../../duffel/source-dir/pkg-dir/item1/item2/item3/content",
 					file.TypeFile),
 			},
 			wantErr: nil,
 		},
+		"item in a package in a group": {
+			target: "target-dir",
+			files: []*errfs.File{
+				sourceDir("duffel/source-dir"),
+				errfs.NewFile(path.Join("duffel/source-dir/group", file.GroupMarkerFile), 0o644),
+				errfs.NewFile("duffel/source-dir/group/pkg-dir/item/content", 0o644),
+			},
+			nameArg:   "duffel/source-dir/group/pkg-dir/item",
+			targetArg: "item",
+			wantStates: map[string]file.State{
+				"target-dir/item": file.DirState(), // Replaces the link.
+				"target-dir/item/content": file.LinkState(
+					"../../duffel/source-dir/group/pkg-dir/item/content",
+					file.TypeFile),
+			},
+			wantErr: nil,
+		},
 		"various file types in a package": {
 			target: "target-dir",
 			files: []*errfs.File{
@@ -77,8 +102,10 @@ func TestMerge(t *testing.T) {
 				errfs.NewFile("duffel/source-dir/pkg-dir/item/file", 0o644),
 				errfs.NewLink("duffel/source-dir/pkg-dir/item/link", "some/dest"),
 			},
-			nameArg: "duffel/source-dir/pkg-dir/item",
+			nameArg:   "duffel/source-dir/pkg-dir/item",
+			targetArg: "item",
 			wantStates: map[string]file.State{
+				"target-dir/item": file.DirState(), // Replaces the link.
 				"target-dir/item/dir": file.LinkState(
 					"../../duffel/source-dir/pkg-dir/item/dir",
 					file.TypeDir),
@@ -91,6 +118,52 @@ func TestMerge(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		"item installed at a mapped path": {
+			target: "target-dir",
+			files: []*errfs.File{
+				sourceDir("duffel/source-dir"),
+				errfs.NewFileData(path.Join("duffel/source-dir/pkg-dir", config.PackageFile), 0o644,
+					[]byte(`{"paths": {"item": ".local/item"}}`)),
+				errfs.NewFile("duffel/source-dir/pkg-dir/item/content", 0o644),
+			},
+			nameArg:   "duffel/source-dir/pkg-dir/item",
+			targetArg: ".local/item",
+			wantStates: map[string]file.State{
+				"target-dir/.local/item": file.DirState(), // Replaces the link.
+				"target-dir/.local/item/content": file.LinkState(
+					"../../../duffel/source-dir/pkg-dir/item/content",
+					file.TypeFile),
+			},
+			wantErr: nil,
+		},
+		"item linked from a path that the package does not map": {
+			target: "target-dir",
+			files: []*errfs.File{
+				sourceDir("duffel/source-dir"),
+				errfs.NewFile("duffel/source-dir/pkg-dir/item/content", 0o644),
+			},
+			nameArg:   "duffel/source-dir/pkg-dir/item",
+			targetArg: "other",
+			wantStates: map[string]file.State{
+				"target-dir/other": file.DirState(), // Replaces the link.
+				"target-dir/other/content": file.LinkState(
+					"../../duffel/source-dir/pkg-dir/item/content",
+					file.TypeFile),
+			},
+			wantErr: nil,
+		},
+		"mapped item linked from a path the package does not install it at": {
+			target: "target-dir",
+			files: []*errfs.File{
+				sourceDir("duffel/source-dir"),
+				errfs.NewFileData(path.Join("duffel/source-dir/pkg-dir", config.PackageFile), 0o644,
+					[]byte(`{"paths": {"item": ".local/item"}}`)),
+				errfs.NewFile("duffel/source-dir/pkg-dir/item/content", 0o644),
+			},
+			nameArg:   "duffel/source-dir/pkg-dir/item",
+			targetArg: "item",
+			wantErr:   &MergeError{Dir: "duffel/source-dir/pkg-dir/item", Err: errNotInstalledAt},
+		},
 	}
 
 	for name, test := range tests {
@@ -105,13 +178,13 @@ func TestMerge(t *testing.T) {
 			}
 
 			stater := file.NewStater(testFS)
-			index := newIndex(stater)
-			analyzer := newAnalyzer(testFS, test.target, index)
+			index := newIndex(stater, 1)
+			analyzer := newAnalyzer(testFS, test.target, System{}, index, newLayers(testFS, nil), newVariants(testFS, facts.Facts{}), newRenderer(testFS, System{}))
 			itemizer := itemizer{testFS}
 
 			merger := newMerger(itemizer, analyzer)
 
-			err := merger.merge(test.nameArg, logger)
+			err := merger.merge(context.Background(), test.nameArg, newTargetPath(test.target, test.targetArg), logger)
 
 			if diff := cmp.Diff(test.wantErr, err); diff != "" {
 				t.Errorf("Merge(%q, %q) error:\n%s",
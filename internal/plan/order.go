@@ -0,0 +1,153 @@
+package plan
+
+import (
+	"encoding/json/jsontext"
+	"encoding/json/v2"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/dhemery/duffel/internal/file"
+)
+
+// order returns the items in ts in an order
+// that satisfies the dependencies between their tasks.
+// See [Tasks.dependencies].
+// Items whose tasks do not depend on each other are ordered lexically.
+// If the dependencies cannot be satisfied,
+// order returns an [OrderError].
+func (ts Tasks) order() ([]string, error) {
+	deps, err := ts.dependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	var ordered []string
+	marks := map[string]int{}
+	var visit func(item string, chain []string) error
+	visit = func(item string, chain []string) error {
+		switch marks[item] {
+		case visited:
+			return nil
+		case visiting:
+			return &OrderError{Items: append(slices.Clip(chain), item), Err: errDependencyCycle}
+		}
+		marks[item] = visiting
+		for _, dep := range deps[item] {
+			if err := visit(dep, append(slices.Clip(chain), item)); err != nil {
+				return err
+			}
+		}
+		marks[item] = visited
+		ordered = append(ordered, item)
+		return nil
+	}
+	for _, item := range slices.Sorted(maps.Keys(ts)) {
+		if err := visit(item, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// dependencies returns a graph that maps each item in ts
+// to the items whose tasks must be executed before the item's task.
+// The dependencies of each item are in lexical order.
+//
+// Each item's task depends on the task for its nearest ancestor that has a task:
+// if the ancestor's task creates a directory,
+// the item's task must follow it.
+// If the ancestor's task removes the ancestor without replacing it,
+// the item's task must remove the item, and must precede the ancestor's task.
+// Creating an item inside an ancestor that the plan removes
+// is an [OrderError].
+func (ts Tasks) dependencies() (map[string][]string, error) {
+	deps := map[string][]string{}
+	for _, item := range slices.Sorted(maps.Keys(ts)) {
+		ancestor, ok := ts.ancestor(item)
+		if !ok {
+			continue
+		}
+		task, ancestorTask := ts[item], ts[ancestor]
+		switch {
+		case ancestorTask.createsDir():
+			deps[item] = append(deps[item], ancestor)
+		case ancestorTask.removesOnly() && task.removesOnly():
+			deps[ancestor] = append(deps[ancestor], item)
+		default:
+			return nil, &OrderError{Items: []string{ancestor, item}, Err: errCreateInRemoved}
+		}
+	}
+	return deps, nil
+}
+
+// ancestor returns the nearest ancestor of item that has a task in ts.
+// If no ancestor has a task, the bool result is false.
+func (ts Tasks) ancestor(item string) (string, bool) {
+	for dir := path.Dir(item); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := ts[dir]; ok {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// MarshalJSONTo writes ts to e as a JSON object
+// whose members are in the order in which ts's tasks will be executed.
+func (ts Tasks) MarshalJSONTo(e *jsontext.Encoder) error {
+	items, err := ts.order()
+	if err != nil {
+		return err
+	}
+	if err := e.WriteToken(jsontext.BeginObject); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := e.WriteToken(jsontext.String(item)); err != nil {
+			return err
+		}
+		if err := json.MarshalEncode(e, ts[item]); err != nil {
+			return err
+		}
+	}
+	return e.WriteToken(jsontext.EndObject)
+}
+
+// createsDir reports whether t leaves its item as a directory.
+func (t Task) createsDir() bool {
+	return len(t) > 0 && t[len(t)-1] == file.MkdirAction()
+}
+
+// removesOnly reports whether t removes its item without replacing it.
+func (t Task) removesOnly() bool {
+	return len(t) > 0 && !slices.ContainsFunc(t, func(a file.Action) bool {
+		return a != file.RemoveAction()
+	})
+}
+
+var (
+	errDependencyCycle = errors.New("tasks form a dependency cycle")
+	errCreateInRemoved = errors.New("task creates an item in a directory that the plan removes")
+)
+
+// An OrderError indicates that the tasks in a plan
+// cannot be ordered to satisfy their dependencies.
+type OrderError struct {
+	Items []string // The items whose tasks cannot be ordered.
+	Err   error    // The reason the tasks cannot be ordered.
+}
+
+func (oe *OrderError) Error() string {
+	return fmt.Sprintf("plan error: %s: %s", oe.Err, strings.Join(oe.Items, " -> "))
+}
+
+func (oe *OrderError) Unwrap() error {
+	return oe.Err
+}
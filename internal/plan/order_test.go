@@ -0,0 +1,94 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json/v2"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dhemery/duffel/internal/file"
+)
+
+var (
+	mkdir   = Task{file.MkdirAction()}
+	remove  = Task{file.RemoveAction()}
+	replace = Task{file.RemoveAction(), file.MkdirAction()}
+	link    = Task{file.SymlinkAction("some/dest")}
+)
+
+func TestTasksOrder(t *testing.T) {
+	tests := map[string]struct {
+		tasks     Tasks
+		wantOrder []string
+		wantErr   error
+	}{
+		"independent items in lexical order": {
+			tasks:     Tasks{"c": link, "a": mkdir, "b": remove},
+			wantOrder: []string{"a", "b", "c"},
+		},
+		"created dir before its contents": {
+			tasks:     Tasks{"dir": mkdir, "dir/sub": mkdir, "dir/sub/link": link, "dir-link": link},
+			wantOrder: []string{"dir", "dir-link", "dir/sub", "dir/sub/link"},
+		},
+		"link replaced by dir before its contents": {
+			tasks:     Tasks{"dir": replace, "dir/link": link},
+			wantOrder: []string{"dir", "dir/link"},
+		},
+		"nearest ancestor with a task": {
+			tasks:     Tasks{"dir": mkdir, "dir/sub/sub/link": link},
+			wantOrder: []string{"dir", "dir/sub/sub/link"},
+		},
+		"removed contents before their dir": {
+			tasks:     Tasks{"dir": remove, "dir/sub": remove, "dir/sub/link": remove, "dir/link": remove},
+			wantOrder: []string{"dir/link", "dir/sub/link", "dir/sub", "dir"},
+		},
+		"item created in removed dir": {
+			tasks:   Tasks{"dir": remove, "dir/link": link},
+			wantErr: errCreateInRemoved,
+		},
+		"dir created in removed dir": {
+			tasks:   Tasks{"dir": remove, "dir/sub": replace},
+			wantErr: errCreateInRemoved,
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			got, err := test.tasks.order()
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error:\n got: %v\nwant: %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.wantOrder, got); diff != "" {
+				t.Errorf("order:\n%s", diff)
+			}
+			if err != nil {
+				var oe *OrderError
+				if !errors.As(err, &oe) {
+					t.Errorf("error: got %T, want %T", err, oe)
+				}
+			}
+		})
+	}
+}
+
+func TestTasksMarshalInOrder(t *testing.T) {
+	tasks := Tasks{"dir": remove, "dir/link": remove, "other": mkdir}
+
+	var got bytes.Buffer
+	if err := json.MarshalWrite(&got, tasks, json.Deterministic(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"dir/link":[{"action":"remove"}],"dir":[{"action":"remove"}],"other":[{"action":"mkdir"}]}`
+	if got.String() != want {
+		t.Errorf("JSON:\n got: %s\nwant: %s", got.String(), want)
+	}
+
+	bad := Tasks{"dir": remove, "dir/link": link}
+	err := json.MarshalWrite(&got, bad)
+	if !errors.Is(err, errCreateInRemoved) {
+		t.Errorf("marshal unorderable tasks error:\n got: %v\nwant: %v", err, errCreateInRemoved)
+	}
+}
@@ -0,0 +1,139 @@
+package plan
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/dhemery/duffel/internal/config"
+	"github.com/dhemery/duffel/internal/file"
+)
+
+// Validate checks that the target of each of p's goals
+// does not overlap any source in p's goals in a way that would make p
+// link items onto themselves or install items into a source.
+// A goal's target must not be a source or inside a source,
+// and the goal must not install any item at a source or inside one.
+// Validate compares physical paths, so it detects overlaps
+// created by symlinks. See [file.Physical].
+// [Planner.Plan] and [Planner.PlanContext] call Validate
+// unless it has already succeeded.
+func (p *Planner) Validate() error {
+	if p.validated {
+		return nil
+	}
+	physical := map[string]string{}
+	physicalPath := func(name string) (string, error) {
+		if phys, ok := physical[name]; ok {
+			return phys, nil
+		}
+		phys, err := file.Physical(p.fsys, name)
+		if err != nil {
+			return "", err
+		}
+		physical[name] = phys
+		return phys, nil
+	}
+
+	var sources []string
+	for _, goal := range p.goals {
+		if !slices.Contains(sources, goal.dir.source) {
+			sources = append(sources, goal.dir.source)
+		}
+	}
+	physSources := map[string]string{}
+	for _, source := range sources {
+		phys, err := physicalPath(source)
+		if err != nil {
+			return fmt.Errorf("source %s: %w", source, err)
+		}
+		physSources[source] = phys
+	}
+
+	for _, goal := range p.goals {
+		target := cmp.Or(goal.target, p.analyzer.target)
+		physTarget, err := physicalPath(target)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
+		}
+		if err := p.validateGoal(goal, target, physTarget, sources, physSources); err != nil {
+			return err
+		}
+	}
+	p.validated = true
+	return nil
+}
+
+// validateGoal checks that goal's target, at physical path physTarget,
+// does not overlap any of the sources.
+// PhysSources maps each source to its physical path.
+func (p *Planner) validateGoal(goal DirGoal, target, physTarget string, sources []string, physSources map[string]string) error {
+	// The items at which each source in the target would be installed.
+	sourceItems := map[string]string{}
+	for _, source := range sources {
+		physSource := physSources[source]
+		if within(physTarget, physSource) {
+			return fmt.Errorf("package %s: %w: target %s (%s) is in source %s (%s)",
+				goal.dir.packageDir(), fs.ErrInvalid, target, physTarget, source, physSource)
+		}
+		if !within(physSource, physTarget) {
+			continue
+		}
+		sourceItem := strings.TrimPrefix(strings.TrimPrefix(physSource, physTarget), "/")
+		if physTarget == "." {
+			sourceItem = physSource
+		}
+		sourceItems[source] = sourceItem
+	}
+	if len(sourceItems) == 0 {
+		return nil
+	}
+
+	// Some sources are in the target.
+	// No item may be installed at a source or inside it.
+	paths, err := p.analyzer.paths(goal.dir, target)
+	if err != nil {
+		return err
+	}
+	pkgDir := goal.dir.packageDir()
+	var selector selector = selectors{}
+	if goal.dir.item != "" {
+		// The item may name its variants without their conditions,
+		// so walk the whole package, as the analyzer does.
+		selector = itemSelector(p.fsys, goal.dir)
+	}
+	root := goal.dir.withItem("")
+	return fs.WalkDir(p.fsys, root.String(), func(n string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		item := root.withItemFrom(n).item
+		if n == pkgDir || item == config.PackageFile {
+			return nil
+		}
+		name := targetName(item, entry.Type().IsRegular())
+		if selector.selection(name) == selectNone {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		targetItem := paths.apply(name)
+		for _, source := range sources {
+			sourceItem, ok := sourceItems[source]
+			if ok && within(targetItem, sourceItem) {
+				return fmt.Errorf("package %s: %w: item %s would be installed at %s, in source %s (%s)",
+					pkgDir, fs.ErrInvalid, item, newTargetPath(target, targetItem), source, physSources[source])
+			}
+		}
+		return nil
+	})
+}
+
+// within reports whether name is dir or is inside dir.
+// Dir "." is the root, which contains every name.
+func within(name, dir string) bool {
+	return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+}
@@ -0,0 +1,221 @@
+package plan
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"testing"
+
+	"github.com/dhemery/duffel/internal/config"
+	"github.com/dhemery/duffel/internal/duftest"
+	"github.com/dhemery/duffel/internal/errfs"
+	"github.com/dhemery/duffel/internal/log"
+)
+
+func TestPlannerValidate(t *testing.T) {
+	tests := []struct {
+		desc    string        // Description of the test.
+		files   []*errfs.File // Files on the file system.
+		target  string        // The planner's target.
+		goal    DirGoal       // The goal to validate.
+		others  []DirGoal     // Other goals in the run.
+		wantErr string        // Error text from Validate, or empty if no error.
+	}{
+		{
+			desc:    "separate source and target",
+			files:   []*errfs.File{errfs.NewFile("source/pkg/item", 0o644), errfs.NewDir("target", 0o755)},
+			target:  "target",
+			goal:    InstallPackage("source", "pkg"),
+			wantErr: "",
+		},
+		{
+			desc:    "target is source",
+			files:   []*errfs.File{errfs.NewFile("source/pkg/item", 0o644)},
+			target:  "source",
+			goal:    InstallPackage("source", "pkg"),
+			wantErr: "package source/pkg: invalid argument: target source (source) is in source source (source)",
+		},
+		{
+			desc:    "target in package",
+			files:   []*errfs.File{errfs.NewDir("source/pkg/dir", 0o755)},
+			target:  "source/pkg/dir",
+			goal:    InstallPackage("source", "pkg"),
+			wantErr: "package source/pkg: invalid argument: target source/pkg/dir (source/pkg/dir) is in source source (source)",
+		},
+		{
+			desc: "target links to source",
+			files: []*errfs.File{
+				errfs.NewFile("source/pkg/item", 0o644),
+				errfs.NewLink("target", "source"),
+			},
+			target:  "target",
+			goal:    InstallPackage("source", "pkg"),
+			wantErr: "package source/pkg: invalid argument: target target (source) is in source source (source)",
+		},
+		{
+			desc: "target parent links into package",
+			files: []*errfs.File{
+				errfs.NewDir("source/pkg/dir/sub", 0o755),
+				errfs.NewLink("home", "source/pkg/dir"),
+			},
+			target:  "home/sub",
+			goal:    InstallPackage("source", "pkg"),
+			wantErr: "package source/pkg: invalid argument: target home/sub (source/pkg/dir/sub) is in source source (source)",
+		},
+		{
+			desc: "goal target in source",
+			files: []*errfs.File{
+				errfs.NewFile("source/pkg/item", 0o644),
+				errfs.NewDir("source/other", 0o755),
+				errfs.NewDir("target", 0o755),
+			},
+			target:  "target",
+			goal:    InstallPackage("source", "pkg").WithTarget("source/other"),
+			wantErr: "package source/pkg: invalid argument: target source/other (source/other) is in source source (source)",
+		},
+		{
+			desc:    "source in target",
+			files:   []*errfs.File{errfs.NewFile("home/source/pkg/item", 0o644)},
+			target:  "home",
+			goal:    InstallPackage("home/source", "pkg"),
+			wantErr: "",
+		},
+		{
+			desc:    "item is ancestor of source",
+			files:   []*errfs.File{errfs.NewFile("home/source/pkg/home/item", 0o644)},
+			target:  ".",
+			goal:    InstallPackage("home/source", "pkg"),
+			wantErr: "",
+		},
+		{
+			desc:    "item is source",
+			files:   []*errfs.File{errfs.NewFile("home/source/pkg/source", 0o644)},
+			target:  "home",
+			goal:    InstallPackage("home/source", "pkg"),
+			wantErr: "package home/source/pkg: invalid argument: item source would be installed at home/source, in source home/source (home/source)",
+		},
+		{
+			desc:    "item in source",
+			files:   []*errfs.File{errfs.NewFile("home/source/pkg/source/pkg/item", 0o644)},
+			target:  "home",
+			goal:    InstallPackage("home/source", "pkg"),
+			wantErr: "package home/source/pkg: invalid argument: item source would be installed at home/source, in source home/source (home/source)",
+		},
+		{
+			desc: "remapped item in source",
+			files: []*errfs.File{
+				errfs.NewFile("home/source/pkg/bin/tool", 0o644),
+				errfs.NewFileData(path.Join("home/source/pkg", config.PackageFile), 0o644,
+					[]byte(`{"paths": {"bin": "source/bin"}}`)),
+			},
+			target:  "home",
+			goal:    InstallPackage("home/source", "pkg"),
+			wantErr: "package home/source/pkg: invalid argument: item bin would be installed at home/source/bin, in source home/source (home/source)",
+		},
+		{
+			desc: "item in source via linked target",
+			files: []*errfs.File{
+				errfs.NewFile("data/source/pkg/source/item", 0o644),
+				errfs.NewLink("home", "data"),
+			},
+			target:  "home",
+			goal:    InstallPackage("data/source", "pkg"),
+			wantErr: "package data/source/pkg: invalid argument: item source would be installed at home/source, in source data/source (data/source)",
+		},
+		{
+			desc: "unselected item is not checked",
+			files: []*errfs.File{
+				errfs.NewFile("home/source/pkg/source", 0o644),
+				errfs.NewFile("home/source/pkg/item", 0o644),
+			},
+			target:  "home",
+			goal:    InstallItem("home/source", "pkg", "item"),
+			wantErr: "",
+		},
+		{
+			desc: "item installed in another goal's source",
+			files: []*errfs.File{
+				errfs.NewFile("s1/p/s2/evil", 0o644),
+				errfs.NewFile("s2/q/item", 0o644),
+			},
+			target:  ".",
+			goal:    InstallPackage("s1", "p"),
+			others:  []DirGoal{InstallPackage("s2", "q")},
+			wantErr: "package s1/p: invalid argument: item s2 would be installed at s2, in source s2 (s2)",
+		},
+		{
+			desc: "target in another goal's source",
+			files: []*errfs.File{
+				errfs.NewFile("s1/p/item", 0o644),
+				errfs.NewFile("s2/q/item", 0o644),
+			},
+			target:  "s2/q",
+			goal:    InstallPackage("s1", "p"),
+			others:  []DirGoal{InstallPackage("s2", "q").WithTarget("target")},
+			wantErr: "package s1/p: invalid argument: target s2/q (s2/q) is in source s2 (s2)",
+		},
+		{
+			desc: "items installed beside other goals' sources",
+			files: []*errfs.File{
+				errfs.NewFile("s1/p/item1", 0o644),
+				errfs.NewFile("s2/q/item2", 0o644),
+			},
+			target:  ".",
+			goal:    InstallPackage("s1", "p"),
+			others:  []DirGoal{InstallPackage("s2", "q")},
+			wantErr: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			testFS := errfs.New()
+			for _, f := range test.files {
+				errfs.Add(testFS, f)
+			}
+			var logbuf bytes.Buffer
+			logger := log.Logger(&logbuf, duftest.LogLevel)
+			defer duftest.Dump(t, "log", &logbuf)
+
+			goals := append([]DirGoal{test.goal}, test.others...)
+			planner := NewPlanner(testFS, test.target, goals, System{}, 1, logger)
+
+			err := planner.Validate()
+
+			checkValidateErr(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestPlanValidates(t *testing.T) {
+	testFS := errfs.New()
+	errfs.AddFile(testFS, "s1/p/s2/evil", 0o644)
+	errfs.AddFile(testFS, "s2/q/item", 0o644)
+	var logbuf bytes.Buffer
+	logger := log.Logger(&logbuf, duftest.LogLevel)
+	defer duftest.Dump(t, "log", &logbuf)
+	goals := []DirGoal{InstallPackage("s1", "p"), InstallPackage("s2", "q")}
+	planner := NewPlanner(testFS, ".", goals, System{}, 1, logger)
+
+	_, err := planner.Plan()
+
+	checkValidateErr(t, err, "package s1/p: invalid argument: item s2 would be installed at s2, in source s2 (s2)")
+}
+
+// checkValidateErr checks that err has the wanted text,
+// and that a non-nil err wraps [fs.ErrInvalid].
+func checkValidateErr(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Errorf("error result:\n got: %v\nwant: no error", err)
+		}
+		return
+	}
+	if err == nil || err.Error() != want {
+		t.Errorf("error result:\n got: %v\nwant: %s", err, want)
+	}
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("error %v does not wrap %v", err, fs.ErrInvalid)
+	}
+}
@@ -2,16 +2,22 @@ package plan
 
 import (
 	"encoding/json/jsontext"
+	"fmt"
+	"io/fs"
+	"maps"
 	"path"
 	"path/filepath"
+	"strings"
 
+	"github.com/dhemery/duffel/internal/config"
 	"github.com/dhemery/duffel/internal/file"
 )
 
 // newSourcePath returns a [sourcePath]
 // for the specified package or item.
 // Source is the full path from the root of the file system to the source directory.
-// Pkg is the name of the package directory.
+// Pkg is the path from the source directory to the package directory.
+// It has multiple components if the package is in a group.
 // Item is the path from the package directory to the item.
 // If item is empty, the SourcePath represents a package.
 func newSourcePath(source, pkg, item string) sourcePath {
@@ -21,7 +27,7 @@ func newSourcePath(source, pkg, item string) sourcePath {
 // A sourcePath is the path to a package or item in a duffel source tree.
 type sourcePath struct {
 	source string // The full path to the source directory.
-	pkg    string // The name of the package.
+	pkg    string // The path from the source directory to the package.
 	item   string // The path from the package directory to the item.
 }
 
@@ -110,3 +116,125 @@ type targetItem struct {
 	Path  targetPath `json:"path"`  // The path to the file.
 	State file.State `json:"state"` // The state of the file.
 }
+
+// dest returns the full path to the file at the end of
+// the chain of links that begins at t's link destination,
+// or to the destination itself if the chain was not resolved.
+func (t targetItem) dest() string {
+	if t.State.Dest.Resolved != "" {
+		return t.State.Dest.Resolved
+	}
+	return t.Path.resolve(t.State.Dest.Path)
+}
+
+// A pathMap maps item path prefixes in a package
+// to the path prefixes at which to install them in the target tree.
+type pathMap map[string]string
+
+// newPathMap returns a [pathMap] that installs items into the target tree
+// as mapped by paths, and by defaults for prefixes not in paths.
+// Variables in the mapped paths are expanded from env.
+// A mapped path that expands to an absolute path
+// must be inside the target tree.
+// A default mapping to a path outside the target tree is ignored.
+func newPathMap(paths, defaults map[string]string, target string, env map[string]string) (pathMap, error) {
+	m := pathMap{}
+	for from, to := range defaults {
+		if _, ok := paths[from]; ok {
+			continue
+		}
+		item, err := targetItemPath(to, target, env)
+		if err != nil {
+			continue
+		}
+		m[from] = item
+	}
+	for from, to := range paths {
+		item, err := targetItemPath(to, target, env)
+		if err != nil {
+			return nil, fmt.Errorf("paths %q: %w", from, err)
+		}
+		m[from] = item
+	}
+	return m, nil
+}
+
+// targetItemPath expands the variables in p
+// and returns the path from the target to the resulting item.
+func targetItemPath(p, target string, env map[string]string) (string, error) {
+	expanded, err := config.Expand(p, env)
+	if err != nil {
+		return "", err
+	}
+	item, inTarget := expanded, true
+	if path.IsAbs(expanded) {
+		// Make the path relative to the target.
+		item = path.Clean(expanded)[1:]
+		if target != "." {
+			item, inTarget = strings.CutPrefix(item, target+"/")
+		}
+	}
+	if !inTarget || item == "" || item == "." || !fs.ValidPath(item) {
+		return "", fmt.Errorf("%s: %w: not an item in target %s", p, fs.ErrInvalid, target)
+	}
+	return item, nil
+}
+
+// apply returns the path in the target tree at which to install item.
+// The item is mapped by its longest prefix in m.
+// If no prefix of item is in m, the item is installed at its own path.
+func (m pathMap) apply(item string) string {
+	return remap(item, m)
+}
+
+// invert returns the item that m installs at the target item,
+// the inverse of apply.
+func (m pathMap) invert(target string) string {
+	inverse := make(map[string]string, len(m))
+	for from, to := range m {
+		inverse[to] = from
+	}
+	return remap(target, inverse)
+}
+
+// with returns a copy of m that also installs item at the target item.
+func (m pathMap) with(item, target string) pathMap {
+	if item == target {
+		return m
+	}
+	c := maps.Clone(m)
+	if c == nil {
+		c = pathMap{}
+	}
+	c[item] = target
+	return c
+}
+
+// begins reports whether item is itself a prefix in m,
+// and so may be installed at a place unrelated to its parent's.
+func (m pathMap) begins(item string) bool {
+	_, ok := m[item]
+	return ok
+}
+
+// holds reports whether the target item is a proper ancestor
+// of the path to which m maps some prefix.
+func (m pathMap) holds(target string) bool {
+	for _, to := range m {
+		if strings.HasPrefix(to, target+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// remap replaces the longest prefix of p that is a key in m
+// with its value.
+func remap(p string, m map[string]string) string {
+	for prefix := p; prefix != "."; prefix = path.Dir(prefix) {
+		if to, ok := m[prefix]; ok {
+			return path.Join(to, strings.TrimPrefix(p, prefix))
+		}
+	}
+	return p
+}
@@ -1,8 +1,12 @@
 package plan
 
 import (
+	"errors"
+	"io/fs"
 	"path"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestSourcePath(t *testing.T) {
@@ -68,3 +72,110 @@ func TestTargetPath(t *testing.T) {
 		t.Errorf("Resolve()=%q, want %q", got, full)
 	}
 }
+
+func TestPathMap(t *testing.T) {
+	paths := pathMap{
+		"bin":         ".local/bin",
+		"config":      ".config",
+		"config/nvim": ".nvim",
+	}
+	tests := map[string]struct {
+		item       string
+		wantTarget string
+		wantBegins bool
+	}{
+		"unmapped item":        {item: "notes", wantTarget: "notes"},
+		"mapped item":          {item: "bin", wantTarget: ".local/bin", wantBegins: true},
+		"item in mapped dir":   {item: "bin/tool", wantTarget: ".local/bin/tool"},
+		"longest prefix wins":  {item: "config/nvim/init.lua", wantTarget: ".nvim/init.lua"},
+		"shorter prefix":       {item: "config/git/config", wantTarget: ".config/git/config"},
+		"name with map prefix": {item: "binary", wantTarget: "binary"},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			got := paths.apply(test.item)
+			if got != test.wantTarget {
+				t.Errorf("apply(%q)=%q, want %q", test.item, got, test.wantTarget)
+			}
+			if inverse := paths.invert(got); inverse != test.item {
+				t.Errorf("invert(%q)=%q, want %q", got, inverse, test.item)
+			}
+			if begins := paths.begins(test.item); begins != test.wantBegins {
+				t.Errorf("begins(%q)=%t, want %t", test.item, begins, test.wantBegins)
+			}
+		})
+	}
+
+	for target, want := range map[string]bool{".local": true, ".local/bin": false, ".config": false, "bin": false} {
+		if got := paths.holds(target); got != want {
+			t.Errorf("holds(%q)=%t, want %t", target, got, want)
+		}
+	}
+}
+
+func TestNewPathMap(t *testing.T) {
+	env := map[string]string{
+		"HOME":            "/home/user",
+		"XDG_CONFIG_HOME": "/home/user/.xdg",
+		"OUTSIDE":         "/etc/xdg",
+	}
+	tests := map[string]struct {
+		paths    map[string]string
+		defaults map[string]string
+		want     pathMap
+		wantErr  error
+	}{
+		"relative path": {
+			paths: map[string]string{"bin": ".local/bin"},
+			want:  pathMap{"bin": ".local/bin"},
+		},
+		"absolute path in target": {
+			paths: map[string]string{"bin": "/home/user/.local/bin"},
+			want:  pathMap{"bin": ".local/bin"},
+		},
+		"variable": {
+			paths: map[string]string{"config": "$XDG_CONFIG_HOME"},
+			want:  pathMap{"config": ".xdg"},
+		},
+		"tilde": {
+			paths: map[string]string{"bin": "~/.local/bin"},
+			want:  pathMap{"bin": ".local/bin"},
+		},
+		"undefined variable": {
+			paths:   map[string]string{"bin": "$NO_SUCH_VAR/bin"},
+			wantErr: fs.ErrInvalid,
+		},
+		"path outside target": {
+			paths:   map[string]string{"config": "$OUTSIDE"},
+			wantErr: fs.ErrInvalid,
+		},
+		"path is target": {
+			paths:   map[string]string{"config": "~"},
+			wantErr: fs.ErrInvalid,
+		},
+		"default": {
+			defaults: map[string]string{".config": "$XDG_CONFIG_HOME"},
+			want:     pathMap{".config": ".xdg"},
+		},
+		"package path overrides default": {
+			paths:    map[string]string{".config": ".cfg"},
+			defaults: map[string]string{".config": "$XDG_CONFIG_HOME"},
+			want:     pathMap{".config": ".cfg"},
+		},
+		"default outside target is ignored": {
+			defaults: map[string]string{".config": "$OUTSIDE"},
+			want:     pathMap{},
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			got, err := newPathMap(test.paths, test.defaults, "home/user", env)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error:\n got: %v\nwant: %v", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("path map:\n%s", diff)
+			}
+		})
+	}
+}
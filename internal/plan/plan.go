@@ -1,31 +1,89 @@
 package plan
 
 import (
+	"context"
 	"encoding/json/v2"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"iter"
 	"log/slog"
 	"maps"
-	"path"
 	"slices"
+	"strings"
 
+	"github.com/dhemery/duffel/internal/facts"
 	"github.com/dhemery/duffel/internal/file"
 )
 
+// A System describes the system on which to install packages.
+type System struct {
+	Facts facts.Facts       // Facts for choosing variants and rendering templates.
+	Env   map[string]string // Environment variables for rendering templates and expanding paths.
+
+	// Paths maps item path prefixes to the path prefixes
+	// at which to install them in the target tree,
+	// for every package whose configuration does not map the same prefix.
+	// Mappings to paths outside the target tree are ignored.
+	Paths map[string]string
+}
+
 // NewPlanner returns a new [Planner]
-// that plans how to achieive goals in the file tree rooted at target.
-func NewPlanner(fsys fs.ReadLinkFS, target string, goals []DirGoal, l *slog.Logger) *Planner {
+// that plans how to achieive goals in the file tree rooted at target,
+// or in the tree rooted at each goal's own target.
+// The system determines which variant of each conditional item to install,
+// and the values with which to render templates.
+// The planner reads up to jobs dirs or target file states concurrently.
+// The planner reads each directory, symlink, and file in fsys only once.
+// See [file.CacheFS].
+func NewPlanner(fsys fs.ReadLinkFS, target string, goals []DirGoal, sys System, jobs int, l *slog.Logger) *Planner {
+	return newPlanner(file.NewCacheFS(fsys), target, goals, sys, jobs, l)
+}
+
+// newPlanner is like [NewPlanner], but reads directly from fsys.
+func newPlanner(fsys fs.ReadLinkFS, target string, goals []DirGoal, sys System, jobs int, l *slog.Logger) *Planner {
 	stater := file.NewStater(fsys)
-	index := newIndex(stater)
-	analyst := newAnalyzer(fsys, target, index)
-	return &Planner{target, analyst, goals, l}
+	index := newIndex(stater, jobs)
+	layers := newLayers(fsys, goals)
+	variants := newVariants(fsys, sys.Facts)
+	renderer := newRenderer(fsys, sys)
+	analyst := newAnalyzer(fsys, target, sys, index, layers, variants, renderer)
+	targets := []string{target}
+	for _, g := range goals {
+		if g.target != "" && !slices.Contains(targets, g.target) {
+			targets = append(targets, g.target)
+		}
+	}
+	return &Planner{fsys, targets, analyst, layers, variants, renderer, goals, l, false}
+}
+
+// Execute returns a function that executes its [Plan] argument in the specified file system,
+// running up to jobs independent tasks concurrently.
+func Execute(fsys file.ActionFS, jobs int, l *slog.Logger) func(p Plan) error {
+	execute := ExecuteContext(fsys, jobs, l)
+	return func(p Plan) error {
+		return execute(context.Background(), p)
+	}
+}
+
+// ExecuteContext is like [Execute],
+// but the returned function starts no more tasks once ctx is done.
+// If ctx is done before every task has started,
+// the function waits for the started tasks to finish
+// and returns an error that includes a [*CanceledError].
+func ExecuteContext(fsys file.ActionFS, jobs int, l *slog.Logger) func(ctx context.Context, p Plan) error {
+	return func(ctx context.Context, p Plan) error {
+		return p.execute(ctx, fsys, jobs, l)
+	}
 }
 
-// Execute returns a function that executes its [Plan] argument in the specified file system.
-func Execute(fsys file.ActionFS, l *slog.Logger) func(p Plan) error {
+// PrintStatus returns a function that writes the status
+// of the items in its [Plan] argument to w.
+// See [Plan.Status].
+func PrintStatus(w io.Writer) func(p Plan) error {
 	return func(p Plan) error {
-		return p.execute(fsys, l)
+		return json.MarshalWrite(w, p.Status, json.Deterministic(true))
 	}
 }
 
@@ -38,27 +96,99 @@ func Print(w io.Writer) func(p Plan) error {
 
 // A Planner plans how to realize a set of goals in a target tree.
 type Planner struct {
-	target   string
-	analyzer *analyzer
-	goals    []DirGoal
-	logger   *slog.Logger
+	fsys      fs.ReadLinkFS
+	targets   []string
+	analyzer  *analyzer
+	layers    *layers
+	variants  *variants
+	renderer  *renderer
+	goals     []DirGoal
+	logger    *slog.Logger
+	validated bool
 }
 
-// Plan creates a plan to realize p's goals in its target tree.
-func (p Planner) Plan() (Plan, error) {
+// Plan creates a plan to realize p's goals in its target trees.
+// It first checks the goals with [Planner.Validate].
+func (p *Planner) Plan() (Plan, error) {
+	return p.PlanContext(context.Background())
+}
+
+// PlanContext is like [Planner.Plan],
+// but stops analyzing the goals once ctx is done,
+// and returns ctx's error.
+// Analysis checks ctx before each goal and before each source item.
+func (p *Planner) PlanContext(ctx context.Context) (Plan, error) {
+	if err := p.Validate(); err != nil {
+		return Plan{}, err
+	}
 	for _, goal := range p.goals {
-		if err := p.analyzer.analyze(goal, p.logger); err != nil {
+		if err := ctx.Err(); err != nil {
 			return Plan{}, err
 		}
+		if err := p.analyzer.analyze(ctx, goal, p.logger); err != nil {
+			return Plan{}, err
+		}
+	}
+	if err := p.renderer.plan(p.analyzer.index, p.logger); err != nil {
+		return Plan{}, err
+	}
+	plan := newPlan(p.targets, p.analyzer.index)
+	for _, goal := range p.goals {
+		if len(goal.profiles) == 0 {
+			continue
+		}
+		if plan.Profiles == nil {
+			plan.Profiles = map[string][]string{}
+		}
+		plan.Profiles[goal.dir.String()] = goal.profiles
+	}
+	if len(p.layers.shadows) > 0 {
+		plan.Shadows = p.layers.shadows
+	}
+	if len(p.variants.items) > 0 {
+		plan.Variants = p.variants.items
+	}
+	if len(p.analyzer.status.items) > 0 {
+		plan.Status = p.analyzer.status.items
 	}
-	return newPlan(p.target, p.analyzer.index), nil
+	return plan, nil
 }
 
-// A Plan is a sequence of tasks
-// to bring the file tree rooted at target to the desired state.
+// A Plan is a collection of tasks
+// to bring one or more target file trees to the desired state.
 type Plan struct {
-	Target string          `json:"target"` // The root of the target file tree for the tasks to change.
-	Tasks  map[string]Task `json:"tasks"`  // The file tasks to apply to the target.
+	// Targets maps the root of each target file tree
+	// to the tasks that change the tree.
+	Targets map[string]Tasks `json:"targets"`
+
+	// Profiles maps each package introduced by a profile
+	// to the chain of profiles that introduced it.
+	Profiles map[string][]string `json:"profiles,omitempty"`
+
+	// Shadows maps the path to each target item that several packages provide
+	// to the package that provides it and the packages it shadows.
+	Shadows map[string]Shadow `json:"shadows,omitempty"`
+
+	// Variants maps the path to each target item
+	// provided by a conditional variant to the chosen variant.
+	Variants map[string]string `json:"variants,omitempty"`
+
+	// Status maps the path to each target item
+	// whose status a goal reports to the item's status:
+	// "installed" if it provides its source item,
+	// "missing" if it does not exist,
+	// or "conflict" if it is some other file.
+	// See [StatusItem].
+	Status map[string]string `json:"status,omitempty"`
+}
+
+// Tasks maps each item in a target file tree to the task that changes it.
+type Tasks map[string]Task
+
+// A Shadow describes a target item that several packages provide.
+type Shadow struct {
+	Package  string   `json:"package"`  // The package that provides the target item.
+	Shadowed []string `json:"shadowed"` // The lower priority packages that also provide the item.
 }
 
 // print writes the JSON encoding of the Plan to [io.Writer] w.
@@ -67,33 +197,97 @@ func (p Plan) print(w io.Writer) error {
 }
 
 // execute executes the Plan in [file.ActionFS] fsys.
-func (p Plan) execute(fsys file.ActionFS, _ *slog.Logger) error {
-	for _, item := range slices.Sorted(maps.Keys(p.Tasks)) {
-		task := p.Tasks[item]
-		name := path.Join(p.Target, item)
-		if err := task.Execute(fsys, name); err != nil {
-			return err
+// The tasks for each target are executed in dependency order
+// (see [Tasks.dependencies]) in an ActionFS
+// confined to the target tree. See [file.Sub].
+// Up to jobs tasks that do not depend on each other
+// execute concurrently. Once ctx is done, no more tasks start.
+// See [runJobs].
+// Before executing any task, execute checks that no task's item
+// escapes its target, and opens the confined ActionFS for each target.
+func (p Plan) execute(ctx context.Context, fsys file.ActionFS, jobs int, _ *slog.Logger) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	subs := map[string]file.ActionFS{}
+	defer func() {
+		for _, sub := range subs {
+			if c, ok := sub.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}()
+	for _, target := range slices.Sorted(maps.Keys(p.Targets)) {
+		sub, err := file.Sub(fsys, target)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
 		}
+		subs[target] = sub
 	}
-	return nil
+
+	js, err := newJobs(p, subs)
+	if err != nil {
+		return err
+	}
+	return runJobs(ctx, js, jobs)
 }
 
-// newPlan returns a [Plan] to bring the target tree
-// to its planned state.
+// validate checks that each target in p is a valid path,
+// that each item is a path to a file inside its target,
+// and that the tasks for each target can be ordered by their dependencies.
+func (p Plan) validate() error {
+	var errs []error
+	for _, target := range slices.Sorted(maps.Keys(p.Targets)) {
+		if !fs.ValidPath(target) {
+			errs = append(errs, fmt.Errorf("target %q: %w: not a valid path", target, fs.ErrInvalid))
+			continue
+		}
+		for _, item := range slices.Sorted(maps.Keys(p.Targets[target])) {
+			if item == "." || !fs.ValidPath(item) {
+				errs = append(errs, fmt.Errorf("target %s: item %q: %w: escapes the target",
+					target, item, fs.ErrInvalid))
+			}
+		}
+		if _, err := p.Targets[target].order(); err != nil {
+			errs = append(errs, fmt.Errorf("target %s: %w", target, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newPlan returns a [Plan] to bring the target trees
+// to their planned states.
 // Specs describes the current and planned state of each file.
-func newPlan(target string, specs specs) Plan {
-	targetLen := len(target) + 1
-	p := Plan{Target: target, Tasks: map[string]Task{}}
+// Each file belongs to the deepest target tree that contains it.
+func newPlan(targets []string, specs specs) Plan {
+	p := Plan{Targets: map[string]Tasks{}}
+	for _, target := range targets {
+		p.Targets[target] = Tasks{}
+	}
 	for name, spec := range specs.all() {
 		if spec.current == spec.planned {
 			continue
 		}
-		item := name[targetLen:]
-		p.Tasks[item] = newTask(spec.current, spec.planned)
+		target := containingTarget(targets, name)
+		item := name[len(target)+1:]
+		p.Targets[target][item] = newTask(spec.current, spec.planned)
 	}
 	return p
 }
 
+// containingTarget returns the longest of the targets
+// that contains the named file.
+func containingTarget(targets []string, name string) string {
+	var found string
+	for _, target := range targets {
+		if len(target) > len(found) && strings.HasPrefix(name, target+"/") {
+			found = target
+		}
+	}
+	return found
+}
+
 // newTask creates a [Task] with the actions to bring file
 // from the current state to the planned state.
 func newTask(current, planned file.State) Task {
@@ -103,6 +297,11 @@ func newTask(current, planned file.State) Task {
 	case current.IsNoFile(): // No-op
 	case current.IsLink():
 		t = append(t, file.RemoveAction())
+	case current.IsRegular() && planned.Rendered:
+		// Rendering replaces the content of the file.
+	case current.IsRegular() && planned.IsNoFile():
+		// Uninstalling removes a file rendered from a template.
+		t = append(t, file.RemoveAction())
 	default:
 		panic("do not know an action to remove " + current.String())
 	}
@@ -113,6 +312,8 @@ func newTask(current, planned file.State) Task {
 		t = append(t, file.MkdirAction())
 	case planned.IsLink():
 		t = append(t, file.SymlinkAction(planned.Dest.Path))
+	case planned.Rendered:
+		t = append(t, file.RenderAction(planned.Content, planned.Perm))
 	default:
 		panic("do not know an action to create " + planned.String())
 	}
@@ -1,11 +1,24 @@
 package plan
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"iter"
+	"log/slog"
 	"maps"
+	"path"
+	"slices"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/dhemery/duffel/internal/duftest"
+	"github.com/dhemery/duffel/internal/errfs"
 	"github.com/dhemery/duffel/internal/file"
+	"github.com/dhemery/duffel/internal/log"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
@@ -19,7 +32,7 @@ func (sm specMap) all() iter.Seq2[string, spec] {
 func TestNewPlan(t *testing.T) {
 	tests := map[string]struct {
 		specs     specMap
-		wantTasks map[string]Task
+		wantTasks Tasks
 	}{
 		"plans no task if current and planned are equal": {
 			specs: specMap{
@@ -50,7 +63,7 @@ func TestNewPlan(t *testing.T) {
 					planned: file.LinkState("some/dest", file.TypeSymlink),
 				},
 			},
-			wantTasks: map[string]Task{},
+			wantTasks: Tasks{},
 		},
 		"plans tasks if current and planned differ": {
 			specs: specMap{
@@ -67,7 +80,7 @@ func TestNewPlan(t *testing.T) {
 					planned: file.DirState(),
 				},
 			},
-			wantTasks: map[string]Task{
+			wantTasks: Tasks{
 				"link-to-dir": {file.RemoveAction(), file.MkdirAction()},
 				"new-dir":     {file.MkdirAction()},
 				"new-link":    {file.SymlinkAction("some/dest")},
@@ -78,9 +91,9 @@ func TestNewPlan(t *testing.T) {
 	for desc, test := range tests {
 		const target = "target"
 		t.Run(desc, func(t *testing.T) {
-			p := newPlan(target, test.specs)
+			p := newPlan([]string{target}, test.specs)
 
-			wantPlan := Plan{Target: target, Tasks: test.wantTasks}
+			wantPlan := Plan{Targets: map[string]Tasks{target: test.wantTasks}}
 
 			if diff := cmp.Diff(wantPlan, p); diff != "" {
 				t.Error("Plan: ", diff)
@@ -89,6 +102,116 @@ func TestNewPlan(t *testing.T) {
 	}
 }
 
+func TestNewPlanTargets(t *testing.T) {
+	targets := []string{"home/user", "etc/xdg", "home/user/.config/app"}
+	specs := specMap{
+		"home/user/.bashrc":            spec{file.NoFileState(), file.LinkState("dest/.bashrc", file.TypeFile)},
+		"etc/xdg/app.conf":             spec{file.NoFileState(), file.LinkState("dest/app.conf", file.TypeFile)},
+		"home/user/.config/app/config": spec{file.NoFileState(), file.LinkState("dest/config", file.TypeFile)},
+	}
+
+	p := newPlan(targets, specs)
+
+	// Each file belongs to the deepest target that contains it.
+	want := Plan{Targets: map[string]Tasks{
+		"home/user":             {".bashrc": {file.SymlinkAction("dest/.bashrc")}},
+		"etc/xdg":               {"app.conf": {file.SymlinkAction("dest/app.conf")}},
+		"home/user/.config/app": {"config": {file.SymlinkAction("dest/config")}},
+	}}
+	if diff := cmp.Diff(want, p); diff != "" {
+		t.Error("Plan: ", diff)
+	}
+}
+
+func TestPlanExecute(t *testing.T) {
+	tests := map[string]struct {
+		targets   map[string]Tasks
+		wantErr   error
+		wantFiles []string // Files that exist after executing the plan.
+		wantNone  []string // Files that do not exist after executing the plan.
+		existing  []string // Dirs that exist before executing the plan.
+	}{
+		"tasks in several targets": {
+			targets: map[string]Tasks{
+				"home/user": {"dir": {file.MkdirAction()}},
+				"etc/xdg":   {"link": {file.SymlinkAction("some/dest")}},
+			},
+			wantFiles: []string{"home/user/dir", "etc/xdg/link"},
+		},
+		"removes contents before their dir": {
+			targets: map[string]Tasks{
+				"home/user": {
+					"dir":         {file.RemoveAction()},
+					"dir/link":    {file.RemoveAction()},
+					"dir/sub":     {file.RemoveAction()},
+					"dir/sub/dir": {file.RemoveAction()},
+				},
+			},
+			existing: []string{"home/user/dir/link", "home/user/dir/sub/dir"},
+			wantNone: []string{"home/user/dir"},
+		},
+		"creates item in removed dir": {
+			targets: map[string]Tasks{
+				"home/user": {
+					"dir":      {file.RemoveAction()},
+					"dir/link": {file.SymlinkAction("some/dest")},
+				},
+			},
+			existing:  []string{"home/user/dir"},
+			wantErr:   errCreateInRemoved,
+			wantFiles: []string{"home/user/dir"},
+		},
+		"item escapes target": {
+			targets: map[string]Tasks{
+				"home/user": {"dir": {file.MkdirAction()}},
+				"etc/xdg":   {"../escape": {file.MkdirAction()}},
+			},
+			wantErr:  fs.ErrInvalid,
+			wantNone: []string{"home/user/dir", "etc/escape"},
+		},
+		"absolute item": {
+			targets: map[string]Tasks{
+				"home/user": {"/etc/escape": {file.MkdirAction()}},
+			},
+			wantErr:  fs.ErrInvalid,
+			wantNone: []string{"etc/escape"},
+		},
+		"invalid target": {
+			targets: map[string]Tasks{
+				"/home/user": {"dir": {file.MkdirAction()}},
+			},
+			wantErr:  fs.ErrInvalid,
+			wantNone: []string{"home/user/dir"},
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			testFS := errfs.New()
+			errfs.AddDir(testFS, "home/user", 0o755)
+			errfs.AddDir(testFS, "etc/xdg", 0o755)
+			for _, name := range test.existing {
+				errfs.AddDir(testFS, name, 0o755)
+			}
+
+			err := Plan{Targets: test.targets}.execute(context.Background(), testFS, 1, nil)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error:\n got: %v\nwant: %v", err, test.wantErr)
+			}
+			for _, name := range test.wantFiles {
+				if _, err := testFS.Lstat(name); err != nil {
+					t.Errorf("want file %s: %v", name, err)
+				}
+			}
+			for _, name := range test.wantNone {
+				if _, err := testFS.Lstat(name); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("want no file %s, got error %v", name, err)
+				}
+			}
+		})
+	}
+}
+
 func TestNewTask(t *testing.T) {
 	tests := map[string]struct {
 		current  file.State
@@ -110,6 +233,26 @@ func TestNewTask(t *testing.T) {
 			planned:  file.DirState(),
 			wantTask: Task{file.RemoveAction(), file.MkdirAction()},
 		},
+		"from no file to rendered file": {
+			current:  file.NoFileState(),
+			planned:  file.RenderedState("content", 0o644),
+			wantTask: Task{file.RenderAction("content", 0o644)},
+		},
+		"from file to rendered file": {
+			current:  file.FileState(),
+			planned:  file.RenderedState("content", 0o644),
+			wantTask: Task{file.RenderAction("content", 0o644)},
+		},
+		"from file to no file": {
+			current:  file.FileState(),
+			planned:  file.NoFileState(),
+			wantTask: Task{file.RemoveAction()},
+		},
+		"from symlink to rendered file": {
+			current:  file.LinkState("some/dest", file.TypeNoFile),
+			planned:  file.RenderedState("content", 0o644),
+			wantTask: Task{file.RemoveAction(), file.RenderAction("content", 0o644)},
+		},
 	}
 
 	for desc, test := range tests {
@@ -124,3 +267,241 @@ func TestNewTask(t *testing.T) {
 		})
 	}
 }
+
+func TestPlannerPrefetchSamePlan(t *testing.T) {
+	testFS := errfs.New()
+	addSyntheticTree(testFS, 8, 8)
+
+	plans := map[int][]byte{}
+	for _, jobs := range []int{1, 8} {
+		var logbuf bytes.Buffer
+		logger := log.Logger(&logbuf, duftest.LogLevel)
+		goals := []DirGoal{InstallPackage("source", "pkg")}
+		planner := NewPlanner(testFS, "target", goals, System{}, jobs, logger)
+
+		p, err := planner.Plan()
+		if err != nil {
+			duftest.Dump(t, "log", &logbuf)
+			t.Fatalf("jobs %d: plan error: %v", jobs, err)
+		}
+		var out bytes.Buffer
+		if err := p.print(&out); err != nil {
+			t.Fatal(err)
+		}
+		plans[jobs] = out.Bytes()
+	}
+
+	if len(plans[1]) == 0 || bytes.Equal(plans[1], []byte(`{"targets":{"target":{}}}`)) {
+		t.Fatalf("sequential plan has no tasks: %s", plans[1])
+	}
+	if !bytes.Equal(plans[1], plans[8]) {
+		t.Errorf("plans differ:\n sequential: %s\n concurrent: %s", plans[1], plans[8])
+	}
+}
+
+func TestPlannerPlanContext(t *testing.T) {
+	testFS := errfs.New()
+	addSyntheticTree(testFS, 6, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Cancel while walking the second dir in the package.
+	fsys := &cancelingFS{FS: testFS, cancelAt: "source/pkg/dir001", cancel: cancel}
+
+	var logbuf bytes.Buffer
+	logger := log.Logger(&logbuf, duftest.LogLevel)
+	defer duftest.Dump(t, "log", &logbuf)
+	goals := []DirGoal{InstallPackage("source", "pkg")}
+	planner := NewPlanner(fsys, "target", goals, System{}, 1, logger)
+
+	_, err := planner.PlanContext(ctx)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error: got %v, want %v", err, context.Canceled)
+	}
+	if slices.Contains(fsys.read, "source/pkg/dir002") {
+		t.Errorf("walk continued after cancellation: read %v", fsys.read)
+	}
+}
+
+func TestCanceledErrorMessage(t *testing.T) {
+	tests := map[string]struct {
+		err  *CanceledError
+		want string
+	}{
+		"no task finished": {
+			err:  &CanceledError{Err: context.Canceled},
+			want: "plan canceled before any task finished: context canceled",
+		},
+		"some tasks finished": {
+			err: &CanceledError{
+				Finished: map[string][]string{"home": {".config", ".bashrc"}, "etc": {"motd"}},
+				Err:      context.Canceled,
+			},
+			want: "plan canceled: context canceled: finished tasks for etc/motd, home/.config, home/.bashrc",
+		},
+	}
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			if got := test.err.Error(); got != test.want {
+				t.Errorf("Error():\n got: %s\nwant: %s", got, test.want)
+			}
+		})
+	}
+}
+
+func BenchmarkPlanner(b *testing.B) {
+	testFS := errfs.New()
+	addSyntheticTree(testFS, 20, 50)
+	fsys := slowFS{testFS, 50 * time.Microsecond}
+	logger := slog.New(slog.DiscardHandler)
+
+	for _, jobs := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			for b.Loop() {
+				goals := []DirGoal{InstallPackage("source", "pkg")}
+				planner := NewPlanner(fsys, "target", goals, System{}, jobs, logger)
+				if _, err := planner.Plan(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPlannerCalls reports the number of calls that planning makes
+// to each method of the file system,
+// when the planner reads directly from the file system
+// and when it reads through a [file.CacheFS].
+func BenchmarkPlannerCalls(b *testing.B) {
+	testFS := errfs.New()
+	addSyntheticTree(testFS, 20, 50)
+	logger := slog.New(slog.DiscardHandler)
+
+	for desc, plannerFunc := range map[string]func(fs.ReadLinkFS, string, []DirGoal, System, int, *slog.Logger) *Planner{
+		"uncached": newPlanner,
+		"cached":   NewPlanner,
+	} {
+		b.Run(desc, func(b *testing.B) {
+			fsys := &countingFS{FS: testFS}
+			for b.Loop() {
+				goals := []DirGoal{InstallPackage("source", "pkg")}
+				planner := plannerFunc(fsys, "target", goals, System{}, 1, logger)
+				if _, err := planner.Plan(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			n := float64(b.N)
+			b.ReportMetric(float64(fsys.lstats.Load())/n, "lstats/op")
+			b.ReportMetric(float64(fsys.stats.Load())/n, "stats/op")
+			b.ReportMetric(float64(fsys.readDirs.Load())/n, "readdirs/op")
+			b.ReportMetric(float64(fsys.readLinks.Load())/n, "readlinks/op")
+			b.ReportMetric(float64(fsys.readFiles.Load())/n, "readfiles/op")
+			b.ReportMetric(float64(fsys.opens.Load())/n, "opens/op")
+		})
+	}
+}
+
+// addSyntheticTree adds a source with a package that has the given number of dirs,
+// each holding the given number of files,
+// and a target tree in which some of the dirs already exist.
+// Of the existing dirs, some are real dirs
+// that hold a file that the package does not provide,
+// and some are links to dirs installed from another package.
+// Installing the package links the missing dirs
+// and the files in the existing real dirs,
+// and merges the linked dirs.
+func addSyntheticTree(fsys *errfs.FS, dirs, files int) {
+	errfs.AddFile(fsys, path.Join("source", file.SourceMarkerFile), 0o644)
+	for d := range dirs {
+		dir := fmt.Sprintf("dir%03d", d)
+		for f := range files {
+			errfs.AddFile(fsys, fmt.Sprintf("source/pkg/%s/file%03d", dir, f), 0o644)
+		}
+		switch d % 3 {
+		case 0:
+			errfs.AddFile(fsys, path.Join("target", dir, "other"), 0o644)
+		case 1:
+			for f := range files {
+				errfs.AddFile(fsys, fmt.Sprintf("source/old/%s/old%03d", dir, f), 0o644)
+			}
+			errfs.AddLink(fsys, path.Join("target", dir), path.Join("../source/old", dir))
+		}
+	}
+	errfs.AddDir(fsys, "target", 0o755)
+}
+
+// A slowFS is a file system that delays each call that reads a file's metadata,
+// like a file system on a slow or network-mounted disk.
+type slowFS struct {
+	*errfs.FS
+	delay time.Duration
+}
+
+func (f slowFS) Lstat(name string) (fs.FileInfo, error) {
+	time.Sleep(f.delay)
+	return f.FS.Lstat(name)
+}
+
+func (f slowFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	time.Sleep(f.delay)
+	return f.FS.ReadDir(name)
+}
+
+func (f slowFS) ReadLink(name string) (string, error) {
+	time.Sleep(f.delay)
+	return f.FS.ReadLink(name)
+}
+
+// A countingFS counts the calls to each method of a file system.
+// It is safe for concurrent use.
+type countingFS struct {
+	*errfs.FS
+	lstats, stats, readDirs, readLinks, readFiles, opens atomic.Int64
+}
+
+func (f *countingFS) Lstat(name string) (fs.FileInfo, error) {
+	f.lstats.Add(1)
+	return f.FS.Lstat(name)
+}
+
+func (f *countingFS) Stat(name string) (fs.FileInfo, error) {
+	f.stats.Add(1)
+	return f.FS.Stat(name)
+}
+
+func (f *countingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f.readDirs.Add(1)
+	return f.FS.ReadDir(name)
+}
+
+func (f *countingFS) ReadLink(name string) (string, error) {
+	f.readLinks.Add(1)
+	return f.FS.ReadLink(name)
+}
+
+func (f *countingFS) ReadFile(name string) ([]byte, error) {
+	f.readFiles.Add(1)
+	return f.FS.ReadFile(name)
+}
+
+func (f *countingFS) Open(name string) (fs.File, error) {
+	f.opens.Add(1)
+	return f.FS.Open(name)
+}
+
+// A cancelingFS is a file system that records the dirs it reads,
+// and calls cancel when it reads the dir named by cancelAt.
+type cancelingFS struct {
+	*errfs.FS
+	cancelAt string
+	cancel   func()
+	read     []string
+}
+
+func (f *cancelingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f.read = append(f.read, name)
+	if name == f.cancelAt {
+		f.cancel()
+	}
+	return f.FS.ReadDir(name)
+}
@@ -0,0 +1,321 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json/v2"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/dhemery/duffel/internal/config"
+	"github.com/dhemery/duffel/internal/facts"
+	"github.com/dhemery/duffel/internal/file"
+)
+
+// templateSuffix marks a regular source file as a template
+// to render into a regular file in the target tree.
+const templateSuffix = ".tmpl"
+
+// recordFile is the name of the file in the target dir
+// that records a hash of the content of each rendered target item.
+const recordFile = ".duffel-rendered"
+
+// isTemplate reports whether s is a template.
+func isTemplate(s sourceItem) bool {
+	return s.Type.IsRegular() && strings.HasSuffix(stripConditions(s.Path.item), templateSuffix)
+}
+
+// targetName returns the name of the target item for a source item.
+// The name omits any conditions and,
+// if the source item is a regular file, any template suffix.
+func targetName(item string, regular bool) string {
+	name := stripConditions(item)
+	if regular {
+		name = strings.TrimSuffix(name, templateSuffix)
+	}
+	return name
+}
+
+// templateData is the data for rendering a template.
+type templateData struct {
+	Vars  map[string]string // Variables from the source configuration.
+	Env   map[string]string // Environment variables.
+	Facts facts.Facts       // Facts about the system.
+}
+
+// newRenderer returns a [renderer] that renders templates
+// into target trees.
+func newRenderer(fsys fs.FS, sys System) *renderer {
+	return &renderer{
+		fsys:    fsys,
+		sys:     sys,
+		vars:    map[string]map[string]string{},
+		records: map[string]map[string]string{},
+		changed: map[string]bool{},
+	}
+}
+
+// A renderer renders templates
+// and tracks the content of the rendered files.
+type renderer struct {
+	fsys    fs.FS
+	sys     System
+	vars    map[string]map[string]string // The template vars for each source dir.
+	records map[string]map[string]string // The recorded hash of each rendered item in each target tree.
+	changed map[string]bool              // Whether the record for each target tree has changed.
+}
+
+// render returns the state of the target item file
+// that would result from rendering the source template item.
+// If the target file holds the rendered content, its state is unchanged.
+// If the target file holds content previously rendered by duffel,
+// the resulting state replaces the content.
+// If the target file holds other content, render returns an error.
+func (r *renderer) render(s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	content, err := r.execute(s)
+	if err != nil {
+		return file.State{}, err
+	}
+
+	targetState := t.State
+	switch {
+	case targetState.IsNoFile(), targetState.IsLink() && targetState.Dest.FinalType().IsNoFile():
+		// There is no target file, or it links to nothing.
+	case targetState.Rendered:
+		// Another template already renders the target item.
+		return file.State{}, newConflictError(s, t)
+	case targetState.IsRegular():
+		current, err := fs.ReadFile(r.fsys, t.Path.String())
+		if err != nil {
+			return file.State{}, err
+		}
+		if string(current) == content {
+			// The target already holds the rendered content.
+			if err := r.track(t.Path, content); err != nil {
+				return file.State{}, err
+			}
+			return targetState, nil
+		}
+		recorded, err := r.recorded(t.Path)
+		if err != nil {
+			return file.State{}, err
+		}
+		if recorded == "" {
+			// Duffel did not render the target file.
+			return file.State{}, newConflictError(s, t)
+		}
+		if recorded != hash(string(current)) {
+			// Someone edited the target file since duffel rendered it.
+			return file.State{}, &EditedError{Source: s.Path.String(), Target: t.Path.String()}
+		}
+	default:
+		return file.State{}, newConflictError(s, t)
+	}
+
+	// The rendered file has the template's permission bits,
+	// so that an executable template renders an executable file.
+	info, err := fs.Stat(r.fsys, s.Path.String())
+	if err != nil {
+		return file.State{}, err
+	}
+
+	l.Info("rendering", slog.Any("source", s), slog.Any("target", t))
+	if err := r.track(t.Path, content); err != nil {
+		return file.State{}, err
+	}
+	return file.RenderedState(content, info.Mode().Perm()), nil
+}
+
+// remove returns the state of the target item file
+// that would result from uninstalling the source template item.
+// If the target file holds the content that duffel last rendered into it,
+// the resulting state has no file.
+// If the target file is not a regular file,
+// or duffel did not render it, its state is unchanged.
+// If someone edited the target file since duffel rendered it,
+// remove returns an [*EditedError].
+func (r *renderer) remove(s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	if !t.State.IsRegular() || t.State.Rendered {
+		return t.State, nil
+	}
+	recorded, err := r.recorded(t.Path)
+	if err != nil {
+		return file.State{}, err
+	}
+	if recorded == "" {
+		// Duffel did not render the target file.
+		return t.State, nil
+	}
+	current, err := fs.ReadFile(r.fsys, t.Path.String())
+	if err != nil {
+		return file.State{}, err
+	}
+	if recorded != hash(string(current)) {
+		return file.State{}, &EditedError{Source: s.Path.String(), Target: t.Path.String()}
+	}
+
+	l.Info("removing", slog.Any("source", s), slog.Any("target", t))
+	if err := r.untrack(t.Path); err != nil {
+		return file.State{}, err
+	}
+	return file.NoFileState(), nil
+}
+
+// rendered reports whether the target item file
+// holds the content rendered from the source template item.
+func (r *renderer) rendered(s sourceItem, t targetItem) (bool, error) {
+	if !t.State.IsRegular() || t.State.Rendered {
+		return false, nil
+	}
+	content, err := r.execute(s)
+	if err != nil {
+		return false, err
+	}
+	current, err := fs.ReadFile(r.fsys, t.Path.String())
+	if err != nil {
+		return false, err
+	}
+	return string(current) == content, nil
+}
+
+// execute renders the template item.
+func (r *renderer) execute(s sourceItem) (string, error) {
+	name := s.Path.String()
+	text, err := fs.ReadFile(r.fsys, name)
+	if err != nil {
+		return "", err
+	}
+
+	vars, err := r.sourceVars(s.Path.source)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New(path.Base(name)).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return "", fmt.Errorf("template %s: %w: %w", name, fs.ErrInvalid, err)
+	}
+
+	var out strings.Builder
+	data := templateData{Vars: vars, Env: r.sys.Env, Facts: r.sys.Facts}
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("template %s: %w: %w", name, fs.ErrInvalid, err)
+	}
+	return out.String(), nil
+}
+
+// sourceVars returns the template vars from the configuration of the source.
+func (r *renderer) sourceVars(source string) (map[string]string, error) {
+	vars, ok := r.vars[source]
+	if !ok {
+		c, err := config.ReadSource(r.fsys, source)
+		if err != nil {
+			return nil, err
+		}
+		vars = c.Vars
+		r.vars[source] = vars
+	}
+	return vars, nil
+}
+
+// recorded returns the recorded hash of the target item's rendered content,
+// or "" if there is no record of the item.
+func (r *renderer) recorded(t targetPath) (string, error) {
+	record, err := r.record(t.target)
+	if err != nil {
+		return "", err
+	}
+	return record[t.item], nil
+}
+
+// track records the hash of the target item's rendered content.
+func (r *renderer) track(t targetPath, content string) error {
+	record, err := r.record(t.target)
+	if err != nil {
+		return err
+	}
+	h := hash(content)
+	if record[t.item] != h {
+		record[t.item] = h
+		r.changed[t.target] = true
+	}
+	return nil
+}
+
+// untrack removes the target item from the record of rendered items.
+func (r *renderer) untrack(t targetPath) error {
+	record, err := r.record(t.target)
+	if err != nil {
+		return err
+	}
+	if _, ok := record[t.item]; ok {
+		delete(record, t.item)
+		r.changed[t.target] = true
+	}
+	return nil
+}
+
+// record returns the record of the items rendered in the target tree,
+// reading the tree's record file if r has not already read it.
+func (r *renderer) record(target string) (map[string]string, error) {
+	if record, ok := r.records[target]; ok {
+		return record, nil
+	}
+
+	record := map[string]string{}
+	name := path.Join(target, recordFile)
+	data, err := fs.ReadFile(r.fsys, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("%s: %w: %w", name, fs.ErrInvalid, err)
+		}
+	}
+	r.records[target] = record
+	return record, nil
+}
+
+// plan plans to update the record file in each target tree
+// in which the rendered content of any item has changed.
+func (r *renderer) plan(i index, l *slog.Logger) error {
+	for _, target := range slices.Sorted(maps.Keys(r.changed)) {
+		data, err := json.Marshal(r.records[target], json.Deterministic(true))
+		if err != nil {
+			return err
+		}
+
+		recordPath := newTargetPath(target, recordFile)
+		if _, err := i.state(recordPath, l); err != nil {
+			return err
+		}
+		i.setState(recordPath, file.RenderedState(string(data), 0o644), l)
+	}
+	return nil
+}
+
+// hash returns the hex encoding of the SHA-256 hash of content.
+func hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// An EditedError indicates that a target item file
+// has changed since duffel rendered it.
+type EditedError struct {
+	Source string // The full path to the template source item.
+	Target string // The full path to the edited target item.
+}
+
+func (ee *EditedError) Error() string {
+	return fmt.Sprintf("render conflict: target item %q has changed since it was rendered from %q",
+		ee.Target, ee.Source)
+}
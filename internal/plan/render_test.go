@@ -0,0 +1,362 @@
+package plan
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dhemery/duffel/internal/duftest"
+	"github.com/dhemery/duffel/internal/errfs"
+	"github.com/dhemery/duffel/internal/facts"
+	"github.com/dhemery/duffel/internal/file"
+	"github.com/dhemery/duffel/internal/log"
+)
+
+func TestRenderer(t *testing.T) {
+	const (
+		source   = "home/user/source"
+		target   = "home/user"
+		template = "email = {{.Vars.email}}\nhost = {{.Facts.host}}\nshell = {{.Env.SHELL}}\n"
+		rendered = "email = me@example.com\nhost = build01\nshell = /bin/zsh\n"
+	)
+	sys := System{
+		Facts: facts.Facts{facts.Host: "build01"},
+		Env:   map[string]string{"SHELL": "/bin/zsh"},
+	}
+	recordOf := func(content string) string {
+		return `{".gitconfig":"` + hash(content) + `"}`
+	}
+
+	tests := []struct {
+		desc        string
+		template    string      // The content of the template.
+		perm        fs.FileMode // The permission bits of the template. If zero, 0o644.
+		targetState file.State  // The planned state of the target item.
+		targetData  *string     // The content of the target file, if any.
+		record      *string     // The content of the record file, if any.
+		wantState   file.State  // The state result.
+		wantErr     error       // The error result.
+		wantRecord  *file.State // The planned state of the record file, if any.
+	}{
+		{
+			desc:        "no target file",
+			template:    template,
+			targetState: file.NoFileState(),
+			wantState:   file.RenderedState(rendered, 0o644),
+			wantRecord:  ptr(file.RenderedState(recordOf(rendered), 0o644)),
+		},
+		{
+			desc:        "executable template",
+			template:    template,
+			perm:        0o755,
+			targetState: file.NoFileState(),
+			wantState:   file.RenderedState(rendered, 0o755),
+			wantRecord:  ptr(file.RenderedState(recordOf(rendered), 0o644)),
+		},
+		{
+			desc:        "target links to nothing",
+			template:    template,
+			targetState: file.LinkState("nowhere", file.TypeNoFile),
+			wantState:   file.RenderedState(rendered, 0o644),
+			wantRecord:  ptr(file.RenderedState(recordOf(rendered), 0o644)),
+		},
+		{
+			desc:        "target holds rendered content",
+			template:    template,
+			targetState: file.FileState(),
+			targetData:  ptr(rendered),
+			record:      ptr(recordOf(rendered)),
+			wantState:   file.FileState(), // Unchanged.
+		},
+		{
+			desc:        "unrecorded target holds rendered content",
+			template:    template,
+			targetState: file.FileState(),
+			targetData:  ptr(rendered),
+			wantState:   file.FileState(),
+			wantRecord:  ptr(file.RenderedState(recordOf(rendered), 0o644)),
+		},
+		{
+			desc:        "template changed since target was rendered",
+			template:    template,
+			targetState: file.FileState(),
+			targetData:  ptr("old content"),
+			record:      ptr(recordOf("old content")),
+			wantState:   file.RenderedState(rendered, 0o644),
+			wantRecord:  ptr(file.RenderedState(recordOf(rendered), 0o644)),
+		},
+		{
+			desc:        "target edited since it was rendered",
+			template:    template,
+			targetState: file.FileState(),
+			targetData:  ptr("edited content"),
+			record:      ptr(recordOf("old content")),
+			wantErr:     &EditedError{},
+		},
+		{
+			desc:        "target file was not rendered",
+			template:    template,
+			targetState: file.FileState(),
+			targetData:  ptr("other content"),
+			wantErr:     &ConflictError{},
+		},
+		{
+			desc:        "target rendered earlier in this plan",
+			template:    template,
+			targetState: file.RenderedState("other template output", 0o644),
+			wantErr:     &ConflictError{},
+		},
+		{
+			desc:        "target is a dir",
+			template:    template,
+			targetState: file.DirState(),
+			wantErr:     &ConflictError{},
+		},
+		{
+			desc:        "missing var",
+			template:    "{{.Vars.nosuchvar}}",
+			targetState: file.NoFileState(),
+			wantErr:     fs.ErrInvalid,
+		},
+		{
+			desc:        "malformed template",
+			template:    "{{.Vars.email",
+			targetState: file.NoFileState(),
+			wantErr:     fs.ErrInvalid,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logbuf bytes.Buffer
+			logger := log.Logger(&logbuf, duftest.LogLevel)
+			defer duftest.Dump(t, "log", &logbuf)
+
+			testFS := errfs.New()
+			errfs.Add(testFS, errfs.NewFileData(path.Join(source, file.SourceMarkerFile), 0o644,
+				[]byte(`{"vars": {"email": "me@example.com"}}`)))
+			perm := test.perm
+			if perm == 0 {
+				perm = 0o644
+			}
+			errfs.Add(testFS, errfs.NewFileData(path.Join(source, "pkg/.gitconfig.tmpl"), perm,
+				[]byte(test.template)))
+			if test.targetData != nil {
+				errfs.Add(testFS, errfs.NewFileData(path.Join(target, ".gitconfig"), 0o644,
+					[]byte(*test.targetData)))
+			}
+			if test.record != nil {
+				errfs.Add(testFS, errfs.NewFileData(path.Join(target, recordFile), 0o644,
+					[]byte(*test.record)))
+			}
+
+			r := newRenderer(testFS, sys)
+			s := newSourceItem(source, "pkg", ".gitconfig.tmpl", file.TypeFile)
+			tgt := newTargetItem(target, ".gitconfig", test.targetState)
+
+			gotState, err := r.render(s, tgt, logger)
+
+			switch test.wantErr.(type) {
+			case *EditedError:
+				var want *EditedError
+				if !errors.As(err, &want) {
+					t.Errorf("error: got %v, want %T", err, want)
+				}
+			case *ConflictError:
+				var want *ConflictError
+				if !errors.As(err, &want) {
+					t.Errorf("error: got %v, want %T", err, want)
+				}
+			default:
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("error: got %v, want %v", err, test.wantErr)
+				}
+			}
+			if diff := cmp.Diff(test.wantState, gotState); diff != "" {
+				t.Errorf("state:\n%s", diff)
+			}
+
+			index := newIndex(file.NewStater(testFS), 1)
+			if err := r.plan(index, logger); err != nil {
+				t.Fatal(err)
+			}
+			var gotRecord *file.State
+			for name, spec := range index.all() {
+				if name == path.Join(target, recordFile) {
+					gotRecord = &spec.planned
+				}
+			}
+			if diff := cmp.Diff(test.wantRecord, gotRecord); diff != "" {
+				t.Errorf("planned record state:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRendererRemove(t *testing.T) {
+	const (
+		source   = "home/user/source"
+		target   = "home/user"
+		rendered = "rendered content"
+	)
+	recordOf := func(content string) string {
+		return `{".gitconfig":"` + hash(content) + `"}`
+	}
+
+	tests := []struct {
+		desc        string
+		targetState file.State  // The planned state of the target item.
+		targetData  *string     // The content of the target file, if any.
+		record      *string     // The content of the record file, if any.
+		wantState   file.State  // The state result.
+		wantErr     error       // The error result.
+		wantRecord  *file.State // The planned state of the record file, if any.
+	}{
+		{
+			desc:        "target holds rendered content",
+			targetState: file.FileState(),
+			targetData:  ptr(rendered),
+			record:      ptr(recordOf(rendered)),
+			wantState:   file.NoFileState(),
+			wantRecord:  ptr(file.RenderedState("{}", 0o644)),
+		},
+		{
+			desc:        "no target file",
+			targetState: file.NoFileState(),
+			record:      ptr(recordOf(rendered)),
+			wantState:   file.NoFileState(),
+		},
+		{
+			desc:        "target file was not rendered",
+			targetState: file.FileState(),
+			targetData:  ptr("other content"),
+			wantState:   file.FileState(), // Unchanged.
+		},
+		{
+			desc:        "target edited since it was rendered",
+			targetState: file.FileState(),
+			targetData:  ptr("edited content"),
+			record:      ptr(recordOf(rendered)),
+			wantErr:     &EditedError{},
+		},
+		{
+			desc:        "target links to another file",
+			targetState: file.LinkState("other", file.TypeFile),
+			record:      ptr(recordOf(rendered)),
+			wantState:   file.LinkState("other", file.TypeFile), // Unchanged.
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logbuf bytes.Buffer
+			logger := log.Logger(&logbuf, duftest.LogLevel)
+			defer duftest.Dump(t, "log", &logbuf)
+
+			testFS := errfs.New()
+			errfs.Add(testFS, errfs.NewFileData(path.Join(source, "pkg/.gitconfig.tmpl"), 0o644,
+				[]byte(rendered)))
+			if test.targetData != nil {
+				errfs.Add(testFS, errfs.NewFileData(path.Join(target, ".gitconfig"), 0o644,
+					[]byte(*test.targetData)))
+			}
+			if test.record != nil {
+				errfs.Add(testFS, errfs.NewFileData(path.Join(target, recordFile), 0o644,
+					[]byte(*test.record)))
+			}
+
+			r := newRenderer(testFS, System{})
+			s := newSourceItem(source, "pkg", ".gitconfig.tmpl", file.TypeFile)
+			tgt := newTargetItem(target, ".gitconfig", test.targetState)
+
+			gotState, err := r.remove(s, tgt, logger)
+
+			if _, ok := test.wantErr.(*EditedError); ok {
+				var want *EditedError
+				if !errors.As(err, &want) {
+					t.Errorf("error: got %v, want %T", err, want)
+				}
+			} else if err != nil {
+				t.Errorf("error: got %v, want nil", err)
+			}
+			if diff := cmp.Diff(test.wantState, gotState); diff != "" {
+				t.Errorf("state:\n%s", diff)
+			}
+
+			index := newIndex(file.NewStater(testFS), 1)
+			if err := r.plan(index, logger); err != nil {
+				t.Fatal(err)
+			}
+			var gotRecord *file.State
+			for name, spec := range index.all() {
+				if name == path.Join(target, recordFile) {
+					gotRecord = &spec.planned
+				}
+			}
+			if diff := cmp.Diff(test.wantRecord, gotRecord); diff != "" {
+				t.Errorf("planned record state:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRendererRendered(t *testing.T) {
+	const (
+		source = "home/user/source"
+		target = "home/user"
+	)
+	tests := []struct {
+		desc        string
+		targetState file.State // The planned state of the target item.
+		targetData  *string    // The content of the target file, if any.
+		want        bool
+	}{
+		{
+			desc:        "target holds rendered content",
+			targetState: file.FileState(),
+			targetData:  ptr("host = build01"),
+			want:        true,
+		},
+		{
+			desc:        "target holds other content",
+			targetState: file.FileState(),
+			targetData:  ptr("host = other"),
+			want:        false,
+		},
+		{
+			desc:        "no target file",
+			targetState: file.NoFileState(),
+			want:        false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			testFS := errfs.New()
+			errfs.Add(testFS, sourceDir(source))
+			errfs.Add(testFS, errfs.NewFileData(path.Join(source, "pkg/.gitconfig.tmpl"), 0o644,
+				[]byte("host = {{.Facts.host}}")))
+			if test.targetData != nil {
+				errfs.Add(testFS, errfs.NewFileData(path.Join(target, ".gitconfig"), 0o644,
+					[]byte(*test.targetData)))
+			}
+
+			r := newRenderer(testFS, System{Facts: facts.Facts{facts.Host: "build01"}})
+			s := newSourceItem(source, "pkg", ".gitconfig.tmpl", file.TypeFile)
+			tgt := newTargetItem(target, ".gitconfig", test.targetState)
+
+			got, err := r.rendered(s, tgt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("rendered: got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
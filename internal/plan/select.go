@@ -0,0 +1,120 @@
+package plan
+
+import (
+	"path"
+	"strings"
+)
+
+// A selection describes whether and how to analyze an item.
+type selection int
+
+const (
+	// Analyze the item and its contents.
+	selectItem selection = iota
+
+	// Analyze the item as the parent of selected items.
+	selectParent
+
+	// Do not analyze the item or its contents.
+	selectNone
+)
+
+// A selector selects the items to analyze.
+type selector interface {
+	// selection returns the selection for the item.
+	selection(item string) selection
+}
+
+// selectors combines the selections of several selectors.
+// An item is selected only as far as every selector selects it.
+type selectors []selector
+
+func (ss selectors) selection(item string) selection {
+	sel := selectItem
+	for _, s := range ss {
+		sel = max(sel, s.selection(item))
+	}
+	return sel
+}
+
+// subtreeSelector selects an item, its contents, and its ancestors.
+type subtreeSelector string
+
+func (s subtreeSelector) selection(item string) selection {
+	root := string(s)
+	switch {
+	case item == root || strings.HasPrefix(item, root+"/"):
+		return selectItem
+	case strings.HasPrefix(root, item+"/"):
+		return selectParent
+	}
+	return selectNone
+}
+
+// A patternSelector selects items by include and exclude patterns.
+type patternSelector struct {
+	include []string // If not empty, select only items that match one of these.
+	exclude []string // Do not select items that match any of these.
+}
+
+func (ps *patternSelector) selection(item string) selection {
+	switch {
+	case matchesAny(ps.exclude, item):
+		return selectNone
+	case len(ps.include) == 0 || matchesAny(ps.include, item):
+		return selectItem
+	case mayContainMatch(ps.include, item):
+		return selectParent
+	}
+	return selectNone
+}
+
+// matchesAny reports whether item or any of its ancestors
+// matches any of the patterns.
+// A pattern that contains a slash matches the whole path.
+// A pattern without a slash matches the base name.
+func matchesAny(patterns []string, item string) bool {
+	for _, pattern := range patterns {
+		for p := item; p != "."; p = path.Dir(p) {
+			name := p
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(p)
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mayContainMatch reports whether a dir named item
+// may contain a file that matches any of the patterns.
+func mayContainMatch(patterns []string, item string) bool {
+	itemNames := strings.Split(item, "/")
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			// The pattern may match a base name at any depth.
+			return true
+		}
+		patternNames := strings.Split(pattern, "/")
+		if len(patternNames) <= len(itemNames) {
+			continue
+		}
+		if matchesNames(patternNames, itemNames) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNames reports whether each name
+// matches the corresponding pattern.
+func matchesNames(patterns, names []string) bool {
+	for i, name := range names {
+		if ok, _ := path.Match(patterns[i], name); !ok {
+			return false
+		}
+	}
+	return true
+}
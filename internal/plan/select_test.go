@@ -0,0 +1,109 @@
+package plan
+
+import "testing"
+
+func TestPatternSelector(t *testing.T) {
+	tests := []struct {
+		desc    string
+		include []string
+		exclude []string
+		item    string
+		want    selection
+	}{
+		{
+			desc: "no patterns",
+			item: "dir/item",
+			want: selectItem,
+		},
+		{
+			desc:    "item matches exclude base name",
+			exclude: []string{".DS_Store"},
+			item:    "dir/.DS_Store",
+			want:    selectNone,
+		},
+		{
+			desc:    "ancestor matches exclude path",
+			exclude: []string{".config/gtk-*"},
+			item:    ".config/gtk-3.0/settings.ini",
+			want:    selectNone,
+		},
+		{
+			desc:    "exclude path does not match base name",
+			exclude: []string{"dir/item"},
+			item:    "other/dir/item",
+			want:    selectItem,
+		},
+		{
+			desc:    "item matches include",
+			include: []string{".config/nvim"},
+			item:    ".config/nvim",
+			want:    selectItem,
+		},
+		{
+			desc:    "ancestor matches include",
+			include: []string{".config/nvim"},
+			item:    ".config/nvim/init.lua",
+			want:    selectItem,
+		},
+		{
+			desc:    "item may contain include path match",
+			include: []string{".config/nvim"},
+			item:    ".config",
+			want:    selectParent,
+		},
+		{
+			desc:    "item may contain include base name match",
+			include: []string{"*.lua"},
+			item:    ".config/nvim",
+			want:    selectParent,
+		},
+		{
+			desc:    "item cannot contain include match",
+			include: []string{".config/nvim"},
+			item:    ".local",
+			want:    selectNone,
+		},
+		{
+			desc:    "item is deeper than include path",
+			include: []string{".config/nvim"},
+			item:    ".config/gtk-3.0/settings.ini",
+			want:    selectNone,
+		},
+		{
+			desc:    "exclude wins over include",
+			include: []string{".config"},
+			exclude: []string{"gtk-*"},
+			item:    ".config/gtk-3.0",
+			want:    selectNone,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ps := &patternSelector{include: test.include, exclude: test.exclude}
+			got := ps.selection(test.item)
+			if got != test.want {
+				t.Errorf("selection(%q): got %d, want %d", test.item, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSelectors(t *testing.T) {
+	ss := selectors{
+		subtreeSelector(".config/nvim"),
+		&patternSelector{exclude: []string{"*.bak"}},
+	}
+	tests := map[string]selection{
+		".config":                   selectParent,
+		".config/nvim":              selectItem,
+		".config/nvim/init.lua":     selectItem,
+		".config/nvim/init.lua.bak": selectNone,
+		".config/gtk-3.0":           selectNone,
+	}
+	for item, want := range tests {
+		got := ss.selection(item)
+		if got != want {
+			t.Errorf("selection(%q): got %d, want %d", item, got, want)
+		}
+	}
+}
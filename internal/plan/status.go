@@ -0,0 +1,91 @@
+package plan
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+
+	"github.com/dhemery/duffel/internal/file"
+)
+
+// The statuses of installable items.
+const (
+	statusInstalled = "installed" // The target item provides the source item.
+	statusMissing   = "missing"   // There is no target item.
+	statusConflict  = "conflict"  // The target item is a file that does not provide the source item.
+)
+
+type statusRenderer interface {
+	rendered(sourceItem, targetItem) (bool, error)
+}
+
+func newInspector(renderer statusRenderer, fsys fs.ReadLinkFS) *inspector {
+	return &inspector{
+		renderer: renderer,
+		fsys:     fsys,
+		items:    map[string]string{},
+	}
+}
+
+// inspector records whether each given source item file
+// is installed at the corresponding target item file.
+// It leaves the state of each target item unchanged.
+type inspector struct {
+	renderer statusRenderer
+	fsys     fs.ReadLinkFS     // Resolves link destinations. If nil, links are compared by their destination paths.
+	items    map[string]string // The status of each target item, by full path.
+}
+
+// analyze records the status of the source item at the target item,
+// and returns the target item's state unchanged.
+// If the source item and the target item are both dirs,
+// analyze records nothing, and the walk records the status of the dir's contents.
+func (i *inspector) analyze(_ context.Context, s sourceItem, t targetItem, _ *slog.Logger) (file.State, error) {
+	var skip error
+	if s.Type.IsDir() {
+		skip = fs.SkipDir
+	}
+
+	if isTemplate(s) {
+		rendered, err := i.renderer.rendered(s, t)
+		if err != nil {
+			return t.State, err
+		}
+		if rendered {
+			return t.State, i.record(t, statusInstalled, nil)
+		}
+	}
+
+	linked, err := linksTo(i.fsys, t, s)
+	switch {
+	case err != nil:
+		return t.State, err
+	case linked && !isTemplate(s):
+		return t.State, i.record(t, statusInstalled, skip)
+	case t.State.IsNoFile(), t.State.IsLink() && t.State.Dest.FinalType().IsNoFile():
+		return t.State, i.record(t, statusMissing, skip)
+	case t.State.IsDir() && s.Type.IsDir():
+		return t.State, nil
+	}
+	return t.State, i.record(t, statusConflict, skip)
+}
+
+// analyzeParent is the same as analyze.
+// If the target item links to the whole source dir,
+// it installs the selected contents along with the rest of the dir,
+// so analyze records the target item as installed,
+// and the walk records nothing about the dir's contents.
+func (i *inspector) analyzeParent(ctx context.Context, s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	return i.analyze(ctx, s, t, l)
+}
+
+// unfold is the same as analyze.
+func (i *inspector) unfold(ctx context.Context, s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	return i.analyze(ctx, s, t, l)
+}
+
+// record records the status of the target item, and returns err.
+func (i *inspector) record(t targetItem, status string, err error) error {
+	i.items[t.Path.String()] = status
+	return err
+}
@@ -0,0 +1,201 @@
+package plan
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/dhemery/duffel/internal/duftest"
+	"github.com/dhemery/duffel/internal/errfs"
+	"github.com/dhemery/duffel/internal/file"
+	"github.com/dhemery/duffel/internal/log"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		desc       string
+		sourceItem sourceItem    // The state of the source item.
+		targetItem targetItem    // The state of the target item as of any earlier planning.
+		renderer   *testRenderer // The renderer for the inspector to call.
+		wantStatus string        // The recorded status, or empty if none.
+		wantErr    error         // Error result.
+	}{
+		{
+			desc:       "target links to file item",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item", file.LinkState("../source/pkg/item", file.TypeFile)),
+			wantStatus: statusInstalled,
+		},
+		{
+			desc:       "target links to dir item",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.LinkState("../source/pkg/item", file.TypeDir)),
+			wantStatus: statusInstalled,
+			wantErr:    fs.SkipDir,
+		},
+		{
+			desc:       "no target file",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item", file.NoFileState()),
+			wantStatus: statusMissing,
+		},
+		{
+			desc:       "target links to nothing",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item", file.LinkState("nowhere", file.TypeNoFile)),
+			wantStatus: statusMissing,
+		},
+		{
+			desc:       "target links to another package",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item", file.LinkState("../source/other/item", file.TypeFile)),
+			wantStatus: statusConflict,
+		},
+		{
+			desc:       "target is a regular file",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item", file.FileState()),
+			wantStatus: statusConflict,
+		},
+		{
+			desc:       "target dir may hold dir item's contents",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.DirState()),
+			wantStatus: "",  // The walk records the status of the contents.
+			wantErr:    nil, // Walk the dir.
+		},
+		{
+			desc:       "target holds rendered template",
+			sourceItem: newSourceItem("source", "pkg", ".gitconfig.tmpl", file.TypeFile),
+			targetItem: newTargetItem("target", ".gitconfig", file.FileState()),
+			renderer:   &testRenderer{isRendered: true},
+			wantStatus: statusInstalled,
+		},
+		{
+			desc:       "target holds other content than rendered template",
+			sourceItem: newSourceItem("source", "pkg", ".gitconfig.tmpl", file.TypeFile),
+			targetItem: newTargetItem("target", ".gitconfig", file.FileState()),
+			renderer:   &testRenderer{},
+			wantStatus: statusConflict,
+		},
+		{
+			desc:       "target links to template",
+			sourceItem: newSourceItem("source", "pkg", ".gitconfig.tmpl", file.TypeFile),
+			targetItem: newTargetItem("target", ".gitconfig", file.LinkState("../source/pkg/.gitconfig.tmpl", file.TypeFile)),
+			renderer:   &testRenderer{},
+			wantStatus: statusConflict,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logbuf bytes.Buffer
+			logger := log.Logger(&logbuf, duftest.LogLevel)
+			defer duftest.Dump(t, "log", &logbuf)
+
+			inspect := newInspector(test.renderer, nil)
+
+			gotState, gotErr := inspect.analyze(context.Background(), test.sourceItem, test.targetItem, logger)
+
+			if diff := cmp.Diff(test.targetItem.State, gotState); diff != "" {
+				t.Errorf("state:\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantErr, gotErr, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("error:\n%s", diff)
+			}
+			if got := inspect.items[test.targetItem.Path.String()]; got != test.wantStatus {
+				t.Errorf("status: got %q, want %q", got, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestPlannerStatus(t *testing.T) {
+	tests := []struct {
+		desc       string
+		files      []*errfs.File // Files in addition to the source marker and the package's items.
+		goal       DirGoal
+		wantStatus map[string]string
+	}{
+		{
+			desc: "package",
+			files: []*errfs.File{
+				errfs.NewLink("target/.vimrc", "../source/pkg/.vimrc"),
+				errfs.NewFile("target/.bashrc", 0o644),
+			},
+			goal: StatusPackage("source", "pkg"),
+			wantStatus: map[string]string{
+				"target/.vimrc":   statusInstalled,
+				"target/.bashrc":  statusConflict,
+				"target/.config":  statusMissing,
+				"target/.profile": statusMissing,
+			},
+		},
+		{
+			desc: "item in a linked dir",
+			files: []*errfs.File{
+				errfs.NewLink("target/.config", "../source/pkg/.config"),
+			},
+			goal: StatusItem("source", "pkg", ".config/nvim/lua"),
+			wantStatus: map[string]string{
+				"target/.config": statusInstalled,
+			},
+		},
+		{
+			desc: "item in a real dir",
+			files: []*errfs.File{
+				errfs.NewLink("target/.config/nvim/lua", "../../../source/pkg/.config/nvim/lua"),
+			},
+			goal: StatusItem("source", "pkg", ".config/nvim/lua"),
+			wantStatus: map[string]string{
+				"target/.config/nvim/lua": statusInstalled,
+			},
+		},
+		{
+			desc: "contents of a dir item",
+			files: []*errfs.File{
+				errfs.NewLink("target/.config/nvim/init", "../../../source/pkg/.config/nvim/init"),
+			},
+			goal: StatusItem("source", "pkg", ".config/nvim"),
+			wantStatus: map[string]string{
+				"target/.config/nvim/init": statusInstalled,
+				"target/.config/nvim/lua":  statusMissing,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logbuf bytes.Buffer
+			logger := log.Logger(&logbuf, duftest.LogLevel)
+			defer duftest.Dump(t, "log", &logbuf)
+
+			testFS := errfs.New()
+			errfs.Add(testFS, sourceDir("source"))
+			errfs.AddFile(testFS, "source/pkg/.vimrc", 0o644)
+			errfs.AddFile(testFS, "source/pkg/.bashrc", 0o644)
+			errfs.AddFile(testFS, "source/pkg/.profile", 0o644)
+			errfs.AddFile(testFS, "source/pkg/.config/nvim/init", 0o644)
+			errfs.AddFile(testFS, "source/pkg/.config/nvim/lua/plugins", 0o644)
+			errfs.AddDir(testFS, "target", 0o755)
+			for _, f := range test.files {
+				errfs.Add(testFS, f)
+			}
+
+			planner := NewPlanner(testFS, "target", []DirGoal{test.goal}, System{}, 1, logger)
+			got, err := planner.Plan()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.wantStatus, got.Status); diff != "" {
+				t.Errorf("status:\n%s", diff)
+			}
+			if diff := cmp.Diff(Tasks{}, got.Targets["target"], cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("tasks:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package plan
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+
+	"github.com/dhemery/duffel/internal/file"
+)
+
+type uninstallRenderer interface {
+	remove(sourceItem, targetItem, *slog.Logger) (file.State, error)
+}
+
+// uninstaller describes the uninstalled state
+// of the target item file that corresponds
+// to each given source item file.
+// It removes links to the source items
+// and files rendered from source templates,
+// and leaves every other target file in place,
+// including the dirs that hold installed items.
+type uninstaller struct {
+	merger   installMerger
+	renderer uninstallRenderer
+	fsys     fs.ReadLinkFS // Resolves link destinations. If nil, links are compared by their destination paths.
+}
+
+// analyze returns the state of the target item file
+// that would result from uninstalling the source item file.
+func (u uninstaller) analyze(_ context.Context, s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	if isTemplate(s) {
+		return u.renderer.remove(s, t, l)
+	}
+
+	var skip error
+	if s.Type.IsDir() {
+		skip = fs.SkipDir
+	}
+
+	linked, err := linksTo(u.fsys, t, s)
+	if err != nil {
+		return file.State{}, err
+	}
+	if linked {
+		// Removing the link uninstalls the item and its contents.
+		l.Info("removing", slog.Any("source", s), slog.Any("target", t))
+		return file.NoFileState(), skip
+	}
+
+	if t.State.IsDir() && s.Type.IsDir() {
+		// The target dir may hold the source dir's contents.
+		// Leave the dir, and walk the source dir to uninstall its contents.
+		return t.State, nil
+	}
+
+	// The target item is not installed from the source item.
+	return t.State, skip
+}
+
+// analyzeParent returns the state of the target item file
+// that would result from uninstalling some of the contents
+// of the source item directory, but not the whole directory.
+// If the target item links to the source item,
+// the resulting state is a directory
+// that holds links to each item in the source item,
+// from which the walk then uninstalls the selected contents.
+func (u uninstaller) analyzeParent(ctx context.Context, s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	linked, err := linksTo(u.fsys, t, s)
+	if err != nil {
+		return file.State{}, err
+	}
+	if !linked {
+		return u.analyze(ctx, s, t, l)
+	}
+
+	l.Info("unfolding", slog.Any("source", s), slog.Any("target", t))
+	if err := u.merger.merge(ctx, s.Path.String(), t.Path, l); err != nil {
+		return file.State{}, err
+	}
+	return file.DirState(), nil
+}
+
+// unfold is the same as analyzeParent.
+// The walk unfolds a dir whose contents it may not uninstall all of,
+// so the target item keeps links to the contents it does not uninstall.
+func (u uninstaller) unfold(ctx context.Context, s sourceItem, t targetItem, l *slog.Logger) (file.State, error) {
+	return u.analyzeParent(ctx, s, t, l)
+}
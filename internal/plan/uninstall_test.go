@@ -0,0 +1,255 @@
+package plan
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/dhemery/duffel/internal/duftest"
+	"github.com/dhemery/duffel/internal/errfs"
+	"github.com/dhemery/duffel/internal/file"
+	"github.com/dhemery/duffel/internal/log"
+)
+
+func TestUninstall(t *testing.T) {
+	tests := []struct {
+		desc       string
+		parent     bool          // Whether to analyze the source item as a parent of selected items.
+		sourceItem sourceItem    // The state of the source item.
+		targetItem targetItem    // The state of the target item as of any earlier planning.
+		merger     *testMerger   // The merger for the uninstaller to call.
+		renderer   *testRenderer // The renderer for the uninstaller to call.
+		files      []*errfs.File // Files on the file system. If empty, the uninstaller has no file system.
+		wantState  file.State    // State result.
+		wantErr    error         // Error result.
+	}{
+		{
+			desc:       "target links to file item",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item", file.LinkState("../source/pkg/item", file.TypeFile)),
+			wantState:  file.NoFileState(),
+		},
+		{
+			desc:       "target links to dir item",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.LinkState("../source/pkg/item", file.TypeDir)),
+			wantState:  file.NoFileState(),
+			wantErr:    fs.SkipDir, // Removing the link uninstalls the dir's contents.
+		},
+		{
+			desc: "target links to item through a symlink to the source",
+			files: []*errfs.File{
+				errfs.NewLink("home/dotfiles", "../data/dotfiles"),
+				errfs.NewFile("data/dotfiles/pkg/item", 0o644),
+			},
+			sourceItem: newSourceItem("data/dotfiles", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("home", "item", file.LinkState("dotfiles/pkg/item", file.TypeFile)),
+			wantState:  file.NoFileState(),
+		},
+		{
+			desc:       "no target file",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.NoFileState()),
+			wantState:  file.NoFileState(),
+			wantErr:    fs.SkipDir,
+		},
+		{
+			desc:       "target links to another package",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item", file.LinkState("../source/other/item", file.TypeFile)),
+			wantState:  file.LinkState("../source/other/item", file.TypeFile), // Unchanged.
+		},
+		{
+			desc:       "target is a regular file",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item", file.FileState()),
+			wantState:  file.FileState(), // Unchanged.
+		},
+		{
+			desc:       "target dir may hold dir item's contents",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.DirState()),
+			wantState:  file.DirState(),
+			wantErr:    nil, // Walk the dir to uninstall its contents.
+		},
+		{
+			desc:       "target is a dir but item is not",
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeFile),
+			targetItem: newTargetItem("target", "item", file.DirState()),
+			wantState:  file.DirState(), // Unchanged.
+		},
+		{
+			desc:       "parent of selected items linked by target",
+			parent:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.LinkState("../source/pkg/item", file.TypeDir)),
+			merger:     mergeSucceeds("source/pkg/item"),
+			wantState:  file.DirState(), // Holds links to the items to keep.
+		},
+		{
+			desc:       "parent of selected items in target dir",
+			parent:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.DirState()),
+			merger:     &testMerger{},
+			wantState:  file.DirState(),
+		},
+		{
+			desc:       "parent of selected items cannot be merged",
+			parent:     true,
+			sourceItem: newSourceItem("source", "pkg", "item", file.TypeDir),
+			targetItem: newTargetItem("target", "item", file.LinkState("../source/pkg/item", file.TypeDir)),
+			merger:     mergeFails(&MergeError{Dir: "source/pkg/item", Err: errNotInstalledAt}),
+			wantErr:    &MergeError{Dir: "source/pkg/item", Err: errNotInstalledAt},
+		},
+		{
+			desc:       "rendered template",
+			sourceItem: newSourceItem("source", "pkg", ".gitconfig.tmpl", file.TypeFile),
+			targetItem: newTargetItem("target", ".gitconfig", file.FileState()),
+			renderer:   &testRenderer{state: file.NoFileState()},
+			wantState:  file.NoFileState(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logbuf bytes.Buffer
+			logger := log.Logger(&logbuf, duftest.LogLevel)
+			defer duftest.Dump(t, "log", &logbuf)
+
+			uninstall := &uninstaller{test.merger, test.renderer, nil}
+			if len(test.files) > 0 {
+				testFS := errfs.New()
+				for _, f := range test.files {
+					errfs.Add(testFS, f)
+				}
+				uninstall.fsys = testFS
+			}
+
+			analyze := uninstall.analyze
+			if test.parent {
+				analyze = uninstall.analyzeParent
+			}
+
+			gotState, gotErr := analyze(context.Background(), test.sourceItem, test.targetItem, logger)
+
+			if diff := cmp.Diff(test.wantState, gotState); diff != "" {
+				t.Errorf("state:\n%s", diff)
+			}
+			if _, ok := test.wantErr.(*MergeError); ok {
+				if diff := cmp.Diff(test.wantErr, gotErr); diff != "" {
+					t.Errorf("error:\n%s", diff)
+				}
+			} else if diff := cmp.Diff(test.wantErr, gotErr, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("error:\n%s", diff)
+			}
+
+			test.merger.checkCall(t)
+		})
+	}
+}
+
+func TestPlannerUninstall(t *testing.T) {
+	tests := []struct {
+		desc      string
+		files     []*errfs.File // Files in addition to the source marker and the package's items.
+		goal      DirGoal
+		wantTasks Tasks
+	}{
+		{
+			desc: "package",
+			files: []*errfs.File{
+				errfs.NewLink("target/.vimrc", "../source/pkg/.vimrc"),
+				errfs.NewLink("target/.config", "../source/pkg/.config"),
+				errfs.NewLink("target/.profile", "../source/other/.profile"),
+			},
+			goal: UninstallPackage("source", "pkg"),
+			wantTasks: Tasks{
+				".vimrc":  {file.RemoveAction()},
+				".config": {file.RemoveAction()},
+			},
+		},
+		{
+			desc: "package installed into existing dirs",
+			files: []*errfs.File{
+				errfs.NewLink("target/.vimrc", "../source/pkg/.vimrc"),
+				errfs.NewLink("target/.config/nvim", "../../source/pkg/.config/nvim"),
+				errfs.NewFile("target/.config/git/config", 0o644),
+			},
+			goal: UninstallPackage("source", "pkg"),
+			wantTasks: Tasks{
+				".vimrc":       {file.RemoveAction()},
+				".config/nvim": {file.RemoveAction()},
+			},
+		},
+		{
+			desc: "item in a linked dir",
+			files: []*errfs.File{
+				errfs.NewLink("target/.config", "../source/pkg/.config"),
+			},
+			goal: UninstallItem("source", "pkg", ".config/nvim/lua"),
+			wantTasks: Tasks{
+				".config":           {file.RemoveAction(), file.MkdirAction()},
+				".config/nvim":      {file.MkdirAction()},
+				".config/nvim/init": {file.SymlinkAction("../../../source/pkg/.config/nvim/init")},
+			},
+		},
+		{
+			desc: "rendered template",
+			files: []*errfs.File{
+				errfs.NewFileData("source/pkg/.gitconfig.tmpl", 0o644, []byte("rendered")),
+				errfs.NewFileData("target/.gitconfig", 0o644, []byte("rendered")),
+				errfs.NewFileData("target/.duffel-rendered", 0o644,
+					[]byte(`{".gitconfig":"`+hash("rendered")+`"}`)),
+			},
+			goal: UninstallPackage("source", "pkg"),
+			wantTasks: Tasks{
+				".gitconfig":       {file.RemoveAction()},
+				".duffel-rendered": {file.RenderAction("{}", 0o644)},
+			},
+		},
+		{
+			desc: "variant other than the one the system would install",
+			files: []*errfs.File{
+				errfs.NewFile("source/pkg/.bashrc##os.darwin", 0o644),
+				errfs.NewFile("source/pkg/.bashrc##os.linux", 0o644),
+				errfs.NewLink("target/.bashrc", "../source/pkg/.bashrc##os.darwin"),
+			},
+			goal: UninstallPackage("source", "pkg"),
+			wantTasks: Tasks{
+				".bashrc": {file.RemoveAction()},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logbuf bytes.Buffer
+			logger := log.Logger(&logbuf, duftest.LogLevel)
+			defer duftest.Dump(t, "log", &logbuf)
+
+			testFS := errfs.New()
+			errfs.Add(testFS, sourceDir("source"))
+			errfs.AddFile(testFS, "source/pkg/.vimrc", 0o644)
+			errfs.AddFile(testFS, "source/pkg/.config/nvim/init", 0o644)
+			errfs.AddFile(testFS, "source/pkg/.config/nvim/lua/plugins", 0o644)
+			errfs.AddDir(testFS, "target", 0o755)
+			for _, f := range test.files {
+				errfs.Add(testFS, f)
+			}
+
+			sys := System{Facts: map[string]string{"os": "linux"}}
+			planner := NewPlanner(testFS, "target", []DirGoal{test.goal}, sys, 1, logger)
+			got, err := planner.Plan()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.wantTasks, got.Targets["target"], cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("tasks:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package plan
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/dhemery/duffel/internal/facts"
+)
+
+// conditionSep separates an item's name from its conditions.
+// For example, an item named "name##os.linux,host.build01"
+// is a variant of "name" to install only if the os fact is linux
+// and the host fact is build01.
+const conditionSep = "##"
+
+// A condition requires a fact to have one of a set of values.
+type condition struct {
+	fact   string
+	values []string
+}
+
+// splitVariant splits a file base name into the name of the item it is a variant of,
+// and the conditions under which to install it.
+// A condition has the form fact.value.
+// Conditions that name the same fact are satisfied if the fact has any of the values.
+// Conditions that name different facts must all be satisfied.
+func splitVariant(name string) (string, []condition, error) {
+	base, list, found := strings.Cut(name, conditionSep)
+	if !found {
+		return name, nil, nil
+	}
+
+	var conds []condition
+	for c := range strings.SplitSeq(list, ",") {
+		fact, value, ok := strings.Cut(c, ".")
+		if !ok || fact == "" || value == "" {
+			return "", nil, fmt.Errorf("variant %q: condition %q: %w: want fact.value", name, c, fs.ErrInvalid)
+		}
+		i := len(conds)
+		for j, cond := range conds {
+			if cond.fact == fact {
+				i = j
+				break
+			}
+		}
+		if i == len(conds) {
+			conds = append(conds, condition{fact: fact})
+		}
+		conds[i].values = append(conds[i].values, value)
+	}
+	if base == "" {
+		return "", nil, fmt.Errorf("variant %q: %w: no item name", name, fs.ErrInvalid)
+	}
+	return base, conds, nil
+}
+
+// isVariant reports whether the base name of item has conditions.
+func isVariant(item string) bool {
+	return strings.Contains(path.Base(item), conditionSep)
+}
+
+// stripConditions returns item with the conditions removed from each component.
+func stripConditions(item string) string {
+	if !strings.Contains(item, conditionSep) {
+		return item
+	}
+	names := strings.Split(item, "/")
+	for i, name := range names {
+		names[i], _, _ = strings.Cut(name, conditionSep)
+	}
+	return strings.Join(names, "/")
+}
+
+// newVariants returns a [variants] that chooses variants
+// that satisfy the facts.
+func newVariants(fsys fs.FS, f facts.Facts) *variants {
+	return &variants{
+		fsys:   fsys,
+		facts:  f,
+		chosen: map[string]map[string]choice{},
+		items:  map[string]string{},
+	}
+}
+
+// variants chooses which variant of each item to install.
+type variants struct {
+	fsys   fs.FS
+	facts  facts.Facts
+	chosen map[string]map[string]choice // The choice for each item, by dir and item name.
+	items  map[string]string            // The chosen variant for each target item.
+}
+
+// A choice is the variant chosen for an item,
+// or the error that prevents choosing one.
+type choice struct {
+	variant string
+	err     error
+}
+
+// record records that the variant at s provides the target item at t.
+func (v *variants) record(t targetPath, s sourcePath) {
+	v.items[t.String()] = s.String()
+}
+
+// choose reports whether the named source file
+// is the variant to install for its item.
+// Among the variants whose conditions the facts satisfy,
+// the one that names the most facts is chosen.
+// A file without conditions is chosen
+// only if no variant's conditions are satisfied.
+// If any variant of the item has malformed conditions,
+// or if several variants are equally specific,
+// choose returns an error for each of the item's files.
+func (v *variants) choose(name string) (bool, error) {
+	dir, base := path.Dir(name), path.Base(name)
+	chosen, ok := v.chosen[dir]
+	if !ok {
+		var err error
+		chosen, err = v.chooseIn(dir)
+		if err != nil {
+			return false, err
+		}
+		v.chosen[dir] = chosen
+	}
+	item, _, _ := strings.Cut(base, conditionSep)
+	c := chosen[item]
+	if c.err != nil {
+		return false, c.err
+	}
+	return c.variant == base, nil
+}
+
+// chooseIn chooses the variant of each item in the named dir.
+func (v *variants) chooseIn(dir string) (map[string]choice, error) {
+	entries, err := fs.ReadDir(v.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen := map[string]choice{}
+	specificity := map[string]int{}
+	malformed := map[string]error{}
+	for _, e := range entries {
+		item, conds, err := splitVariant(e.Name())
+		if err != nil {
+			name, _, _ := strings.Cut(e.Name(), conditionSep)
+			malformed[name] = fmt.Errorf("%s: %w", dir, err)
+			continue
+		}
+		if !v.satisfied(conds) {
+			continue
+		}
+
+		prev, found := chosen[item]
+		switch n := len(conds); {
+		case !found || n > specificity[item]:
+			chosen[item] = choice{variant: e.Name()}
+			specificity[item] = n
+		case n == specificity[item] && prev.err == nil:
+			chosen[item] = choice{err: fmt.Errorf("%s: %w: variants %q and %q both satisfy the facts",
+				dir, fs.ErrInvalid, prev.variant, e.Name())}
+		}
+	}
+	for item, err := range malformed {
+		chosen[item] = choice{err: err}
+	}
+	return chosen, nil
+}
+
+// satisfied reports whether v's facts satisfy every condition.
+func (v *variants) satisfied(conds []condition) bool {
+	for _, c := range conds {
+		fact, ok := v.facts[c.fact]
+		if !ok || !slices.Contains(c.values, fact) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+package plan
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dhemery/duffel/internal/errfs"
+	"github.com/dhemery/duffel/internal/facts"
+)
+
+func TestSplitVariant(t *testing.T) {
+	tests := map[string]struct {
+		wantItem  string
+		wantConds []condition
+		wantErr   error
+	}{
+		".bashrc": {
+			wantItem: ".bashrc",
+		},
+		".bashrc##os.linux": {
+			wantItem:  ".bashrc",
+			wantConds: []condition{{"os", []string{"linux"}}},
+		},
+		".bashrc##os.linux,host.build01": {
+			wantItem: ".bashrc",
+			wantConds: []condition{
+				{"os", []string{"linux"}},
+				{"host", []string{"build01"}},
+			},
+		},
+		".bashrc##os.linux,os.darwin": {
+			wantItem:  ".bashrc",
+			wantConds: []condition{{"os", []string{"linux", "darwin"}}},
+		},
+		".bashrc##host.build01.example.com": {
+			wantItem:  ".bashrc",
+			wantConds: []condition{{"host", []string{"build01.example.com"}}},
+		},
+		".bashrc##": {
+			wantErr: fs.ErrInvalid,
+		},
+		".bashrc##linux": {
+			wantErr: fs.ErrInvalid,
+		},
+		"##os.linux": {
+			wantErr: fs.ErrInvalid,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			item, conds, err := splitVariant(name)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error: got %v, want %v", err, test.wantErr)
+			}
+			if item != test.wantItem {
+				t.Errorf("item: got %q, want %q", item, test.wantItem)
+			}
+			if diff := cmp.Diff(test.wantConds, conds, cmp.AllowUnexported(condition{})); diff != "" {
+				t.Errorf("conditions:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStripConditions(t *testing.T) {
+	tests := map[string]string{
+		".bashrc":           ".bashrc",
+		".bashrc##os.linux": ".bashrc",
+		".config##os.linux/nvim/init.lua##host.x": ".config/nvim/init.lua",
+	}
+	for item, want := range tests {
+		if got := stripConditions(item); got != want {
+			t.Errorf("stripConditions(%q): got %q, want %q", item, got, want)
+		}
+	}
+}
+
+func TestVariantsChoose(t *testing.T) {
+	const dir = "source/pkg"
+	linuxBuild := facts.Facts{facts.OS: "linux", facts.Host: "build01", facts.Distro: "debian"}
+	tests := []struct {
+		desc       string
+		facts      facts.Facts
+		files      []string
+		others     []string // Files of other items, which choose without error.
+		wantChosen []string // The files to choose. Choose no others.
+		wantErr    error
+	}{
+		{
+			desc:       "no variants",
+			facts:      linuxBuild,
+			files:      []string{".bashrc", ".profile"},
+			wantChosen: []string{".bashrc", ".profile"},
+		},
+		{
+			desc:       "satisfied variant replaces plain item",
+			facts:      linuxBuild,
+			files:      []string{".bashrc", ".bashrc##os.linux"},
+			wantChosen: []string{".bashrc##os.linux"},
+		},
+		{
+			desc:       "unsatisfied variant leaves plain item",
+			facts:      linuxBuild,
+			files:      []string{".bashrc", ".bashrc##os.darwin"},
+			wantChosen: []string{".bashrc"},
+		},
+		{
+			desc:       "more specific variant wins",
+			facts:      linuxBuild,
+			files:      []string{".bashrc##os.linux", ".bashrc##os.linux,host.build01"},
+			wantChosen: []string{".bashrc##os.linux,host.build01"},
+		},
+		{
+			desc:       "any value of a fact satisfies",
+			facts:      linuxBuild,
+			files:      []string{".bashrc##os.darwin,os.linux"},
+			wantChosen: []string{".bashrc##os.darwin,os.linux"},
+		},
+		{
+			desc:       "unknown fact is not satisfied",
+			facts:      linuxBuild,
+			files:      []string{".bashrc##class.work"},
+			wantChosen: nil,
+		},
+		{
+			desc:    "equally specific satisfied variants",
+			facts:   linuxBuild,
+			files:   []string{".bashrc##os.linux", ".bashrc##distro.debian"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc:    "malformed condition",
+			facts:   linuxBuild,
+			files:   []string{".bashrc##linux"},
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			desc:       "malformed condition spoils only its item",
+			facts:      linuxBuild,
+			files:      []string{".bashrc", ".bashrc##linux"},
+			others:     []string{".profile", ".vimrc##os.linux"},
+			wantChosen: []string{".profile", ".vimrc##os.linux"},
+			wantErr:    fs.ErrInvalid,
+		},
+		{
+			desc:       "equally specific variants spoil only their item",
+			facts:      linuxBuild,
+			files:      []string{".bashrc##os.linux", ".bashrc##distro.debian"},
+			others:     []string{".profile"},
+			wantChosen: []string{".profile"},
+			wantErr:    fs.ErrInvalid,
+		},
+		{
+			desc:       "more specific variant resolves equally specific ones",
+			facts:      linuxBuild,
+			files:      []string{".bashrc##distro.debian", ".bashrc##host.build01", ".bashrc##os.linux,host.build01"},
+			wantChosen: []string{".bashrc##os.linux,host.build01"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			testFS := errfs.New()
+			for _, f := range append(test.files, test.others...) {
+				errfs.AddFile(testFS, path.Join(dir, f), 0o644)
+			}
+			v := newVariants(testFS, test.facts)
+
+			var gotChosen []string
+			for _, f := range test.others {
+				chosen, err := v.choose(path.Join(dir, f))
+				if err != nil {
+					t.Fatalf("choose(%q) error: %v", f, err)
+				}
+				if chosen {
+					gotChosen = append(gotChosen, f)
+				}
+			}
+			for _, f := range test.files {
+				chosen, err := v.choose(path.Join(dir, f))
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("choose(%q) error: got %v, want %v", f, err, test.wantErr)
+				}
+				if chosen {
+					gotChosen = append(gotChosen, f)
+				}
+			}
+			if diff := cmp.Diff(test.wantChosen, gotChosen); diff != "" {
+				t.Errorf("chosen:\n%s", diff)
+			}
+		})
+	}
+}
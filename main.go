@@ -3,24 +3,43 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/dhemery/duffel/internal/cmd"
-	"github.com/dhemery/duffel/internal/file"
+	"github.com/dhemery/duffel/plan"
 )
 
 func main() {
-	root, err := os.OpenRoot("/")
-	if err != nil {
-		fatal(err)
-	}
 	cwd, err := os.Getwd()
 	if err != nil {
 		fatal(err)
 	}
 
-	fsys := file.NewRootFS(root)
+	sys := plan.System{
+		Facts: plan.SystemFacts(),
+		Env:   environ(),
+	}
+
+	cmd.Execute(os.Args[1:], openRoot, cwd, sys, os.Stdout, os.Stderr)
+}
 
-	cmd.Execute(os.Args[1:], fsys, cwd, os.Stdout, os.Stderr)
+// openRoot opens the file system rooted at dir.
+func openRoot(dir string) (cmd.FS, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return plan.NewRootFS(root), nil
+}
+
+// environ returns a map of the environment variables.
+func environ() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+	return env
 }
 
 func fatal(err error) {
@@ -3,19 +3,25 @@ package main
 import (
 	"bytes"
 	. "cmp"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json/v2"
-	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 
+	"github.com/dhemery/duffel/internal/config"
 	"github.com/dhemery/duffel/internal/duftest"
 	"github.com/dhemery/duffel/internal/file"
-	"github.com/dhemery/duffel/internal/plan"
+	"github.com/dhemery/duffel/plan"
 )
 
 // TestMain executes the test binary as the duffel command if
@@ -41,11 +47,12 @@ func TestDirOptions(t *testing.T) {
 	)
 
 	tests := []struct {
-		desc      string // Description of the test.
-		wd        string // The working directory in which duffel is run.
-		sourceOpt string // The value for the -source option.
-		targetOpt string // The value for the -target option.
-		wantDest  string // The desired destination for the target link.
+		desc      string   // Description of the test.
+		wd        string   // The working directory in which duffel is run.
+		sourceOpt string   // The value for the -source option.
+		targetOpt string   // The value for the -target option.
+		args      []string // Other args to pass before the package.
+		wantDest  string   // The desired destination for the target link.
 	}{
 		{
 			desc:      "Default source and target",
@@ -75,6 +82,14 @@ func TestDirOptions(t *testing.T) {
 			targetOpt: "../target", // home/user/target
 			wantDest:  filepath.Join("../source", pkg, item),
 		},
+		{
+			desc:      "Given source and target, concurrent jobs",
+			wd:        "home/user/wd",
+			sourceOpt: "../source", // home/user/source
+			targetOpt: "../target", // home/user/target
+			args:      []string{"-j", "4"},
+			wantDest:  filepath.Join("../source", pkg, item),
+		},
 	}
 
 	for _, test := range tests {
@@ -99,6 +114,7 @@ func TestDirOptions(t *testing.T) {
 			if test.targetOpt != "" {
 				args = append(args, "-target", test.targetOpt)
 			}
+			args = append(args, test.args...)
 			args = append(args, pkg)
 
 			td := testDuffel(t, wd, args...)
@@ -121,55 +137,647 @@ func TestDirOptions(t *testing.T) {
 	}
 }
 
+// TestDryRun tests the plans that the duffel command prints with the -n option.
+// The paths in each test's expected plan are relative to the root dir.
 func TestDryRun(t *testing.T) {
-	root := t.TempDir()
-	pkg := "pkg"
-	item := "item"
-	absTarget := filepath.Join(root, "home/user")
-	absSource := filepath.Join(absTarget, "source")
-	absDuffelFile := filepath.Join(absSource, file.SourceMarkerFile)
-	absSourcePkg := filepath.Join(absSource, pkg)
-	absSourcePkgItem := filepath.Join(absSourcePkg, item)
+	const (
+		defaultWD   = "home/user/source"
+		gitTemplate = "email = {{.Vars.email}}\nname = {{.Env.DUFFEL_TEST_NAME}}\n"
+		gitRendered = "email = me@example.com\nname = Me\n"
+	)
 
-	must := duftest.Must(t)
-	// Also creates target and source, which are ancestors
-	must.MkdirAll(absSourcePkgItem, 0o755)
-	must.WriteFile(absDuffelFile, []byte(absDuffelFile), 0o644)
+	tests := []struct {
+		desc         string                 // Description of the test.
+		files        []testFile             // Files to create in the root dir.
+		wd           string                 // The working dir. If empty, home/user/source, which is made a source.
+		env          map[string]string      // Environment variables. A value that starts with / is relative to the root dir.
+		image        bool                   // Whether -root names an image dir. If so, planned paths are relative to the image.
+		args         []string               // The args that follow -n.
+		wantTargets  map[string]plan.Tasks  // The planned tasks for each target dir.
+		wantProfiles map[string][]string    // The profiles that select each package.
+		wantShadows  map[string]plan.Shadow // The packages that provide and shadow each target item.
+		wantVariants map[string]string      // The variant installed at each target item.
+		wantStatus   map[string]string      // The printed status. If nil, the printed output is a plan.
+		wantErr      string                 // The error output, with the root dir removed from paths.
+	}{
+		{
+			desc:  "default source and target",
+			files: []testFile{newDir("home/user/source/pkg/item", 0o755)},
+			args:  []string{"pkg"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {"item": {file.SymlinkAction("source/pkg/item")}},
+			},
+		},
+		{
+			desc: "profile",
+			files: []testFile{
+				newFileData("home/user/source/"+file.SourceMarkerFile, 0o644,
+					`{"profiles": {"work": ["git", "@base"], "base": ["shell"]}}`),
+				newDir("home/user/source/git/git-item", 0o755),
+				newDir("home/user/source/shell/shell-item", 0o755),
+				newDir("home/user/source/tmux/tmux-item", 0o755),
+			},
+			args: []string{"@work", "tmux"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					"git-item":   {file.SymlinkAction("source/git/git-item")},
+					"shell-item": {file.SymlinkAction("source/shell/shell-item")},
+					"tmux-item":  {file.SymlinkAction("source/tmux/tmux-item")},
+				},
+			},
+			wantProfiles: map[string][]string{
+				"home/user/source/git":   {"@work"},
+				"home/user/source/shell": {"@work", "@base"},
+			},
+		},
+		{
+			desc: "uninstall package",
+			files: []testFile{
+				newFile("home/user/source/nvim/.vimrc", 0o644),
+				newFile("home/user/source/nvim/.config/nvim/init.lua", 0o644),
+				newFile("home/user/.config/git/config", 0o644),
+				newLink("home/user/.vimrc", "source/nvim/.vimrc"),
+				newLink("home/user/.config/nvim", "../source/nvim/.config/nvim"),
+			},
+			args: []string{"-uninstall", "nvim"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".vimrc":       {file.RemoveAction()},
+					".config/nvim": {file.RemoveAction()},
+				},
+			},
+		},
+		{
+			desc: "uninstall package that an installed package requires",
+			files: []testFile{
+				newFile("home/user/source/fonts/.fonts/mono.ttf", 0o644),
+				newFile("home/user/source/nvim/.vimrc", 0o644),
+				newFileData("home/user/source/nvim/"+config.PackageFile, 0o644, `{"requires": ["fonts"]}`),
+				newLink("home/user/.fonts", "source/fonts/.fonts"),
+				newLink("home/user/.vimrc", "source/nvim/.vimrc"),
+			},
+			args:    []string{"-uninstall", "fonts"},
+			wantErr: "package fonts: invalid argument: required by installed package nvim; use -force to uninstall it anyway",
+		},
+		{
+			desc: "force uninstall of package that an installed package requires",
+			files: []testFile{
+				newFile("home/user/source/fonts/.fonts/mono.ttf", 0o644),
+				newFile("home/user/source/nvim/.vimrc", 0o644),
+				newFileData("home/user/source/nvim/"+config.PackageFile, 0o644, `{"requires": ["fonts"]}`),
+				newLink("home/user/.fonts", "source/fonts/.fonts"),
+				newLink("home/user/.vimrc", "source/nvim/.vimrc"),
+			},
+			args: []string{"-uninstall", "-force", "fonts"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {".fonts": {file.RemoveAction()}},
+			},
+		},
+		{
+			desc: "uninstall package along with the package that requires it",
+			files: []testFile{
+				newFile("home/user/source/fonts/.fonts/mono.ttf", 0o644),
+				newFile("home/user/source/nvim/.vimrc", 0o644),
+				newFileData("home/user/source/nvim/"+config.PackageFile, 0o644, `{"requires": ["fonts"]}`),
+				newLink("home/user/.fonts", "source/fonts/.fonts"),
+				newLink("home/user/.vimrc", "source/nvim/.vimrc"),
+			},
+			args: []string{"-uninstall", "nvim", "fonts"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".fonts": {file.RemoveAction()},
+					".vimrc": {file.RemoveAction()},
+				},
+			},
+		},
+		{
+			desc: "item",
+			files: []testFile{
+				newDir("home/user/source/nvim/.config/nvim/lua", 0o755),
+				newFile("home/user/source/nvim/.config/nvim/init.lua", 0o644),
+				newFile("home/user/source/nvim/.vimrc", 0o644),
+			},
+			args: []string{"nvim/.config/nvim/lua"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".config":          {file.MkdirAction()},
+					".config/nvim":     {file.MkdirAction()},
+					".config/nvim/lua": {file.SymlinkAction("../../source/nvim/.config/nvim/lua")},
+				},
+			},
+		},
+		{
+			desc: "uninstall item in a linked dir",
+			files: []testFile{
+				newDir("home/user/source/nvim/.config/nvim/lua", 0o755),
+				newFile("home/user/source/nvim/.config/nvim/init.lua", 0o644),
+				newLink("home/user/.config", "source/nvim/.config"),
+			},
+			args: []string{"-uninstall", "nvim/.config/nvim/lua"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".config":               {file.RemoveAction(), file.MkdirAction()},
+					".config/nvim":          {file.MkdirAction()},
+					".config/nvim/init.lua": {file.SymlinkAction("../../source/nvim/.config/nvim/init.lua")},
+				},
+			},
+		},
+		{
+			desc: "status",
+			files: []testFile{
+				newDir("home/user/source/nvim/.config/nvim/lua", 0o755),
+				newFile("home/user/source/nvim/.vimrc", 0o644),
+				newFile("home/user/source/nvim/.profile", 0o644),
+				newLink("home/user/.vimrc", "source/nvim/.vimrc"),
+				newFile("home/user/.profile", 0o644),
+			},
+			args: []string{"-status", "nvim"},
+			wantStatus: map[string]string{
+				"home/user/.vimrc":   "installed",
+				"home/user/.profile": "conflict",
+				"home/user/.config":  "missing",
+			},
+		},
+		{
+			desc: "status of item in a linked dir",
+			files: []testFile{
+				newDir("home/user/source/nvim/.config/nvim/lua", 0o755),
+				newLink("home/user/.config", "source/nvim/.config"),
+			},
+			args: []string{"-status", "nvim/.config/nvim/lua"},
+			wantStatus: map[string]string{
+				"home/user/.config": "installed",
+			},
+		},
+		{
+			desc: "exclude unfolds linked dir",
+			files: []testFile{
+				newFile("home/user/source/home/.config/gtk-3.0/settings.ini", 0o644),
+				newFile("home/user/source/home/.config/nvim/init.lua", 0o644),
+				newLink("home/user/.config", "source/home/.config"),
+			},
+			args: []string{"-exclude", ".config/gtk-3.0", "home"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".config":      {file.RemoveAction(), file.MkdirAction()},
+					".config/nvim": {file.SymlinkAction("../source/home/.config/nvim")},
+				},
+			},
+		},
+		{
+			desc: "include skips unmatched dirs",
+			files: []testFile{
+				newFile("home/user/source/shell/.bashrc", 0o644),
+				newFile("home/user/source/shell/.config/app/deep/settings", 0o644),
+			},
+			args: []string{"-include", ".bashrc", "shell"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {".bashrc": {file.SymlinkAction("source/shell/.bashrc")}},
+			},
+		},
+		{
+			desc: "later package shadows earlier",
+			files: []testFile{
+				newFile("home/user/source/base/.gitconfig", 0o644),
+				newFile("home/user/source/host/.gitconfig", 0o644),
+			},
+			args: []string{"base", "host"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {".gitconfig": {file.SymlinkAction("source/host/.gitconfig")}},
+			},
+			wantShadows: map[string]plan.Shadow{
+				"home/user/.gitconfig": {
+					Package:  "home/user/source/host",
+					Shadowed: []string{"home/user/source/base"},
+				},
+			},
+		},
+		{
+			desc: "unlisted packages conflict",
+			files: []testFile{
+				newFile("home/user/source/a/x", 0o644),
+				newFile("home/user/source/b/x", 0o644),
+			},
+			args: []string{"-a"}, // Orders the packages by name, so neither outranks the other.
+			wantErr: `install conflict: source item "home/user/source/b/x" is file,` +
+				` target item "home/user/x" is symlink to file (source/a/x),` +
+				` provided by item "x" in package "home/user/source/a"`,
+		},
+		{
+			desc: "variant",
+			files: []testFile{
+				newFile("home/user/source/shell/.bashrc", 0o644),
+				newFile("home/user/source/shell/.bashrc##os."+runtime.GOOS, 0o644),
+				newFile("home/user/source/shell/.bashrc##os.no-such-os", 0o644),
+			},
+			args: []string{"shell"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {".bashrc": {file.SymlinkAction("source/shell/.bashrc##os." + runtime.GOOS)}},
+			},
+			wantVariants: map[string]string{
+				"home/user/.bashrc": "home/user/source/shell/.bashrc##os." + runtime.GOOS,
+			},
+		},
+		{
+			desc: "item names variants without their conditions",
+			files: []testFile{
+				newFile("home/user/source/shell/.bashrc##os."+runtime.GOOS, 0o644),
+				newFile("home/user/source/shell/.bashrc##os.no-such-os", 0o644),
+				// Does not prevent installing the other item.
+				newFile("home/user/source/shell/.profile##malformed", 0o644),
+			},
+			args: []string{"shell/.bashrc"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {".bashrc": {file.SymlinkAction("source/shell/.bashrc##os." + runtime.GOOS)}},
+			},
+			wantVariants: map[string]string{
+				"home/user/.bashrc": "home/user/source/shell/.bashrc##os." + runtime.GOOS,
+			},
+		},
+		{
+			desc: "template",
+			files: []testFile{
+				newFileData("home/user/source/"+file.SourceMarkerFile, 0o644, `{"vars": {"email": "me@example.com"}}`),
+				newFileData("home/user/source/git/.gitconfig.tmpl", 0o644, gitTemplate),
+			},
+			env:  map[string]string{"DUFFEL_TEST_NAME": "Me"},
+			args: []string{"git"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".gitconfig":       {file.RenderAction(gitRendered, 0o644)},
+					".duffel-rendered": {file.RenderAction(`{".gitconfig":"`+hash(gitRendered)+`"}`, 0o644)},
+				},
+			},
+		},
+		{
+			desc: "template with unchanged rendered output",
+			files: []testFile{
+				newFileData("home/user/source/"+file.SourceMarkerFile, 0o644, `{"vars": {"email": "me@example.com"}}`),
+				newFileData("home/user/source/git/.gitconfig.tmpl", 0o644, gitTemplate),
+				newFileData("home/user/.gitconfig", 0o644, gitRendered),
+				newFileData("home/user/.duffel-rendered", 0o644, `{".gitconfig":"`+hash(gitRendered)+`"}`),
+			},
+			env:  map[string]string{"DUFFEL_TEST_NAME": "Me"},
+			args: []string{"git"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {},
+			},
+		},
+		{
+			desc: "template with edited rendered output",
+			files: []testFile{
+				newFileData("home/user/source/"+file.SourceMarkerFile, 0o644, `{"vars": {"email": "me@example.com"}}`),
+				newFileData("home/user/source/git/.gitconfig.tmpl", 0o644, gitTemplate),
+				newFileData("home/user/.gitconfig", 0o644, "edited by hand"),
+				newFileData("home/user/.duffel-rendered", 0o644, `{".gitconfig":"`+hash(gitRendered)+`"}`),
+			},
+			env:  map[string]string{"DUFFEL_TEST_NAME": "Me"},
+			args: []string{"git"},
+			wantErr: `render conflict: target item "home/user/.gitconfig" has changed` +
+				` since it was rendered from "home/user/source/git/.gitconfig.tmpl"`,
+		},
+		{
+			desc: "executable template",
+			files: []testFile{
+				newFileData("home/user/source/scripts/bin/hello.tmpl", 0o755, "#!/bin/sh\n"),
+			},
+			args: []string{"scripts"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					"bin":              {file.MkdirAction()},
+					"bin/hello":        {file.RenderAction("#!/bin/sh\n", 0o755)},
+					".duffel-rendered": {file.RenderAction(`{"bin/hello":"`+hash("#!/bin/sh\n")+`"}`, 0o644)},
+				},
+			},
+		},
+		{
+			desc: "item names template by its source name",
+			files: []testFile{
+				newFileData("home/user/source/git/.gitconfig.tmpl", 0o644, "[user]\n"),
+				newFile("home/user/source/git/.gitignore", 0o644),
+			},
+			args: []string{"git/.gitconfig.tmpl"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".gitconfig":       {file.RenderAction("[user]\n", 0o644)},
+					".duffel-rendered": {file.RenderAction(`{".gitconfig":"`+hash("[user]\n")+`"}`, 0o644)},
+				},
+			},
+		},
+		{
+			desc: "remapped paths",
+			files: []testFile{
+				newFileData("home/user/source/tools/"+config.PackageFile, 0o644,
+					`{"paths": {"bin": ".local/bin", "config": ".config"}}`),
+				newFile("home/user/source/tools/bin/tool", 0o755),
+				newFile("home/user/source/tools/config/app/settings", 0o644),
+			},
+			args: []string{"tools"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".local":     {file.MkdirAction()},
+					".local/bin": {file.SymlinkAction("../source/tools/bin")},
+					".config":    {file.SymlinkAction("source/tools/config")},
+				},
+			},
+		},
+		{
+			desc: "remapped paths already installed",
+			files: []testFile{
+				newFileData("home/user/source/tools/"+config.PackageFile, 0o644,
+					`{"paths": {"bin": ".local/bin", "config": ".config"}}`),
+				newFile("home/user/source/tools/bin/tool", 0o755),
+				newFile("home/user/source/tools/config/app/settings", 0o644),
+				newLink("home/user/.local/bin", "../source/tools/bin"),
+				newLink("home/user/.config", "source/tools/config"),
+			},
+			args: []string{"tools"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {},
+			},
+		},
+		{
+			desc: "package target",
+			files: []testFile{
+				newDir("etc/xdg", 0o755),
+				newFileData("home/user/source/etc-tools/"+config.PackageFile, 0o644,
+					`{"target": "$DUFFEL_TEST_ETC/xdg"}`),
+				newFile("home/user/source/etc-tools/tools.conf", 0o644),
+				newFile("home/user/source/shell/.bashrc", 0o644),
+			},
+			env:  map[string]string{"DUFFEL_TEST_ETC": "/etc"},
+			args: []string{"etc-tools", "shell"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {".bashrc": {file.SymlinkAction("source/shell/.bashrc")}},
+				"etc/xdg":   {"tools.conf": {file.SymlinkAction("../../home/user/source/etc-tools/tools.conf")}},
+			},
+		},
+		{
+			desc: "expand variables and ~, and link into XDG config home",
+			files: []testFile{
+				newDir("home/user/.xdg", 0o755),
+				newFile("dotfiles/"+file.SourceMarkerFile, 0o644),
+				newFile("dotfiles/nvim/.config/nvim/init.lua", 0o644),
+			},
+			wd: ".",
+			env: map[string]string{
+				"HOME":            "/home/user",
+				"XDG_CONFIG_HOME": "/home/user/.xdg",
+				"DOTFILES":        "/dotfiles",
+			},
+			// Duffel expands the variables, not a shell.
+			args: []string{"-xdg", "-source", "$DOTFILES", "-target", "~", "nvim"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {".xdg/nvim": {file.SymlinkAction("../../../dotfiles/nvim/.config/nvim")}},
+			},
+		},
+		{
+			desc: "root, from outside the image",
+			files: []testFile{
+				newDir("image/home/user", 0o755),
+				newFile("image/dotfiles/"+file.SourceMarkerFile, 0o644),
+				newFile("image/dotfiles/shell/.bashrc", 0o644),
+			},
+			wd:    ".",
+			image: true,
+			// Absolute names are in the image.
+			args: []string{"-root", "image", "-source", "/dotfiles", "-target", "/home/user", "shell"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {".bashrc": {file.SymlinkAction("../../dotfiles/shell/.bashrc")}},
+			},
+		},
+		{
+			desc: "root, from inside the image",
+			files: []testFile{
+				newDir("image/home/user", 0o755),
+				newFile("image/dotfiles/"+file.SourceMarkerFile, 0o644),
+				newFile("image/dotfiles/shell/.bashrc", 0o644),
+			},
+			wd:    "image/dotfiles",
+			image: true,
+			// Relative names are relative to the working dir.
+			args: []string{"-root", "..", "-target", "../home/user", "shell"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {".bashrc": {file.SymlinkAction("../../dotfiles/shell/.bashrc")}},
+			},
+		},
+		{
+			desc: "root facts choose variants",
+			files: []testFile{
+				newDir("image/home/user", 0o755),
+				newFileData("image/etc/os-release", 0o644, "ID=duffel-test-distro\n"),
+				newFileData("image/etc/hostname", 0o644, "duffel-test-host\n"),
+				newFile("image/dotfiles/"+file.SourceMarkerFile, 0o644),
+				newFile("image/dotfiles/shell/.bashrc##distro.duffel-test-distro", 0o644),
+				newFile("image/dotfiles/shell/.profile##host.duffel-test-host", 0o644),
+			},
+			wd:    ".",
+			image: true,
+			args:  []string{"-root", "image", "-source", "/dotfiles", "-target", "/home/user", "shell"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".bashrc":  {file.SymlinkAction("../../dotfiles/shell/.bashrc##distro.duffel-test-distro")},
+					".profile": {file.SymlinkAction("../../dotfiles/shell/.profile##host.duffel-test-host")},
+				},
+			},
+			wantVariants: map[string]string{
+				"home/user/.bashrc":  "dotfiles/shell/.bashrc##distro.duffel-test-distro",
+				"home/user/.profile": "dotfiles/shell/.profile##host.duffel-test-host",
+			},
+		},
+		{
+			desc: "target in a source",
+			files: []testFile{
+				newFile("source/"+file.SourceMarkerFile, 0o644),
+				newFile("source/pkg/dir/item", 0o644),
+				newLink("home", "source/pkg"),
+			},
+			wd: ".",
+			// The target's parent links to the package, so installing would write into the package.
+			args:    []string{"-source", "source", "-target", "home/dir", "pkg"},
+			wantErr: "package source/pkg: invalid argument: target source/pkg/dir (source/pkg/dir) is in source source (source)",
+		},
+		{
+			desc: "merge through link chain",
+			files: []testFile{
+				newDir("home/user/source/vim/.config/vim", 0o755),
+				newDir("home/user/source/nvim/.config/nvim", 0o755),
+				// The target links to a link that links to the vim package's dir.
+				newLink("home/user/.config-vim", "source/vim/.config"),
+				newLink("home/user/.config", ".config-vim"),
+			},
+			args: []string{"nvim"},
+			wantTargets: map[string]plan.Tasks{
+				"home/user": {
+					".config":      {file.RemoveAction(), file.MkdirAction()},
+					".config/nvim": {file.SymlinkAction("../source/nvim/.config/nvim")},
+					".config/vim":  {file.SymlinkAction("../source/vim/.config/vim")},
+				},
+			},
+		},
+		{
+			desc: "target and source reached through symlinks from the working dir",
+			files: []testFile{
+				newDir("var/home/user", 0o755),
+				newFile("data/dotfiles/"+file.SourceMarkerFile, 0o644),
+				newFile("data/dotfiles/shell/.bashrc", 0o644),
+				newLink("home", "var/home"),
+				newLink("var/home/user/dotfiles", "../../../data/dotfiles"),
+			},
+			wd:   "home/user",
+			args: []string{"-target", ".", "-source", "dotfiles", "shell"},
+			wantTargets: map[string]plan.Tasks{
+				"var/home/user": {".bashrc": {file.SymlinkAction("../../../data/dotfiles/shell/.bashrc")}},
+			},
+		},
+		{
+			desc: "target and source named through symlinks",
+			files: []testFile{
+				newDir("var/home/user", 0o755),
+				newFile("data/dotfiles/"+file.SourceMarkerFile, 0o644),
+				newFile("data/dotfiles/shell/.bashrc", 0o644),
+				newLink("home", "var/home"),
+				newLink("var/home/user/dotfiles", "../../../data/dotfiles"),
+			},
+			wd: ".",
+			env: map[string]string{
+				"DUFFEL_TEST_TARGET": "/home/user",
+				"DUFFEL_TEST_SOURCE": "/home/user/dotfiles",
+			},
+			args: []string{"-target", "$DUFFEL_TEST_TARGET", "-source", "$DUFFEL_TEST_SOURCE", "shell"},
+			wantTargets: map[string]plan.Tasks{
+				"var/home/user": {".bashrc": {file.SymlinkAction("../../../data/dotfiles/shell/.bashrc")}},
+			},
+		},
+		{
+			desc: "existing link reaches item through a symlinked source",
+			files: []testFile{
+				newDir("var/home/user", 0o755),
+				newFile("data/dotfiles/"+file.SourceMarkerFile, 0o644),
+				newFile("data/dotfiles/shell/.bashrc", 0o644),
+				newLink("home", "var/home"),
+				newLink("var/home/user/dotfiles", "../../../data/dotfiles"),
+				newLink("var/home/user/.bashrc", "dotfiles/shell/.bashrc"),
+			},
+			wd:   "home/user",
+			args: []string{"-target", ".", "-source", "dotfiles", "shell"},
+			wantTargets: map[string]plan.Tasks{
+				"var/home/user": {},
+			},
+		},
+		{
+			desc: "conflict names the package that provides the target item",
+			files: []testFile{
+				newFile("home/user/source/work/.gitconfig", 0o644),
+				newFile("home/user/source/home/.gitconfig", 0o644),
+				newLink("home/user/.gitconfig", "source/work/.gitconfig"),
+			},
+			args: []string{"home"},
+			wantErr: `install conflict: source item "home/user/source/home/.gitconfig" is file,` +
+				` target item "home/user/.gitconfig" is symlink to file (source/work/.gitconfig),` +
+				` provided by item ".gitconfig" in package "home/user/source/work"`,
+		},
+	}
 
-	// default source (.) and target (..)
-	td := testDuffel(t, absSource, "-n", "pkg")
-	defer td.DumpIfTestFails()
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			root := t.TempDir()
+			wd := filepath.Join(root, Or(test.wd, defaultWD))
+			prefix := root[1:] // Planned paths are full paths without the leading /.
+			if test.image {
+				prefix = ""
+			}
+			full := func(name string) string { return filepath.Join(prefix, name) }
 
-	if err := td.Run(); err != nil {
-		t.Fatal(err)
-	}
+			must := duftest.Must(t)
+			if test.wd == "" {
+				must.MkdirAll(wd, 0o755)
+				must.WriteFile(filepath.Join(wd, file.SourceMarkerFile), []byte{}, 0o644)
+			}
+			for _, f := range test.files {
+				f.create(t, root)
+			}
+			before := listFiles(t, root)
 
-	absTargetItem := filepath.Join(absTarget, item)
-	info, err := os.Stat(absTargetItem)
-	if !errors.Is(err, fs.ErrNotExist) {
-		t.Errorf("want error %q, got %v", fs.ErrNotExist, err)
-	}
-	if err == nil && info != nil {
-		t.Error("created target item:", fs.FormatFileInfo(info))
-	}
+			td := testDuffel(t, wd, append([]string{"-n"}, test.args...)...)
+			td.Env = os.Environ()
+			for k, v := range test.env {
+				if strings.HasPrefix(v, "/") {
+					v = filepath.Join(root, v)
+				}
+				td.Env = append(td.Env, k+"="+v)
+			}
+			defer td.DumpIfTestFails()
 
-	var gotPlan plan.Plan
-	if err = json.Unmarshal(td.stdout.Bytes(), &gotPlan); err != nil {
-		t.Fatal(err)
-	}
+			err := td.Run()
 
-	wantDest, _ := filepath.Rel(absTarget, absSourcePkgItem)
-	wantPlan := plan.Plan{
-		Target: absTarget[1:],
-		Tasks: map[string]plan.Task{
-			"item": {
-				file.SymlinkAction(wantDest),
-			},
-		},
-	}
+			if diff := cmp.Diff(before, listFiles(t, root)); diff != "" {
+				t.Error("dry run changed files:", diff)
+			}
 
-	if diff := cmp.Diff(wantPlan, gotPlan); diff != "" {
-		t.Error("plan:", diff)
+			if test.wantErr != "" {
+				if err == nil {
+					t.Fatal("want error, got none")
+				}
+				gotErr := strings.TrimSpace(strings.ReplaceAll(td.stderr.String(), root[1:]+"/", ""))
+				if gotErr != test.wantErr {
+					t.Errorf("error output:\n got %q\nwant %q", gotErr, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.wantStatus != nil {
+				var gotStatus map[string]string
+				if err := json.Unmarshal(td.stdout.Bytes(), &gotStatus); err != nil {
+					t.Fatal(err)
+				}
+				wantStatus := map[string]string{}
+				for item, status := range test.wantStatus {
+					wantStatus[full(item)] = status
+				}
+				if diff := cmp.Diff(wantStatus, gotStatus); diff != "" {
+					t.Error("status:", diff)
+				}
+				return
+			}
+
+			var gotPlan plan.Plan
+			if err := json.Unmarshal(td.stdout.Bytes(), &gotPlan); err != nil {
+				t.Fatal(err)
+			}
+
+			wantPlan := plan.Plan{
+				Targets:  map[string]plan.Tasks{},
+				Profiles: map[string][]string{},
+				Shadows:  map[string]plan.Shadow{},
+				Variants: map[string]string{},
+			}
+			for target, tasks := range test.wantTargets {
+				wantPlan.Targets[full(target)] = tasks
+			}
+			for pkg, profiles := range test.wantProfiles {
+				wantPlan.Profiles[full(pkg)] = profiles
+			}
+			for item, shadow := range test.wantShadows {
+				shadow.Package = full(shadow.Package)
+				shadowed := []string{}
+				for _, pkg := range shadow.Shadowed {
+					shadowed = append(shadowed, full(pkg))
+				}
+				shadow.Shadowed = shadowed
+				wantPlan.Shadows[full(item)] = shadow
+			}
+			for item, variant := range test.wantVariants {
+				wantPlan.Variants[full(item)] = full(variant)
+			}
+
+			if diff := cmp.Diff(wantPlan, gotPlan, cmpopts.EquateEmpty()); diff != "" {
+				t.Error("plan:", diff)
+			}
+		})
 	}
 }
 
@@ -196,3 +804,65 @@ func testDuffel(t *testing.T, dir string, args ...string) *testDuffelData {
 	cmd.Stderr = &td.stderr
 	return &td
 }
+
+// A testFile describes a file for a test to create.
+type testFile struct {
+	name string      // The name of the file, relative to the test's root dir.
+	perm fs.FileMode // The permissions of a dir or regular file.
+	data string      // The content of a regular file.
+	dest string      // The destination of a symlink.
+}
+
+func newDir(name string, perm fs.FileMode) testFile {
+	return testFile{name: name, perm: fs.ModeDir | perm}
+}
+
+func newFile(name string, perm fs.FileMode) testFile {
+	return testFile{name: name, perm: perm}
+}
+
+func newFileData(name string, perm fs.FileMode, data string) testFile {
+	return testFile{name: name, perm: perm, data: data}
+}
+
+func newLink(name, dest string) testFile {
+	return testFile{name: name, perm: fs.ModeSymlink, dest: dest}
+}
+
+// create creates the file in the root dir, along with any missing parent dirs.
+func (f testFile) create(t *testing.T, root string) {
+	must := duftest.Must(t)
+	name := filepath.Join(root, f.name)
+	switch {
+	case f.perm&fs.ModeDir != 0:
+		must.MkdirAll(name, f.perm.Perm())
+	case f.perm&fs.ModeSymlink != 0:
+		must.MkdirAll(filepath.Dir(name), 0o755)
+		must.Symlink(f.dest, name)
+	default:
+		must.MkdirAll(filepath.Dir(name), 0o755)
+		must.WriteFile(name, []byte(f.data), f.perm)
+	}
+}
+
+// hash returns the hash by which duffel records rendered content.
+func hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// listFiles returns the name and mode of each file in the root dir.
+func listFiles(t *testing.T, root string) []string {
+	var files []string
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		files = append(files, fmt.Sprint(name, " ", d.Type()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
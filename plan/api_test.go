@@ -0,0 +1,132 @@
+package plan_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json/v2"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/dhemery/duffel/plan"
+)
+
+// These declarations fail to compile
+// if the signatures in the API change incompatibly.
+var (
+	_ func(fs.ReadLinkFS, string, []plan.DirGoal, plan.System, int, *slog.Logger) *plan.Planner = plan.NewPlanner
+	_ func(*plan.Planner) (plan.Plan, error)                                                    = (*plan.Planner).Plan
+	_ func(*plan.Planner) error                                                                 = (*plan.Planner).Validate
+	_ func(*plan.Planner, context.Context) (plan.Plan, error)                                   = (*plan.Planner).PlanContext
+
+	_ func(source, pkg string) plan.DirGoal                                         = plan.InstallPackage
+	_ func(source, pkg, item string) plan.DirGoal                                   = plan.InstallItem
+	_ func(source, pkg string) plan.DirGoal                                         = plan.UninstallPackage
+	_ func(source, pkg, item string) plan.DirGoal                                   = plan.UninstallItem
+	_ func(source, pkg string) plan.DirGoal                                         = plan.StatusPackage
+	_ func(source, pkg, item string) plan.DirGoal                                   = plan.StatusItem
+	_ func(plan.DirGoal, []string, []string) plan.DirGoal                           = plan.DirGoal.WithFilter
+	_ func(plan.DirGoal, []string) plan.DirGoal                                     = plan.DirGoal.WithProfiles
+	_ func(plan.DirGoal, string) plan.DirGoal                                       = plan.DirGoal.WithTarget
+	_ func(plan.DirGoal, int) plan.DirGoal                                          = plan.DirGoal.WithListed
+	_ func(plan.ActionFS, int, *slog.Logger) func(plan.Plan) error                  = plan.Execute
+	_ func(plan.ActionFS, int, *slog.Logger) func(context.Context, plan.Plan) error = plan.ExecuteContext
+	_ func(io.Writer) func(plan.Plan) error                                         = plan.Print
+	_ func(io.Writer) func(plan.Plan) error                                         = plan.PrintStatus
+	_ func(plan.Action, plan.ActionFS, string) error                                = plan.Action.Execute
+	_ func(plan.Task, plan.ActionFS, string) error                                  = plan.Task.Execute
+
+	_ func(plan.Root) plan.RootFS = plan.NewRootFS
+	_ func() plan.Facts           = plan.SystemFacts
+
+	_ plan.Root        = (*os.Root)(nil)
+	_ fs.ReadLinkFS    = plan.RootFS{}
+	_ plan.SubActionFS = plan.RootFS{}
+
+	_ error = (*plan.ConflictError)(nil)
+	_ error = (*plan.MergeError)(nil)
+	_ error = (*plan.EditedError)(nil)
+	_ error = (*plan.OrderError)(nil)
+	_ error = (*plan.CanceledError)(nil)
+
+	_ = plan.System{
+		Facts: plan.Facts{plan.FactOS: "linux"},
+		Env:   map[string]string{"HOME": "/home/user"},
+		Paths: map[string]string{".config": ".config"},
+	}
+	_ = plan.ConflictError{
+		Source:      "source/pkg/item",
+		SourceType:  plan.TypeFile,
+		Target:      "target/item",
+		TargetState: plan.State{Type: plan.TypeSymlink, Dest: plan.Dest{Path: "dest", Type: plan.TypeDir}},
+		Owner:       "source/other",
+		OwnerItem:   "item",
+	}
+)
+
+// The JSON form of a plan is read by other programs,
+// so it must not change incompatibly.
+const planJSON = `{` +
+	`"targets":{"target":{` +
+	`"dir":[{"action":"remove"},{"action":"mkdir"}],` +
+	`"dir/item":[{"action":"symlink","dest":"../../source/pkg/dir/item"}],` +
+	`"rendered":[{"action":"render","content":"text","perm":493}]` +
+	`}},` +
+	`"profiles":{"source/pkg":["@desktop"]},` +
+	`"shadows":{"target/dir/item":{"package":"source/pkg","shadowed":["source/other"]}},` +
+	`"variants":{"target/rendered":"source/pkg/rendered##os.linux"},` +
+	`"status":{"target/dir/item":"installed"}` +
+	`}`
+
+var planValue = plan.Plan{
+	Targets: map[string]plan.Tasks{
+		"target": {
+			"dir":      {{Action: "remove"}, {Action: "mkdir"}},
+			"dir/item": {{Action: "symlink", Dest: "../../source/pkg/dir/item"}},
+			"rendered": {{Action: "render", Content: "text", Perm: 0o755}},
+		},
+	},
+	Profiles: map[string][]string{"source/pkg": {"@desktop"}},
+	Shadows: map[string]plan.Shadow{
+		"target/dir/item": {Package: "source/pkg", Shadowed: []string{"source/other"}},
+	},
+	Variants: map[string]string{"target/rendered": "source/pkg/rendered##os.linux"},
+	Status:   map[string]string{"target/dir/item": "installed"},
+}
+
+func TestPrintFormat(t *testing.T) {
+	var got bytes.Buffer
+	if err := plan.Print(&got)(planValue); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(planJSON, got.String()); diff != "" {
+		t.Errorf("printed plan:\n%s", diff)
+	}
+}
+
+func TestPrintStatusFormat(t *testing.T) {
+	var got bytes.Buffer
+	if err := plan.PrintStatus(&got)(planValue); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(`{"target/dir/item":"installed"}`, got.String()); diff != "" {
+		t.Errorf("printed status:\n%s", diff)
+	}
+}
+
+func TestUnmarshalPlan(t *testing.T) {
+	var got plan.Plan
+	if err := json.Unmarshal([]byte(planJSON), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(planValue, got); diff != "" {
+		t.Errorf("unmarshaled plan:\n%s", diff)
+	}
+}
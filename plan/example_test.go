@@ -0,0 +1,166 @@
+package plan_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dhemery/duffel/plan"
+)
+
+// newRoot creates a temporary directory holding the named files,
+// and returns the directory and a [plan.RootFS] rooted at it.
+// A name that ends in a slash is a directory.
+func newRoot(names ...string) (string, plan.RootFS) {
+	dir, err := os.MkdirTemp("", "duffel-example-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range names {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(full, 0o755); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(full, nil, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dir, plan.NewRootFS(root)
+}
+
+func ExamplePlanner_Plan() {
+	dir, fsys := newRoot("source/.duffel", "source/pkg/.bashrc", "source/pkg/.config/git/config", "target/")
+	defer os.RemoveAll(dir)
+
+	goals := []plan.DirGoal{plan.InstallPackage("source", "pkg")}
+	logger := slog.New(slog.DiscardHandler)
+	planner := plan.NewPlanner(fsys, "target", goals, plan.System{}, 1, logger)
+
+	p, err := planner.Plan()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tasks := p.Targets["target"]
+	for _, item := range slices.Sorted(maps.Keys(tasks)) {
+		for _, action := range tasks[item] {
+			fmt.Println(item, action.Action, action.Dest)
+		}
+	}
+	// Output:
+	// .bashrc symlink ../source/pkg/.bashrc
+	// .config symlink ../source/pkg/.config
+}
+
+func ExamplePrint() {
+	dir, fsys := newRoot("source/.duffel", "source/pkg/.bashrc", "target/")
+	defer os.RemoveAll(dir)
+
+	goals := []plan.DirGoal{plan.InstallPackage("source", "pkg")}
+	logger := slog.New(slog.DiscardHandler)
+	planner := plan.NewPlanner(fsys, "target", goals, plan.System{}, 1, logger)
+
+	p, err := planner.Plan()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := plan.Print(os.Stdout)(p); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// {"targets":{"target":{".bashrc":[{"action":"symlink","dest":"../source/pkg/.bashrc"}]}}}
+}
+
+func ExampleExecute() {
+	dir, fsys := newRoot("source/.duffel", "source/pkg/.bashrc", "target/")
+	defer os.RemoveAll(dir)
+
+	goals := []plan.DirGoal{plan.InstallPackage("source", "pkg")}
+	logger := slog.New(slog.DiscardHandler)
+	planner := plan.NewPlanner(fsys, "target", goals, plan.System{}, 1, logger)
+
+	p, err := planner.Plan()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := plan.Execute(fsys, 1, logger)(p); err != nil {
+		log.Fatal(err)
+	}
+
+	dest, err := os.Readlink(filepath.Join(dir, "target", ".bashrc"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(dest)
+	// Output:
+	// ../source/pkg/.bashrc
+}
+
+func ExampleConflictError() {
+	dir, fsys := newRoot("source/.duffel", "source/pkg/.bashrc", "target/.bashrc")
+	defer os.RemoveAll(dir)
+
+	goals := []plan.DirGoal{plan.InstallPackage("source", "pkg")}
+	logger := slog.New(slog.DiscardHandler)
+	planner := plan.NewPlanner(fsys, "target", goals, plan.System{}, 1, logger)
+
+	_, err := planner.Plan()
+
+	var conflict *plan.ConflictError
+	if errors.As(err, &conflict) {
+		fmt.Println("source:", conflict.Source, conflict.SourceType)
+		fmt.Println("target:", conflict.Target, conflict.TargetState)
+	}
+	// Output:
+	// source: source/pkg/.bashrc file
+	// target: target/.bashrc file
+}
+
+func ExampleExecuteContext() {
+	dir, fsys := newRoot("source/.duffel", "source/pkg/.bashrc", "source/pkg/.profile", "target/")
+	defer os.RemoveAll(dir)
+
+	goals := []plan.DirGoal{plan.InstallPackage("source", "pkg")}
+	logger := slog.New(slog.DiscardHandler)
+	planner := plan.NewPlanner(fsys, "target", goals, plan.System{}, 1, logger)
+
+	p, err := planner.Plan()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Cancel the context before executing the plan,
+	// as if the user interrupted the program.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = plan.ExecuteContext(fsys, 1, logger)(ctx, p)
+
+	var canceled *plan.CanceledError
+	if errors.As(err, &canceled) {
+		fmt.Println("finished:", canceled.Finished["target"])
+		fmt.Println(errors.Is(err, context.Canceled))
+	}
+	// Output:
+	// finished: []
+	// true
+}
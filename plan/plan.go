@@ -0,0 +1,215 @@
+// Package plan plans and executes the changes
+// that install duffel packages into target trees.
+//
+// A [Planner] analyzes a set of [DirGoal] values
+// against a caller-supplied file system
+// and returns a [Plan].
+// The plan maps each target tree to the [Tasks] that change it,
+// and each task lists the [Action] values to apply to an item.
+// [Execute] applies a plan's tasks in an [ActionFS],
+// and [Print] writes a plan as JSON.
+//
+// Names in the file systems are slash-separated paths
+// relative to the root of the file system,
+// as described by [fs.ValidPath].
+//
+// Errors that describe why a goal cannot be planned
+// include [*ConflictError], [*MergeError], [*EditedError], and [*OrderError].
+// Use [errors.As] to inspect them.
+package plan
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+
+	"github.com/dhemery/duffel/internal/facts"
+	"github.com/dhemery/duffel/internal/file"
+	"github.com/dhemery/duffel/internal/plan"
+)
+
+type (
+	// A Planner plans how to realize a set of goals in one or more target trees.
+	Planner = plan.Planner
+
+	// A DirGoal identifies a goal for the items in a package or package directory.
+	DirGoal = plan.DirGoal
+
+	// A System describes the system on which to install packages.
+	System = plan.System
+
+	// Facts maps the name of each known fact about a system to its value.
+	Facts = facts.Facts
+
+	// A Plan is a collection of tasks
+	// to bring one or more target file trees to the desired state.
+	Plan = plan.Plan
+
+	// Tasks maps each item in a target tree to the task that changes it.
+	Tasks = plan.Tasks
+
+	// A Task is the sequence of actions that changes an item.
+	Task = plan.Task
+
+	// An Action describes a change to make to a file.
+	Action = file.Action
+
+	// A Shadow describes a target item that several packages provide.
+	Shadow = plan.Shadow
+
+	// An ActionFS provides methods to execute actions in a file system.
+	ActionFS = file.ActionFS
+
+	// A SubActionFS is an [ActionFS] that can confine changes to a subtree.
+	// Execute confines each target tree's tasks to the tree
+	// using the Sub method if the file system implements it.
+	SubActionFS = file.SubActionFS
+
+	// A Root can supply the file systems for a [RootFS].
+	// An [*os.Root] is a Root.
+	Root = file.Root
+
+	// A RootFS implements [fs.ReadLinkFS] and [SubActionFS] by delegating to a [Root].
+	RootFS = file.RootFS
+
+	// A State describes the existing or planned state of a file.
+	State = file.State
+
+	// A Dest describes the destination of a symlink.
+	Dest = file.Dest
+
+	// A Type is the type of an existing or planned file.
+	Type = file.Type
+)
+
+// Names of the facts that [SystemFacts] provides.
+const (
+	FactArch   = facts.Arch   // The machine architecture, as reported by [runtime.GOARCH].
+	FactDistro = facts.Distro // The ID of the operating system distribution, from /etc/os-release.
+	FactHost   = facts.Host   // The short host name, without the domain.
+	FactOS     = facts.OS     // The operating system, as reported by [runtime.GOOS].
+)
+
+// The types of files.
+const (
+	TypeUnknown = file.TypeUnknown // Unknown file type.
+	TypeNoFile  = file.TypeNoFile  // The file does not exist.
+	TypeFile    = file.TypeFile    // The file is a regular file.
+	TypeDir     = file.TypeDir     // The file is a directory.
+	TypeSymlink = file.TypeSymlink // The file is a symbolic link.
+)
+
+type (
+	// A ConflictError indicates that a source item conflicts with a target item
+	// and cannot be installed.
+	ConflictError = plan.ConflictError
+
+	// A MergeError indicates that the target item links to a directory
+	// whose previously installed items cannot be merged
+	// into the directory being installed.
+	MergeError = plan.MergeError
+
+	// An EditedError indicates that a target item file
+	// has changed since duffel rendered it.
+	EditedError = plan.EditedError
+
+	// An OrderError indicates that the tasks in a plan
+	// cannot be ordered to satisfy their dependencies.
+	OrderError = plan.OrderError
+
+	// A CanceledError indicates that the execution of a plan was canceled
+	// before every task was executed.
+	// It lists the tasks that finished.
+	CanceledError = plan.CanceledError
+)
+
+// NewPlanner returns a new [Planner]
+// that plans how to achieve goals in the file tree rooted at target,
+// or in the tree rooted at each goal's own target.
+// The planner reads source and target files from fsys,
+// reads the states of up to jobs target files concurrently,
+// and logs its analysis to l.
+func NewPlanner(fsys fs.ReadLinkFS, target string, goals []DirGoal, sys System, jobs int, l *slog.Logger) *Planner {
+	return plan.NewPlanner(fsys, target, goals, sys, jobs, l)
+}
+
+// InstallPackage returns a [DirGoal] to install the items in a package.
+// Source is the path to the source directory that contains the package.
+func InstallPackage(source, pkg string) DirGoal {
+	return plan.InstallPackage(source, pkg)
+}
+
+// InstallItem returns a [DirGoal] to install a single item in a package.
+// If the item is a directory, the goal installs its contents.
+// If item is empty, the goal installs the whole package.
+func InstallItem(source, pkg, item string) DirGoal {
+	return plan.InstallItem(source, pkg, item)
+}
+
+// UninstallPackage returns a [DirGoal] to uninstall the items in a package.
+// Source is the path to the source directory that contains the package.
+func UninstallPackage(source, pkg string) DirGoal {
+	return plan.UninstallPackage(source, pkg)
+}
+
+// UninstallItem returns a [DirGoal] to uninstall a single item in a package.
+// The goal removes the links to the item and its contents
+// and the files rendered from its templates,
+// and leaves directories in place.
+// If item is empty, the goal uninstalls the whole package.
+func UninstallItem(source, pkg, item string) DirGoal {
+	return plan.UninstallItem(source, pkg, item)
+}
+
+// StatusPackage returns a [DirGoal] to report
+// whether each item in a package is installed.
+func StatusPackage(source, pkg string) DirGoal {
+	return plan.StatusPackage(source, pkg)
+}
+
+// StatusItem returns a [DirGoal] to report
+// whether a single item in a package is installed.
+// The goal records the status of the item or its contents
+// in the plan's Status field, and changes nothing in the target tree.
+// If item is empty, the goal reports the status of the whole package.
+func StatusItem(source, pkg, item string) DirGoal {
+	return plan.StatusItem(source, pkg, item)
+}
+
+// Execute returns a function that executes its [Plan] argument in fsys,
+// running up to jobs independent tasks concurrently.
+func Execute(fsys ActionFS, jobs int, l *slog.Logger) func(p Plan) error {
+	return plan.Execute(fsys, jobs, l)
+}
+
+// ExecuteContext is like [Execute],
+// but the returned function starts no more tasks once ctx is done.
+// If ctx is done before every task has started,
+// the function waits for the started tasks to finish
+// and returns an error that includes a [*CanceledError].
+func ExecuteContext(fsys ActionFS, jobs int, l *slog.Logger) func(ctx context.Context, p Plan) error {
+	return plan.ExecuteContext(fsys, jobs, l)
+}
+
+// Print returns a function that writes its [Plan] argument to w as JSON.
+func Print(w io.Writer) func(p Plan) error {
+	return plan.Print(w)
+}
+
+// PrintStatus returns a function that writes the Status field
+// of its [Plan] argument to w as JSON.
+func PrintStatus(w io.Writer) func(p Plan) error {
+	return plan.PrintStatus(w)
+}
+
+// NewRootFS returns a [RootFS] that delegates to r.
+func NewRootFS(r Root) RootFS {
+	return file.NewRootFS(r)
+}
+
+// SystemFacts returns the facts about the running system.
+// A fact that cannot be determined is omitted.
+func SystemFacts() Facts {
+	return facts.System()
+}